@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// annotationContext is the context of the build annotation of the step, a new annotation with the same context
+// replaces the previous one.
+const annotationContext = "google-play-deploy"
+
+// annotationMarkdown returns the markdown of the build annotation of the deploy result: the releases of the packages
+// and their apps as tables.
+func annotationMarkdown(summary deploySummary) string {
+	var b strings.Builder
+	if summary.Succeeded {
+		b.WriteString("**Google Play deploy succeeded**\n\n")
+	} else {
+		b.WriteString("**Google Play deploy failed**\n\n")
+	}
+
+	b.WriteString("| Package | Operation | Tracks | Version codes | Status | Rollout | Result |\n")
+	b.WriteString("| --- | --- | --- | --- | --- | --- | --- |\n")
+	var artifacts []string
+	for _, packageSummary := range summary.Packages {
+		var versionCodes []string
+		for _, versionCode := range packageSummary.VersionCodes {
+			versionCodes = append(versionCodes, fmt.Sprintf("%d", versionCode))
+		}
+		rollout := ""
+		if packageSummary.UserFraction > 0 {
+			rollout = fmt.Sprintf("%v%%", math.Round(packageSummary.UserFraction*10000)/100)
+		}
+		result := "published"
+		if packageSummary.Error != "" {
+			result = "failed: " + packageSummary.ErrorCategory
+		}
+		b.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s | %s | %s |\n", packageSummary.PackageName, packageSummary.Operation,
+			strings.Join(packageSummary.Tracks, ", "), strings.Join(versionCodes, ", "), packageSummary.Status, rollout, result))
+
+		for _, artifact := range packageSummary.Artifacts {
+			artifacts = append(artifacts, fmt.Sprintf("| %s | %d | `%s` |\n", filepath.Base(artifact.Path), artifact.VersionCode, artifact.Sha256))
+		}
+	}
+
+	if len(artifacts) > 0 {
+		b.WriteString("\n| App | Version code | SHA-256 |\n")
+		b.WriteString("| --- | --- | --- |\n")
+		for _, artifact := range artifacts {
+			b.WriteString(artifact)
+		}
+	}
+	return b.String()
+}
+
+// annotateBuild adds the deploy result to the Bitrise build page as an annotation, with the annotations plugin of
+// the Bitrise CLI.
+func annotateBuild(summary deploySummary) error {
+	style := "success"
+	if !summary.Succeeded {
+		style = "error"
+	}
+	cmd := exec.Command("bitrise", ":annotations", "annotate", annotationMarkdown(summary), "--style", style, "--context", annotationContext)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to annotate build, error: %s, output: %s", err, out)
+	}
+	return nil
+}
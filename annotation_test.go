@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_annotationMarkdown(t *testing.T) {
+	summary := deploySummary{
+		Succeeded: true,
+		Packages: []packageSummary{{
+			PackageName:  "io.bitrise.sample",
+			Operation:    operationDeploy,
+			Tracks:       []string{"production"},
+			Status:       releaseStatusInProgress,
+			UserFraction: 0.07,
+			VersionCodes: []int64{42},
+			Artifacts:    []artifactSummary{{Path: "/tmp/app-release.aab", Sha256: "aa", VersionCode: 42}},
+		}},
+	}
+	require.Equal(t, `**Google Play deploy succeeded**
+
+| Package | Operation | Tracks | Version codes | Status | Rollout | Result |
+| --- | --- | --- | --- | --- | --- | --- |
+| io.bitrise.sample | deploy | production | 42 | inProgress | 7% | published |
+
+| App | Version code | SHA-256 |
+| --- | --- | --- |
+| app-release.aab | 42 | `+"`aa`"+` |
+`, annotationMarkdown(summary))
+
+	failed := deploySummary{Packages: []packageSummary{{
+		PackageName:   "io.bitrise.sample",
+		Operation:     operationPromote,
+		Tracks:        []string{"beta"},
+		Error:         "Failed to promote release",
+		ErrorCategory: errorCategoryTrackConflict.name,
+	}}}
+	require.Equal(t, `**Google Play deploy failed**
+
+| Package | Operation | Tracks | Version codes | Status | Rollout | Result |
+| --- | --- | --- | --- | --- | --- | --- |
+| io.bitrise.sample | promote | beta |  |  |  | failed: track_conflict |
+`, annotationMarkdown(failed))
+}
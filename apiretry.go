@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	apiRetryBaseDelay = 2 * time.Second
+	apiRetryMaxDelay  = 32 * time.Second
+	// apiRetryMaxRetryAfter is the longest wait requested by Google Play that the step waits before retrying, a
+	// longer wait means the quota is exhausted for the day.
+	apiRetryMaxRetryAfter = 5 * time.Minute
+)
+
+var (
+	// rateLimitReasonPattern matches the reason of the rate limited responses, which Google Play may return with
+	// status code 403 instead of 429.
+	rateLimitReasonPattern = regexp.MustCompile(`"reason"\s*:\s*"(rateLimitExceeded|userRateLimitExceeded)"`)
+	// retryDelayPattern matches the retry delay of the RetryInfo detail of the error responses.
+	retryDelayPattern = regexp.MustCompile(`"retryDelay"\s*:\s*"([0-9.]+s)"`)
+)
+
+// serverErrorStatusCodes are the status codes of the transient Google Play server failures.
+var serverErrorStatusCodes = []int{
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+}
+
+// idempotentMethods are the HTTP methods which can be resent after a server failure. A POST (like edits.insert or
+// edits.commit) may have succeeded on the server despite the failed response, so resending it could create another
+// edit or fail on the already committed edit.
+var idempotentMethods = []string{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// isRetryableResponse returns true if the response of the given request method, status code and body is a transient
+// failure which can be retried. Rate limited requests were not processed, so those are retried for every method.
+func isRetryableResponse(method string, statusCode int, body []byte) bool {
+	if statusCode == http.StatusTooManyRequests || (statusCode == http.StatusForbidden && rateLimitReasonPattern.Match(body)) {
+		return true
+	}
+	if !containsString(idempotentMethods, method) {
+		return false
+	}
+	for _, code := range serverErrorStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter returns the wait requested by the given response in its Retry-After header (in seconds or as a date) or
+// in the retry delay of its error details, 0 if the response does not request a wait.
+func retryAfter(header http.Header, body []byte, now time.Time) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(value); err == nil && date.After(now) {
+			return date.Sub(now)
+		}
+	}
+	if match := retryDelayPattern.FindSubmatch(body); match != nil {
+		if delay, err := time.ParseDuration(string(match[1])); err == nil && delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// retryTransport retries the requests failed with a transient error, with exponential backoff and jitter.
+type retryTransport struct {
+	base        http.RoundTripper
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+func newRetryTransport(base http.RoundTripper, maxAttempts int) retryTransport {
+	return retryTransport{base: base, maxAttempts: maxAttempts, baseDelay: apiRetryBaseDelay, maxDelay: apiRetryMaxDelay}
+}
+
+// backoff returns the wait before the given retry: the base delay doubled on every retry up to the max delay, of
+// which a random half is waited to spread the retries of the parallel deploys.
+func (t retryTransport) backoff(retry int) time.Duration {
+	delay := t.baseDelay
+	for i := 1; i < retry && delay < t.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// RoundTrip sends the given request with the base transport, and resends it until it succeeds, fails with an error
+// that is not transient, or the max attempts are reached. The wait requested by a rate limited response is respected
+// instead of the backoff. Requests with a body that can not be recreated are sent only once.
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attemptReq := req
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil || resp.StatusCode < http.StatusBadRequest || attempt >= t.maxAttempts {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		// The body of the error response is read to find the rate limiting details, then restored for the caller.
+		body, err := ioutil.ReadAll(resp.Body)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Debugf("Failed to close response body, error: %s", closeErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if !isRetryableResponse(req.Method, resp.StatusCode, body) {
+			return resp, nil
+		}
+
+		delay := t.backoff(attempt)
+		if requested := retryAfter(resp.Header, body, time.Now()); requested > apiRetryMaxRetryAfter {
+			log.Warnf("%s %s failed with status code %d and Google Play requested to retry in %s, giving up", req.Method, req.URL.Path, resp.StatusCode, requested)
+			return resp, nil
+		} else if requested > 0 {
+			delay = requested
+		}
+		log.Warnf("%s %s failed with status code %d, retrying in %s (%d/%d)", req.Method, req.URL.Path, resp.StatusCode, delay.Round(time.Millisecond), attempt, t.maxAttempts-1)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		attemptReq = req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_retryTransport_RoundTrip(t *testing.T) {
+	rateLimited := `{"error": {"code": 403, "errors": [{"reason": "rateLimitExceeded"}]}}`
+	permissionDenied := `{"error": {"code": 403, "errors": [{"reason": "forbidden"}]}}`
+
+	tests := []struct {
+		name         string
+		method       string
+		statusCodes  []int
+		errorBody    string
+		retryAfter   string
+		maxAttempts  int
+		wantStatus   int
+		wantRequests int
+	}{
+		{"succeeds", http.MethodPut, []int{http.StatusOK}, "", "", 3, http.StatusOK, 1},
+		{"retries transient failures", http.MethodPut, []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, "", "", 3, http.StatusOK, 3},
+		{"stops at max attempts", http.MethodPut, []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusOK}, "", "", 2, http.StatusBadGateway, 2},
+		{"does not retry client errors", http.MethodPut, []int{http.StatusForbidden, http.StatusOK}, permissionDenied, "", 3, http.StatusForbidden, 1},
+		{"retries rate limited forbidden", http.MethodPut, []int{http.StatusForbidden, http.StatusOK}, rateLimited, "", 3, http.StatusOK, 2},
+		{"respects retry after", http.MethodPut, []int{http.StatusTooManyRequests, http.StatusOK}, "", "1", 3, http.StatusOK, 2},
+		{"gives up on long retry after", http.MethodPut, []int{http.StatusTooManyRequests, http.StatusOK}, "", "3600", 3, http.StatusTooManyRequests, 1},
+		{"does not retry non-idempotent server errors", http.MethodPost, []int{http.StatusServiceUnavailable, http.StatusOK}, "", "", 3, http.StatusServiceUnavailable, 1},
+		{"retries non-idempotent rate limited", http.MethodPost, []int{http.StatusTooManyRequests, http.StatusOK}, "", "", 3, http.StatusOK, 2},
+		{"retries non-idempotent rate limited forbidden", http.MethodPost, []int{http.StatusForbidden, http.StatusOK}, rateLimited, "", 3, http.StatusOK, 2},
+		{"single attempt", http.MethodPut, []int{http.StatusInternalServerError, http.StatusOK}, "", "", 1, http.StatusInternalServerError, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				bodies = append(bodies, string(body))
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.statusCodes[len(bodies)-1])
+				if _, err := w.Write([]byte(tt.errorBody)); err != nil {
+					t.Fatal(err)
+				}
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: retryTransport{base: http.DefaultTransport, maxAttempts: tt.maxAttempts, baseDelay: time.Millisecond, maxDelay: 4 * time.Millisecond}}
+			req, err := http.NewRequest(tt.method, server.URL, strings.NewReader(`{"id":"edit-id"}`))
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			respBody, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			if tt.wantStatus != http.StatusOK {
+				require.Equal(t, tt.errorBody, string(respBody))
+			}
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			require.Equal(t, tt.wantRequests, len(bodies))
+			for _, body := range bodies {
+				require.Equal(t, `{"id":"edit-id"}`, body)
+			}
+		})
+	}
+}
+
+func Test_retryTransport_backoff(t *testing.T) {
+	transport := newRetryTransport(http.DefaultTransport, 5)
+	tests := []struct {
+		retry int
+		min   time.Duration
+		max   time.Duration
+	}{
+		{1, time.Second, 2 * time.Second},
+		{2, 2 * time.Second, 4 * time.Second},
+		{3, 4 * time.Second, 8 * time.Second},
+		{10, 16 * time.Second, 32 * time.Second},
+	}
+	for _, tt := range tests {
+		delay := transport.backoff(tt.retry)
+		require.True(t, delay >= tt.min && delay <= tt.max, "retry %d: %s not in [%s, %s]", tt.retry, delay, tt.min, tt.max)
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		want   time.Duration
+	}{
+		{"seconds", "30", "", 30 * time.Second},
+		{"date", "Sun, 01 Jan 2023 12:01:00 GMT", "", time.Minute},
+		{"past date", "Sun, 01 Jan 2023 11:00:00 GMT", "", 0},
+		{"retry info", "", `{"error": {"details": [{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "12.5s"}]}}`, 12500 * time.Millisecond},
+		{"invalid", "soon", "", 0},
+		{"none", "", `{"error": {"code": 429}}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("Retry-After", tt.header)
+			}
+			require.Equal(t, tt.want, retryAfter(header, []byte(tt.body), now))
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// isUploadRequest returns true if the given request uploads a file, those are limited by the upload_timeout instead.
+func isUploadRequest(req *http.Request) bool {
+	return strings.Contains(req.URL.Path, "/upload/")
+}
+
+// timeoutTransport fails the requests, apart from the uploads, which do not finish within the timeout.
+type timeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+// RoundTrip sends the given request with the base transport. The timeout covers reading the response body too, so
+// it is canceled when the body is closed.
+func (t timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 || isUploadRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelReadCloser cancels the context of the request when the response body is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
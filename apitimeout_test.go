@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_timeoutTransport_RoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") == "true" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		if _, err := w.Write([]byte(`{"id":"edit-id"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		timeout time.Duration
+		wantErr bool
+	}{
+		{"fast call", "/androidpublisher/v3/applications/io.bitrise.sample/edits", 100 * time.Millisecond, false},
+		{"slow call", "/androidpublisher/v3/applications/io.bitrise.sample/edits?slow=true", 100 * time.Millisecond, true},
+		{"slow upload", "/upload/androidpublisher/v3/applications/io.bitrise.sample/edits/1/bundles?slow=true", 100 * time.Millisecond, false},
+		{"no timeout", "/androidpublisher/v3/applications/io.bitrise.sample/edits?slow=true", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: timeoutTransport{base: http.DefaultTransport, timeout: tt.timeout}}
+			resp, err := client.Get(server.URL + tt.path)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, `{"id":"edit-id"}`, string(body))
+		})
+	}
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// remoteAppsDirName is the name of the directory (in the system's temp dir) where the remote apps are downloaded to.
+const remoteAppsDirName = "google-play-deploy-apps"
+
+const (
+	appSha256EnvKey  = "GOOGLE_PLAY_APP_SHA256"
+	playSha256EnvKey = "GOOGLE_PLAY_REPORTED_SHA256"
+
+	deobfuscationFilesEnvKey = "GOOGLE_PLAY_DEOBFUSCATION_FILES"
+)
+
+// expansionFileNamePattern matches the standard expansion file names: [main|patch].<versionCode>.<packageName>.obb
+var expansionFileNamePattern = regexp.MustCompile(`^(main|patch)\.(\d+)\.(.+)\.obb$`)
+
+// isRemoteApp returns true if the given app path is a http(s)://, gs:// or s3:// URL. The gs:// and s3:// URLs are
+// rejected on download with a hint to use a signed https URL.
+func isRemoteApp(pth string) bool {
+	for _, scheme := range []string{"http://", "https://", "gs://", "s3://"} {
+		if strings.HasPrefix(strings.ToLower(pth), scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAppDownloadURL returns the http(s) URL the given remote app can be downloaded from. Google Cloud Storage (gs://)
+// and Amazon S3 (s3://) URLs are rejected: the step has no credentials for the buckets, which are private for release
+// artifacts, so a signed https URL is required instead.
+func remoteAppDownloadURL(appURL string) (string, error) {
+	u, err := url.Parse(appURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse app url (%s), error: %s", appURL, err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid app url, missing host or bucket: %s", appURL)
+	}
+
+	switch scheme := strings.ToLower(u.Scheme); scheme {
+	case "gs", "s3":
+		return "", fmt.Errorf("%s:// app urls are not supported (%s), use a signed https URL of the file instead, for example from `gsutil signurl` or `aws s3 presign`", scheme, appURL)
+	default:
+		return appURL, nil
+	}
+}
+
+// remoteAppLocalPath returns the path where the given remote app is downloaded to. The file name of the URL is kept,
+// so the type of the app can be detected by its extension.
+func remoteAppLocalPath(appURL string) (string, error) {
+	u, err := url.Parse(appURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse app url (%s), error: %s", appURL, err)
+	}
+
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return "", fmt.Errorf("invalid app url, missing file name: %s", appURL)
+	}
+
+	return filepath.Join(os.TempDir(), remoteAppsDirName, fmt.Sprintf("%x", sha1.Sum([]byte(appURL)))[:10], name), nil
+}
+
+// downloadRemoteApps downloads the remote apps of the given app list to their local path.
+func downloadRemoteApps(appList []string) error {
+	for _, app := range appList {
+		if !isRemoteApp(app) {
+			continue
+		}
+
+		downloadURL, err := remoteAppDownloadURL(app)
+		if err != nil {
+			return err
+		}
+		pth, err := remoteAppLocalPath(app)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Downloading %s", app)
+		if err := downloadFileWithRetry(downloadURL, pth, 3, 3); err != nil {
+			return fmt.Errorf("failed to download app (%s), error: %s", app, err)
+		}
+		log.Printf(" downloaded to %s", pth)
+	}
+	return nil
+}
+
+// hasGlobPattern returns true if the given path contains any glob special character.
+func hasGlobPattern(pth string) bool {
+	return strings.ContainsAny(pth, "*?[")
+}
+
+// globPaths returns the files matching the given pattern. Besides the filepath.Match syntax, the pattern can contain
+// `**` path elements which match any number of directories (like app/build/outputs/**/release/*.aab).
+func globPaths(pattern string) ([]string, error) {
+	pattern = filepath.Clean(pattern)
+	if !strings.Contains(pattern, "**") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return filterFiles(matches), nil
+	}
+
+	patternElements := strings.Split(filepath.ToSlash(pattern), "/")
+	for _, e := range patternElements {
+		if _, err := filepath.Match(e, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// walk from the longest directory prefix which does not contain any pattern
+	var rootElements []string
+	for _, e := range patternElements {
+		if hasGlobPattern(e) {
+			break
+		}
+		rootElements = append(rootElements, e)
+	}
+	root := filepath.FromSlash(strings.Join(rootElements, "/"))
+	if root == "" {
+		if filepath.IsAbs(pattern) {
+			root = string(filepath.Separator)
+		} else {
+			root = "."
+		}
+	}
+
+	var matches []string
+	err := filepath.Walk(root, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if matchPathElements(patternElements, strings.Split(filepath.ToSlash(pth), "/")) {
+			matches = append(matches, pth)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// matchPathElements reports whether the path elements match the pattern elements, `**` matches zero or more elements.
+func matchPathElements(pattern, pth []string) bool {
+	if len(pattern) == 0 {
+		return len(pth) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(pth); i++ {
+			if matchPathElements(pattern[1:], pth[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(pth) == 0 {
+		return false
+	}
+	if match, err := filepath.Match(pattern[0], pth[0]); err != nil || !match {
+		return false
+	}
+	return matchPathElements(pattern[1:], pth[1:])
+}
+
+// filterFiles returns the paths which are pointing to an existing file.
+func filterFiles(pths []string) (files []string) {
+	for _, pth := range pths {
+		if info, err := os.Stat(pth); err == nil && !info.IsDir() {
+			files = append(files, pth)
+		}
+	}
+	return
+}
+
+// appsInDir returns the .apk and .aab files in the given directory (and its subdirectories if recursive is set), sorted
+// by their version code. Apps with unreadable manifest are listed last.
+func appsInDir(dir string, recursive bool) ([]string, error) {
+	var apps []string
+	err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if pth != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := strings.ToLower(filepath.Ext(pth)); ext == ".apk" || ext == ".aab" {
+			apps = append(apps, pth)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list apps in %s, error: %s", dir, err)
+	}
+
+	versionCodes := map[string]int64{}
+	for _, pth := range apps {
+		versionCodes[pth] = math.MaxInt64
+		if manifest, err := readAppManifest(pth); err == nil {
+			versionCodes[pth] = manifest.VersionCode
+		}
+	}
+	sort.SliceStable(apps, func(i, j int) bool {
+		return versionCodes[apps[i]] < versionCodes[apps[j]]
+	})
+	return apps, nil
+}
+
+// filterApps returns the apps matching the given filter patterns. Patterns prefixed with `!` exclude the matching apps,
+// if there is any other pattern, only the apps matching at least one of them are kept. Patterns are matched against
+// both the file name and the full path of the apps.
+func filterApps(apps, patterns []string) ([]string, error) {
+	var includes, excludes []string
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			excludes = append(excludes, strings.TrimPrefix(pattern, "!"))
+		} else {
+			includes = append(includes, pattern)
+		}
+	}
+
+	matchAny := func(pth string, patterns []string) (bool, error) {
+		for _, pattern := range patterns {
+			for _, name := range []string{filepath.Base(pth), pth} {
+				match, err := filepath.Match(pattern, name)
+				if err != nil {
+					return false, fmt.Errorf("invalid app filter pattern: %s, error: %s", pattern, err)
+				}
+				if match {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+
+	var filtered []string
+	for _, pth := range apps {
+		if len(includes) > 0 {
+			included, err := matchAny(pth, includes)
+			if err != nil {
+				return nil, err
+			}
+			if !included {
+				log.Printf("Skipping %s, it does not match the app filter", pth)
+				continue
+			}
+		}
+
+		excluded, err := matchAny(pth, excludes)
+		if err != nil {
+			return nil, err
+		}
+		if excluded {
+			log.Printf("Skipping %s, it is excluded by the app filter", pth)
+			continue
+		}
+		filtered = append(filtered, pth)
+	}
+	return filtered, nil
+}
+
+// uniqueApps returns the given apps without the duplicates, an app is a duplicate of an earlier one if their absolute
+// path or their content is the same. Returns a warning for each skipped duplicate.
+func uniqueApps(apps []string) ([]string, []string) {
+	var unique, warnings []string
+	seen := map[string]string{}
+	for _, pth := range apps {
+		keys := []string{pth}
+		if absPth, err := filepath.Abs(pth); err == nil {
+			keys[0] = absPth
+		}
+		if _, sha256Hash, err := fileHashes(pth); err == nil {
+			keys = append(keys, "sha256:"+sha256Hash)
+		}
+
+		var duplicateOf string
+		for _, key := range keys {
+			if original, ok := seen[key]; ok {
+				duplicateOf = original
+				break
+			}
+		}
+		if duplicateOf != "" {
+			warnings = append(warnings, fmt.Sprintf("%s is a duplicate of %s, uploading it only once", pth, duplicateOf))
+			continue
+		}
+
+		for _, key := range keys {
+			seen[key] = pth
+		}
+		unique = append(unique, pth)
+	}
+	return unique, warnings
+}
+
+// conventionalMappingFile returns the mapping.txt of the given app from the conventional Gradle output location, like
+// app/build/outputs/mapping/release/mapping.txt for app/build/outputs/bundle/release/app-release.aab. Apps of product
+// flavors (app/build/outputs/apk/free/release/app-free-release.apk) are looked up in the mapping/freeRelease directory.
+// Returns an empty string if no mapping file found.
+func conventionalMappingFile(appPath string) string {
+	variantDir := filepath.Dir(appPath)
+	variant := filepath.Base(variantDir)
+	candidates := []string{filepath.Join(variantDir, "..", "..", "mapping", variant, "mapping.txt")}
+
+	flavorDir := filepath.Dir(variantDir)
+	flavorVariant := filepath.Base(flavorDir) + variant
+	if variant != "" {
+		flavorVariant = filepath.Base(flavorDir) + strings.ToUpper(variant[:1]) + variant[1:]
+	}
+	candidates = append(candidates, filepath.Join(flavorDir, "..", "..", "mapping", flavorVariant, "mapping.txt"))
+
+	for _, candidate := range candidates {
+		if files := filterFiles([]string{candidate}); len(files) > 0 {
+			return filepath.Clean(candidate)
+		}
+	}
+	return ""
+}
+
+// detectMappingFiles returns the conventional mapping file for each app, apps without one have no entry.
+// Returns nil if none of the apps have a mapping file.
+func detectMappingFiles(appPaths []string) [][]deobfuscationFile {
+	var found bool
+	entries := make([][]deobfuscationFile, len(appPaths))
+	for i, appPath := range appPaths {
+		if pth := conventionalMappingFile(appPath); pth != "" {
+			log.Printf("Found mapping file of %s: %s", appPath, pth)
+			entries[i] = []deobfuscationFile{{fileType: "proguard", path: pth}}
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return entries
+}
+
+// discoverExpansionFiles returns the expansion file entries (like "main:/path/main.1.io.bitrise.sample.obb") of the
+// given package found in the given directory, by version code. Files not following the standard naming convention are
+// ignored.
+func discoverExpansionFiles(dir, packageName string) (map[int64][]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expansion files in %s, error: %s", dir, err)
+	}
+
+	entries := map[int64][]string{}
+	for _, file := range files {
+		match := expansionFileNamePattern.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil || match[3] != packageName {
+			continue
+		}
+
+		versionCode, err := strconv.ParseInt(match[2], 10, 64)
+		if err != nil {
+			log.Warnf("Invalid version code in expansion file name: %s", file.Name())
+			continue
+		}
+
+		pth := filepath.Join(dir, file.Name())
+		log.Printf("Found %s expansion file for version code %d: %s", match[1], versionCode, pth)
+		entries[versionCode] = append(entries[versionCode], match[1]+":"+pth)
+	}
+	return entries, nil
+}
+
+// cachedFileHashes are the hashes of a file, valid while the size and the modification time of the file are unchanged.
+type cachedFileHashes struct {
+	size    int64
+	modTime time.Time
+	sha1    string
+	sha256  string
+}
+
+var (
+	fileHashCacheMu sync.Mutex
+	// fileHashCache caches the hashes of the files by their absolute path, as the apps are hashed for the duplicate
+	// check, the deploy summary and the upload.
+	fileHashCache = map[string]cachedFileHashes{}
+)
+
+// fileHashes returns the hex encoded sha1 and sha256 hash of the given file. The hashes are cached per path.
+func fileHashes(pth string) (string, string, error) {
+	info, err := os.Stat(pth)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open file (%s), error: %s", pth, err)
+	}
+	key := pth
+	if absPth, err := filepath.Abs(pth); err == nil {
+		key = absPth
+	}
+
+	fileHashCacheMu.Lock()
+	cached, ok := fileHashCache[key]
+	fileHashCacheMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sha1, cached.sha256, nil
+	}
+
+	sha1Hash, sha256Hash, err := computeFileHashes(pth)
+	if err != nil {
+		return "", "", err
+	}
+	fileHashCacheMu.Lock()
+	fileHashCache[key] = cachedFileHashes{size: info.Size(), modTime: info.ModTime(), sha1: sha1Hash, sha256: sha256Hash}
+	fileHashCacheMu.Unlock()
+	return sha1Hash, sha256Hash, nil
+}
+
+// computeFileHashes reads the given file and returns its hex encoded sha1 and sha256 hash.
+func computeFileHashes(pth string) (string, string, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("failed to close (%s)", pth)
+		}
+	}()
+
+	sha1Hash, sha256Hash := sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(sha1Hash, sha256Hash), file); err != nil {
+		return "", "", fmt.Errorf("failed to read file (%s), error: %s", pth, err)
+	}
+	return hex.EncodeToString(sha1Hash.Sum(nil)), hex.EncodeToString(sha256Hash.Sum(nil)), nil
+}
+
+// appChecksum is the sha256 hash of an uploaded app, computed from the local file and reported by Google Play.
+type appChecksum struct {
+	path       string
+	sha256     string
+	playSha256 string
+}
+
+// checksumList returns the given hashes of the apps in the format of the sha256sum tool, one app per line. Apps
+// without a hash are left out.
+func checksumList(checksums []appChecksum, hash func(appChecksum) string) string {
+	var lines []string
+	for _, checksum := range checksums {
+		if h := hash(checksum); h != "" {
+			lines = append(lines, fmt.Sprintf("%s  %s", strings.ToLower(h), checksum.path))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// exportAppChecksums exports the sha256 hashes of the uploaded apps, both the ones computed from the local files
+// and the ones reported by Google Play.
+func exportAppChecksums(checksums []appChecksum) error {
+	if err := exportEnvironment(appSha256EnvKey, checksumList(checksums, func(c appChecksum) string { return c.sha256 })); err != nil {
+		return err
+	}
+	return exportEnvironment(playSha256EnvKey, checksumList(checksums, func(c appChecksum) string { return c.playSha256 }))
+}
+
+// deobfuscationFilesList returns the types of the deobfuscation files uploaded for each version code as
+// <version code>:<types> lines, in ascending order of the version codes. Version codes without deobfuscation files
+// have no types.
+func deobfuscationFilesList(files map[int64][]string) string {
+	var versionCodes []int64
+	for versionCode := range files {
+		versionCodes = append(versionCodes, versionCode)
+	}
+	sort.Slice(versionCodes, func(i, j int) bool { return versionCodes[i] < versionCodes[j] })
+
+	var lines []string
+	for _, versionCode := range versionCodes {
+		lines = append(lines, fmt.Sprintf("%d:%s", versionCode, strings.Join(files[versionCode], ",")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// verifyUploadedHashes compares the given hashes of the local app file with the hashes Google Play reported for the
+// uploaded app. Hashes not reported by Google Play are not compared.
+func verifyUploadedHashes(pth, localSha1, localSha256, uploadedSha1, uploadedSha256 string) error {
+	if uploadedSha1 != "" && !strings.EqualFold(localSha1, uploadedSha1) {
+		return fmt.Errorf("sha1 hash mismatch of the uploaded app (%s), local: %s, Google Play: %s", pth, localSha1, uploadedSha1)
+	}
+	if uploadedSha256 != "" && !strings.EqualFold(localSha256, uploadedSha256) {
+		return fmt.Errorf("sha256 hash mismatch of the uploaded app (%s), local: %s, Google Play: %s", pth, localSha256, uploadedSha256)
+	}
+	if uploadedSha1 == "" && uploadedSha256 == "" {
+		log.Warnf("Google Play did not report the hash of the uploaded app (%s), skipping verification", pth)
+		return nil
+	}
+
+	log.Printf(" verified hash of the uploaded app: %s", localSha256)
+	return nil
+}
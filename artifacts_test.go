@@ -0,0 +1,408 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_globPaths(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_globPaths")
+	if err != nil {
+		t.Fatalf("setup: failed to create test dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("Failed to remove test dir, error: %s", err)
+		}
+	}()
+
+	for _, pth := range []string{
+		"app/build/outputs/bundle/release/app-release.aab",
+		"app/build/outputs/bundle/debug/app-debug.aab",
+		"app/build/outputs/apk/free/release/app-free-release.apk",
+		"app/build/outputs/apk/paid/release/app-paid-release.apk",
+	} {
+		pth = filepath.Join(tmpDir, pth)
+		if err := os.MkdirAll(filepath.Dir(pth), 0700); err != nil {
+			t.Fatalf("setup: failed to create test dir, error: %s", err)
+		}
+		if err := ioutil.WriteFile(pth, []byte{}, 0600); err != nil {
+			t.Fatalf("setup: failed to create test file, error: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "single level pattern",
+			pattern: "app/build/outputs/bundle/*/*.aab",
+			want:    []string{"app/build/outputs/bundle/debug/app-debug.aab", "app/build/outputs/bundle/release/app-release.aab"},
+		},
+		{
+			name:    "recursive pattern",
+			pattern: "app/build/outputs/**/release/*.aab",
+			want:    []string{"app/build/outputs/bundle/release/app-release.aab"},
+		},
+		{
+			name:    "recursive pattern matching multiple directories",
+			pattern: "app/**/release/*.apk",
+			want:    []string{"app/build/outputs/apk/free/release/app-free-release.apk", "app/build/outputs/apk/paid/release/app-paid-release.apk"},
+		},
+		{
+			name:    "recursive pattern matching zero directories",
+			pattern: "app/build/outputs/bundle/release/**/*.aab",
+			want:    []string{"app/build/outputs/bundle/release/app-release.aab"},
+		},
+		{
+			name:    "no match",
+			pattern: "app/**/*.obb",
+			want:    nil,
+		},
+		{
+			name:    "invalid pattern",
+			pattern: "app/**/[.aab",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := globPaths(filepath.Join(tmpDir, tt.pattern))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("globPaths() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var want []string
+			for _, pth := range tt.want {
+				want = append(want, filepath.Join(tmpDir, pth))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("globPaths() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func Test_remoteAppDownloadURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		appURL  string
+		want    string
+		wantErr bool
+	}{
+		{"https", "https://example.com/builds/app.aab?token=abc", "https://example.com/builds/app.aab?token=abc", false},
+		{"http", "http://example.com/app.apk", "http://example.com/app.apk", false},
+		{"gcs", "gs://my-bucket/builds/app.aab", "", true},
+		{"s3", "s3://my-bucket/builds/app.aab", "", true},
+		{"missing bucket", "gs:///app.aab", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := remoteAppDownloadURL(tt.appURL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("remoteAppDownloadURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("remoteAppDownloadURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_remoteAppLocalPath(t *testing.T) {
+	got, err := remoteAppLocalPath("https://example.com/builds/app-release.aab?token=abc")
+	if err != nil {
+		t.Fatalf("remoteAppLocalPath() error = %v", err)
+	}
+	if filepath.Base(got) != "app-release.aab" {
+		t.Errorf("remoteAppLocalPath() = %v, want file name app-release.aab", got)
+	}
+
+	other, err := remoteAppLocalPath("https://example.com/other/app-release.aab")
+	if err != nil {
+		t.Fatalf("remoteAppLocalPath() error = %v", err)
+	}
+	if got == other {
+		t.Errorf("remoteAppLocalPath() returned the same path (%s) for different urls", got)
+	}
+
+	if _, err := remoteAppLocalPath("https://example.com/"); err == nil {
+		t.Errorf("remoteAppLocalPath() expected error for url without file name")
+	}
+}
+
+func Test_verifyUploadedHashes(t *testing.T) {
+	const (
+		appSha1   = "7d1043473d55bfa90e8530d35801d4e381bc69f0"
+		appSha256 = "a172cedcae47474b615c54d510a5d84a8dea3032e958587430b413538be3f333"
+	)
+
+	tests := []struct {
+		name           string
+		uploadedSha1   string
+		uploadedSha256 string
+		wantErr        bool
+	}{
+		{"matching hashes", appSha1, appSha256, false},
+		{"matching upper case hashes", strings.ToUpper(appSha1), strings.ToUpper(appSha256), false},
+		{"no reported hashes", "", "", false},
+		{"sha1 mismatch", strings.Repeat("0", 40), appSha256, true},
+		{"sha256 mismatch", appSha1, strings.Repeat("0", 64), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyUploadedHashes("app.aab", appSha1, appSha256, tt.uploadedSha1, tt.uploadedSha256); (err != nil) != tt.wantErr {
+				t.Errorf("verifyUploadedHashes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_fileHashes(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_fileHashes")
+	if err != nil {
+		t.Fatalf("setup: failed to create test dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("Failed to remove test dir, error: %s", err)
+		}
+	}()
+
+	pth := filepath.Join(tmpDir, "app.aab")
+	if err := ioutil.WriteFile(pth, []byte("app"), 0600); err != nil {
+		t.Fatalf("setup: failed to create test file, error: %s", err)
+	}
+	sha1Hash, sha256Hash, err := fileHashes(pth)
+	if err != nil {
+		t.Fatalf("fileHashes() error = %v", err)
+	}
+	if sha1Hash != "7d1043473d55bfa90e8530d35801d4e381bc69f0" || sha256Hash != "a172cedcae47474b615c54d510a5d84a8dea3032e958587430b413538be3f333" {
+		t.Errorf("fileHashes() = %s, %s", sha1Hash, sha256Hash)
+	}
+
+	// The cached hashes are not used after the file changed.
+	if err := ioutil.WriteFile(pth, []byte("app v2"), 0600); err != nil {
+		t.Fatalf("setup: failed to update test file, error: %s", err)
+	}
+	_, changedSha256, err := fileHashes(pth)
+	if err != nil {
+		t.Fatalf("fileHashes() error = %v", err)
+	}
+	if changedSha256 == sha256Hash {
+		t.Errorf("fileHashes() returned the cached hash of the changed file")
+	}
+}
+
+func Test_detectMappingFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_detectMappingFiles")
+	if err != nil {
+		t.Fatalf("setup: failed to create test dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("Failed to remove test dir, error: %s", err)
+		}
+	}()
+
+	outputs := filepath.Join(tmpDir, "app", "build", "outputs")
+	for _, pth := range []string{
+		filepath.Join(outputs, "bundle", "release", "app-release.aab"),
+		filepath.Join(outputs, "mapping", "release", "mapping.txt"),
+		filepath.Join(outputs, "apk", "free", "release", "app-free-release.apk"),
+		filepath.Join(outputs, "mapping", "freeRelease", "mapping.txt"),
+		filepath.Join(outputs, "apk", "debug", "app-debug.apk"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(pth), 0700); err != nil {
+			t.Fatalf("setup: failed to create test dir, error: %s", err)
+		}
+		if err := ioutil.WriteFile(pth, []byte{}, 0600); err != nil {
+			t.Fatalf("setup: failed to create test file, error: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		appPaths []string
+		want     [][]deobfuscationFile
+	}{
+		{
+			name:     "bundle",
+			appPaths: []string{filepath.Join(outputs, "bundle", "release", "app-release.aab")},
+			want:     [][]deobfuscationFile{{{fileType: "proguard", path: filepath.Join(outputs, "mapping", "release", "mapping.txt")}}},
+		},
+		{
+			name:     "flavor apk and app without mapping",
+			appPaths: []string{filepath.Join(outputs, "apk", "free", "release", "app-free-release.apk"), filepath.Join(outputs, "apk", "debug", "app-debug.apk")},
+			want:     [][]deobfuscationFile{{{fileType: "proguard", path: filepath.Join(outputs, "mapping", "freeRelease", "mapping.txt")}}, nil},
+		},
+		{
+			name:     "no mapping",
+			appPaths: []string{filepath.Join(outputs, "apk", "debug", "app-debug.apk")},
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectMappingFiles(tt.appPaths); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("detectMappingFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_discoverExpansionFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_discoverExpansionFiles")
+	if err != nil {
+		t.Fatalf("setup: failed to create test dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("Failed to remove test dir, error: %s", err)
+		}
+	}()
+
+	for _, name := range []string{
+		"main.1.io.bitrise.sample.obb",
+		"patch.1.io.bitrise.sample.obb",
+		"main.2.io.bitrise.sample.obb",
+		"main.3.io.bitrise.other.obb",
+		"assets.obb",
+		"main.x.io.bitrise.sample.obb",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte{}, 0600); err != nil {
+			t.Fatalf("setup: failed to create test file, error: %s", err)
+		}
+	}
+
+	got, err := discoverExpansionFiles(tmpDir, "io.bitrise.sample")
+	if err != nil {
+		t.Fatalf("discoverExpansionFiles() error = %v", err)
+	}
+
+	want := map[int64][]string{
+		1: {"main:" + filepath.Join(tmpDir, "main.1.io.bitrise.sample.obb"), "patch:" + filepath.Join(tmpDir, "patch.1.io.bitrise.sample.obb")},
+		2: {"main:" + filepath.Join(tmpDir, "main.2.io.bitrise.sample.obb")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("discoverExpansionFiles() = %v, want %v", got, want)
+	}
+}
+
+func Test_appsInDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_appsInDir")
+	if err != nil {
+		t.Fatalf("setup: failed to create test dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Logf("Failed to remove test dir, error: %s", err)
+		}
+	}()
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "wear"), 0700); err != nil {
+		t.Fatalf("setup: failed to create test dir, error: %s", err)
+	}
+	arm := filepath.Join(tmpDir, "app-arm.apk")
+	createTestApp(t, arm, newTestManifest("io.bitrise.sample", 3), nil)
+	x86 := filepath.Join(tmpDir, "app-x86.apk")
+	createTestApp(t, x86, newTestManifest("io.bitrise.sample", 2), nil)
+	wear := filepath.Join(tmpDir, "wear", "app-wear.aab")
+	createTestApp(t, wear, newTestManifest("io.bitrise.sample", 1), nil)
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "mapping.txt"), []byte{}, 0600); err != nil {
+		t.Fatalf("setup: failed to create test file, error: %s", err)
+	}
+
+	tests := []struct {
+		name      string
+		recursive bool
+		want      []string
+	}{
+		{"non-recursive", false, []string{x86, arm}},
+		{"recursive", true, []string{wear, x86, arm}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := appsInDir(tmpDir, tt.recursive)
+			if err != nil {
+				t.Fatalf("appsInDir() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("appsInDir() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_filterApps(t *testing.T) {
+	apps := []string{
+		"app/build/outputs/bundle/freeRelease/app-free-release.aab",
+		"app/build/outputs/bundle/paidRelease/app-paid-release.aab",
+		"app/build/outputs/apk/free/debug/app-free-debug.apk",
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		{"include", []string{"*release*"}, apps[:2], false},
+		{"exclude", []string{"!*debug*"}, apps[:2], false},
+		{"include and exclude", []string{"*free*", "!*debug*"}, apps[:1], false},
+		{"full path", []string{"app/build/outputs/bundle/*/*.aab"}, apps[:2], false},
+		{"no match", []string{"*.obb"}, nil, false},
+		{"invalid pattern", []string{"[release"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filterApps(apps, tt.patterns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("filterApps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterApps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checksumList(t *testing.T) {
+	checksums := []appChecksum{
+		{path: "app-release.aab", sha256: "aa", playSha256: "AA"},
+		{path: "app-wear-release.apk", sha256: "bb"},
+	}
+
+	if got, want := checksumList(checksums, func(c appChecksum) string { return c.sha256 }), "aa  app-release.aab\nbb  app-wear-release.apk"; got != want {
+		t.Errorf("checksumList() = %q, want %q", got, want)
+	}
+	if got, want := checksumList(checksums, func(c appChecksum) string { return c.playSha256 }), "aa  app-release.aab"; got != want {
+		t.Errorf("checksumList() = %q, want %q", got, want)
+	}
+	if got := checksumList(nil, func(c appChecksum) string { return c.sha256 }); got != "" {
+		t.Errorf("checksumList() = %q, want empty", got)
+	}
+}
+
+func Test_deobfuscationFilesList(t *testing.T) {
+	files := map[int64][]string{
+		43: {"proguard", "nativeCode"},
+		42: {"proguard"},
+		44: {},
+	}
+	if got, want := deobfuscationFilesList(files), "42:proguard\n43:proguard,nativeCode\n44:"; got != want {
+		t.Errorf("deobfuscationFilesList() = %q, want %q", got, want)
+	}
+	if got := deobfuscationFilesList(nil); got != "" {
+		t.Errorf("deobfuscationFilesList() = %q, want empty", got)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+const listingBackupPathEnvKey = "GOOGLE_PLAY_LISTING_BACKUP_PATH"
+
+// listingBackup is the state of the store listings and the release notes before the step changes them.
+type listingBackup struct {
+	PackageName string          `json:"package_name"`
+	CreatedAt   string          `json:"created_at"`
+	Listings    []listingReport `json:"listings"`
+	Tracks      []trackReport   `json:"tracks"`
+}
+
+// listingReport is the state of a store listing in the listing backup.
+type listingReport struct {
+	Language         string                   `json:"language"`
+	Title            string                   `json:"title,omitempty"`
+	ShortDescription string                   `json:"short_description,omitempty"`
+	FullDescription  string                   `json:"full_description,omitempty"`
+	Video            string                   `json:"video,omitempty"`
+	Images           map[string][]imageReport `json:"images,omitempty"`
+}
+
+// imageReport is the state of a store listing image in the listing backup.
+type imageReport struct {
+	ID     string `json:"id"`
+	Sha256 string `json:"sha256"`
+	URL    string `json:"url"`
+}
+
+// newListingReport returns the backup of the given store listing with its images by image type.
+func newListingReport(listing *androidpublisher.Listing, images map[string][]*androidpublisher.Image) listingReport {
+	report := listingReport{
+		Language:         listing.Language,
+		Title:            listing.Title,
+		ShortDescription: listing.ShortDescription,
+		FullDescription:  listing.FullDescription,
+		Video:            listing.Video,
+	}
+	for imageType, typeImages := range images {
+		if len(typeImages) == 0 {
+			continue
+		}
+		if report.Images == nil {
+			report.Images = map[string][]imageReport{}
+		}
+		for _, image := range typeImages {
+			report.Images[imageType] = append(report.Images[imageType], imageReport{ID: image.Id, Sha256: image.Sha256, URL: image.Url})
+		}
+	}
+	return report
+}
+
+// backupListings writes the current store listings, the metadata of their images and the tracks with their release
+// notes to a timestamped JSON file in the listing_backup_dir, and exports its path.
+func backupListings(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) error {
+	listingsResponse, err := androidpublisher.NewEditsListingsService(service).List(configs.PackageName, appEdit.Id).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list store listings, error: %s", err)
+	}
+
+	now := time.Now().UTC()
+	backup := listingBackup{
+		PackageName: configs.PackageName,
+		CreatedAt:   now.Format(time.RFC3339),
+		Listings:    []listingReport{},
+	}
+	editsImagesService := androidpublisher.NewEditsImagesService(service)
+	for _, listing := range listingsResponse.Listings {
+		images := map[string][]*androidpublisher.Image{}
+		for _, imageType := range imageTypes {
+			imagesResponse, err := editsImagesService.List(configs.PackageName, appEdit.Id, listing.Language, imageType).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("failed to list %s %s images, error: %s", listing.Language, imageType, err)
+			}
+			images[imageType] = imagesResponse.Images
+		}
+		backup.Listings = append(backup.Listings, newListingReport(listing, images))
+	}
+
+	tracksListResponse, err := androidpublisher.NewEditsTracksService(service).List(configs.PackageName, appEdit.Id).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list tracks, error: %s", err)
+	}
+	backup.Tracks = tracksReport(tracksListResponse.Tracks)
+
+	pth := filepath.Join(configs.ListingBackupDir, fmt.Sprintf("google-play-listing-backup-%s-%s.json", configs.PackageName, now.Format("20060102-150405")))
+	if err := writeJSONFile(pth, backup); err != nil {
+		return fmt.Errorf("failed to write listing backup, error: %s", err)
+	}
+	log.Printf("Listing backup written to: %s", pth)
+	return exportEnvironment(listingBackupPathEnvKey, pth)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+func Test_newListingReport(t *testing.T) {
+	listing := &androidpublisher.Listing{Language: "en-US", Title: "Sample", ShortDescription: "A sample app"}
+	images := map[string][]*androidpublisher.Image{
+		"phoneScreenshots": {{Id: "1", Sha256: "aa", Url: "https://example.com/1"}, {Id: "2", Sha256: "bb", Url: "https://example.com/2"}},
+		"icon":             {},
+	}
+
+	content, err := json.Marshal(newListingReport(listing, images))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"language": "en-US",
+		"title": "Sample",
+		"short_description": "A sample app",
+		"images": {"phoneScreenshots": [
+			{"id": "1", "sha256": "aa", "url": "https://example.com/1"},
+			{"id": "2", "sha256": "bb", "url": "https://example.com/2"}
+		]}
+	}`, string(content))
+
+	content, err = json.Marshal(newListingReport(&androidpublisher.Listing{Language: "de-DE"}, nil))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"language": "de-DE"}`, string(content))
+}
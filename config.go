@@ -1,10 +1,19 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-utils/log"
@@ -15,16 +24,85 @@ import (
 type Configs struct {
 	JSONKeyPath                 stepconf.Secret `env:"service_account_json_key_path,required"`
 	PackageName                 string          `env:"package_name,required"`
-	AppPath                     string          `env:"app_path,required"`
+	AppPath                     string          `env:"app_path"`
+	AppPathRecursive            bool            `env:"app_path_recursive,opt[true,false]"`
+	AppFilter                   string          `env:"app_filter"`
 	ExpansionfilePath           string          `env:"expansionfile_path"`
+	ExpansionfileDir            string          `env:"expansionfile_dir"`
 	Track                       string          `env:"track,required"`
-	UserFraction                float64         `env:"user_fraction,range]0.0..1.0["`
+	Operation                   string          `env:"operation,opt[deploy,promote,halt_rollout,update_rollout,complete_rollout,rollback,ramp_rollout,list_tracks,update_listing]"`
+	SourceTrack                 string          `env:"source_track"`
+	RollbackVersionCode         string          `env:"rollback_version_code"`
+	RampPlan                    string          `env:"ramp_plan"`
+	RampStatePath               string          `env:"ramp_state_path"`
+	UserFractionInput           string          `env:"user_fraction"`
 	UpdatePriority              int             `env:"update_priority,range[0..5]"`
 	WhatsnewsDir                string          `env:"whatsnews_dir"`
+	TrackWhatsnewsDirs          string          `env:"track_whatsnews_dirs"`
+	WhatsnewsURL                string          `env:"whatsnews_url"`
+	LocaleAliases               string          `env:"locale_aliases"`
+	ReleaseNotesPath            string          `env:"release_notes_path"`
+	ReleaseNotesLanguage        string          `env:"release_notes_language"`
+	ReleaseNotesJSON            string          `env:"release_notes_json"`
+	ReleaseNotesFromGitMessage  bool            `env:"release_notes_from_git_message,opt[true,false]"`
+	TruncateReleaseNotes        bool            `env:"truncate_release_notes,opt[true,false]"`
+	StripReleaseNotesMarkup     bool            `env:"strip_release_notes_markup,opt[true,false]"`
+	ReleaseNotesLocaleCheck     string          `env:"release_notes_locale_check,opt[warn,fail,off]"`
+	ReleaseNotesCoverageCheck   string          `env:"release_notes_coverage_check,opt[warn,fail,off]"`
 	MappingFile                 string          `env:"mapping_file"`
 	ReleaseName                 string          `env:"release_name"`
+	UpdateNamedRelease          bool            `env:"update_named_release,opt[true,false]"`
+	RemoveDraftReleases         bool            `env:"remove_draft_releases,opt[true,false]"`
+	RetainVersionCodes          string          `env:"retain_version_codes"`
+	AppendVersionCodes          bool            `env:"append_version_codes,opt[true,false]"`
 	Status                      string          `env:"status"`
 	RetryWithoutSendingToReview bool            `env:"retry_without_sending_to_review,opt[true,false]"`
+	ChangesNotSentForReview     bool            `env:"changes_not_sent_for_review,opt[true,false]"`
+	UploadTimeout               int             `env:"upload_timeout"`
+	DeviceTierConfigID          string          `env:"device_tier_config_id"`
+	VerifyReleaseBuild          bool            `env:"verify_release_build,opt[true,false]"`
+	SigningCertificateSHA256    string          `env:"signing_certificate_sha256"`
+	UploadOnly                  bool            `env:"upload_only,opt[true,false]"`
+	EditID                      string          `env:"edit_id"`
+	SkipCommit                  bool            `env:"skip_commit,opt[true,false]"`
+	InternalAppSharing          bool            `env:"internal_app_sharing,opt[true,false]"`
+	DetectMappingFile           bool            `env:"detect_mapping_file,opt[true,false]"`
+	ReleaseCountries            string          `env:"release_countries"`
+	IncludeRestOfWorld          bool            `env:"include_rest_of_world,opt[true,false]"`
+	PreRegistration             bool            `env:"pre_registration,opt[true,false]"`
+	ContinueOnPackageFailure    bool            `env:"continue_on_package_failure,opt[true,false]"`
+	ShadowedReleaseCheck        string          `env:"shadowed_release_check,opt[warn,fail,off]"`
+	ExistingRolloutPolicy       string          `env:"existing_rollout_policy,opt[fail,complete_first,halt_first,replace]"`
+	ReleaseStateTimeout         int             `env:"release_state_timeout"`
+	TracksReportPath            string          `env:"tracks_report_path"`
+	MaxCrashRate                string          `env:"max_crash_rate"`
+	MaxANRRate                  string          `env:"max_anr_rate"`
+	ClearLowerTracks            string          `env:"clear_lower_tracks"`
+	ProcessingTimeout           int             `env:"processing_timeout"`
+	MetadataDir                 string          `env:"metadata_dir"`
+	SyncImages                  bool            `env:"sync_images,opt[true,false]"`
+	ListingDryRun               bool            `env:"listing_dry_run,opt[true,false]"`
+	ListingBackupDir            string          `env:"listing_backup_dir"`
+	DeploySummaryDir            string          `env:"deploy_summary_dir"`
+	AnnotateBuild               bool            `env:"annotate_build,opt[true,false]"`
+	LogLevel                    string          `env:"log_level,opt[quiet,normal,debug]"`
+	DebugHTTP                   bool            `env:"debug_http,opt[true,false]"`
+	APIMaxAttempts              int             `env:"api_max_attempts"`
+	APITimeout                  int             `env:"api_timeout"`
+	DeployTimeout               int             `env:"deploy_timeout"`
+	ConsoleDeveloperID          string          `env:"play_console_developer_id"`
+	ConsoleAppID                string          `env:"play_console_app_id"`
+	PromoVideoURL               string          `env:"promo_video_url"`
+	FeatureGraphicPath          string          `env:"feature_graphic_path"`
+
+	// UserFraction is the parsed value of the user_fraction input.
+	UserFraction float64
+	// Apps are the deduplicated apps of the app_path input, resolved once as finding them hashes and parses every app.
+	Apps []string
+	// AppExpansionFiles are the expansion_file_path entries by app path.
+	AppExpansionFiles map[string]string
+	// AppMappingFiles are the mapping_file entries by app path.
+	AppMappingFiles map[string][]deobfuscationFile
 }
 
 // validate validates the Configs.
@@ -37,13 +115,342 @@ func (c Configs) validate() error {
 		return err
 	}
 
+	if err := c.validateTrackWhatsnewsDirs(); err != nil {
+		return err
+	}
+
+	if _, err := c.localeAliases(); err != nil {
+		return err
+	}
+
+	if _, err := c.consoleAppIDs(); err != nil {
+		return err
+	}
+
+	if err := c.validateReleaseNotesPath(); err != nil {
+		return err
+	}
+
+	if c.ReleaseNotesFromGitMessage && c.ReleaseNotesLanguage == "" {
+		return errors.New("release notes language is required for the release notes from the git message")
+	}
+
+	if c.ReleaseNotesJSON != "" {
+		if _, err := c.releaseNotesJSON(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.validateReleaseNotesLength(); err != nil {
+		return err
+	}
+
+	if err := c.validateReleaseNotesLocales(); err != nil {
+		return err
+	}
+
 	if err := c.validateMappingFile(); err != nil {
 		return err
 	}
 
+	if err := c.validateExpansionfileDir(); err != nil {
+		return err
+	}
+
+	if err := c.validateMetadataDir(); err != nil {
+		return err
+	}
+
+	if err := c.validateListingMedia(); err != nil {
+		return err
+	}
+
+	if err := c.validateSigningCertificateSHA256(); err != nil {
+		return err
+	}
+
+	if err := c.validateLegacyRolloutTrack(); err != nil {
+		return err
+	}
+
+	if err := c.validateStatus(); err != nil {
+		return err
+	}
+
+	if _, err := c.retainedVersionCodes(); err != nil {
+		return err
+	}
+
+	if err := c.validateReleaseCountries(); err != nil {
+		return err
+	}
+
+	if c.UpdateNamedRelease && c.ReleaseName == "" {
+		return errors.New("release name is required for updating a named release")
+	}
+
+	if c.UploadTimeout < 0 {
+		return fmt.Errorf("upload timeout should not be negative: %d", c.UploadTimeout)
+	}
+
+	if c.APITimeout < 0 {
+		return fmt.Errorf("API timeout should not be negative: %d", c.APITimeout)
+	}
+
+	if c.DeployTimeout < 0 {
+		return fmt.Errorf("deploy timeout should not be negative: %d", c.DeployTimeout)
+	}
+
+	if c.APIMaxAttempts < 0 {
+		return fmt.Errorf("API max attempts should not be negative: %d", c.APIMaxAttempts)
+	}
+
+	if _, err := c.lowerTracksToClear(); err != nil {
+		return err
+	}
+
+	if _, _, err := c.vitalsThresholds(); err != nil {
+		return err
+	}
+
+	if c.ProcessingTimeout < 0 {
+		return fmt.Errorf("processing timeout should not be negative: %d", c.ProcessingTimeout)
+	}
+
+	if c.ReleaseStateTimeout < 0 {
+		return fmt.Errorf("release state timeout should not be negative: %d", c.ReleaseStateTimeout)
+	}
+
+	if c.EditID != "" && len(c.packageNames()) > 1 {
+		return fmt.Errorf("multiple package names (%s) are not supported for resuming an edit", c.PackageName)
+	}
+
+	if c.SkipCommit && len(c.packageNames()) > 1 {
+		return fmt.Errorf("multiple package names (%s) are not supported for skipping the commit, only the last edit could be resumed", c.PackageName)
+	}
+
+	if c.Operation == operationRampRollout && len(c.packageNames()) > 1 {
+		return fmt.Errorf("multiple package names (%s) are not supported for ramping a rollout", c.PackageName)
+	}
+
+	if c.Operation == operationListTracks {
+		if c.TracksReportPath == "" {
+			return errors.New("tracks report path is required for listing the tracks")
+		}
+		return nil
+	}
+
+	if !c.isDeploy() && len(c.tracks()) > 1 {
+		return fmt.Errorf("multiple tracks (%s) are supported only for deploying apps", c.Track)
+	}
+	if c.Operation == operationPromote {
+		return c.validatePromotion()
+	}
+	if c.Operation == operationRampRollout {
+		if _, err := parseRampPlan(c.RampPlan); err != nil {
+			return fmt.Errorf("invalid ramp plan, error: %s", err)
+		}
+		if c.RampStatePath == "" {
+			return errors.New("ramp state path is required for ramping a rollout")
+		}
+	}
+	if c.Operation == operationUpdateListing && !c.hasListingChanges() && c.FeatureGraphicPath == "" {
+		return errors.New("store listing or release notes inputs are required for updating the store listing")
+	}
+	if c.Operation == operationRollback && c.RollbackVersionCode == "" {
+		return errors.New("rollback version code is required for rolling back a release")
+	}
+	if c.Operation == operationUpdateRollout && c.UserFraction == 0 {
+		return errors.New("user fraction is required for updating a rollout")
+	}
+	if !c.isDeploy() {
+		return nil
+	}
 	return c.validateApps()
 }
 
+// tracks returns the tracks of the track input, which can be a comma separated list. The legacy rollout track is
+// mapped to the production track.
+func (c Configs) tracks() []string {
+	var tracks []string
+	for _, e := range parseInputList(c.Track) {
+		for _, track := range strings.Split(e, ",") {
+			if track = strings.TrimSpace(track); track == legacyRolloutTrack {
+				tracks = append(tracks, productionTrack)
+			} else if track != "" {
+				tracks = append(tracks, track)
+			}
+		}
+	}
+	return tracks
+}
+
+// lowerTracksToClear returns the tracks of the clear_lower_tracks input set to true, given as a list of
+// <track>:<true|false> entries.
+func (c Configs) lowerTracksToClear() ([]string, error) {
+	var tracks []string
+	for _, e := range parseInputList(c.ClearLowerTracks) {
+		for _, entry := range strings.Split(e, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			parts := strings.Split(entry, ":")
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid clear lower tracks entry: %s, should be <track>:<true|false>", entry)
+			}
+			clearTrack, err := strconv.ParseBool(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid clear lower tracks entry: %s, should be <track>:<true|false>", entry)
+			}
+			if clearTrack {
+				tracks = append(tracks, strings.TrimSpace(parts[0]))
+			}
+		}
+	}
+	return tracks, nil
+}
+
+// track returns the first track of the track input, the only one for the operations other than deploy.
+func (c Configs) track() string {
+	if tracks := c.tracks(); len(tracks) > 0 {
+		return tracks[0]
+	}
+	return ""
+}
+
+// validateLegacyRolloutTrack validates the usage of the legacy rollout track, a staged rollout on the production
+// track in the v2 API.
+func (c Configs) validateLegacyRolloutTrack() error {
+	for _, e := range parseInputList(c.Track) {
+		for _, track := range strings.Split(e, ",") {
+			if strings.TrimSpace(track) != legacyRolloutTrack {
+				continue
+			}
+			log.Warnf("Deprecated: the %s track is removed from the Google Play Developer API, it is mapped to a staged rollout on the %s track.", legacyRolloutTrack, productionTrack)
+			log.Warnf("Deprecated: use the track: %s input with the user_fraction input instead.", productionTrack)
+			if c.UserFraction == 0 {
+				return fmt.Errorf("user fraction is required for the %s track, it is a staged rollout on the %s track", legacyRolloutTrack, productionTrack)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// packageNames returns the package names of the package_name input, which can be a comma separated list.
+func (c Configs) packageNames() []string {
+	var packageNames []string
+	for _, e := range parseInputList(c.PackageName) {
+		for _, packageName := range strings.Split(e, ",") {
+			if packageName = strings.TrimSpace(packageName); packageName != "" {
+				packageNames = append(packageNames, packageName)
+			}
+		}
+	}
+	return packageNames
+}
+
+// releaseCountries returns the upper cased country codes of the release_countries input.
+func (c Configs) releaseCountries() []string {
+	var countries []string
+	for _, e := range parseInputList(c.ReleaseCountries) {
+		for _, country := range strings.Split(e, ",") {
+			if country = strings.TrimSpace(country); country != "" {
+				countries = append(countries, strings.ToUpper(country))
+			}
+		}
+	}
+	return countries
+}
+
+// validateReleaseCountries validates if the release_countries input values are two letter country codes.
+func (c Configs) validateReleaseCountries() error {
+	countries := c.releaseCountries()
+	for _, country := range countries {
+		if len(country) != 2 || strings.IndexFunc(country, func(r rune) bool { return r < 'A' || r > 'Z' }) != -1 {
+			return fmt.Errorf("invalid country code: %s, should be a two letter CLDR code", country)
+		}
+	}
+	if len(countries) == 0 && c.IncludeRestOfWorld {
+		log.Warnf("Include rest of world is ignored as the release is not targeted to countries")
+	}
+	return nil
+}
+
+// isDeploy returns true if the step uploads apps, instead of managing the releases of a track.
+func (c Configs) isDeploy() bool {
+	return c.Operation == "" || c.Operation == operationDeploy
+}
+
+// validatePromotion validates the inputs of promoting a release.
+func (c Configs) validatePromotion() error {
+	if c.SourceTrack == "" {
+		return errors.New("source track is required for promoting a release")
+	}
+	if c.SourceTrack == c.track() {
+		return fmt.Errorf("source track and track should be different: %s", c.track())
+	}
+	return nil
+}
+
+// validateStatus validates if status input value is a release status the step can create.
+func (c Configs) validateStatus() error {
+	if c.PreRegistration {
+		return c.validatePreRegistrationStatus()
+	}
+
+	switch c.Status {
+	case "", releaseStatusCompleted, releaseStatusInProgress:
+		return nil
+	case releaseStatusHalted:
+		if c.UserFraction == 0 {
+			return errors.New("user fraction is required for halted releases, it is the fraction of users the rollout reaches once resumed")
+		}
+		return nil
+	case releaseStatusDraft:
+		if c.UserFraction != 0 {
+			log.Warnf("User fraction (%v) is ignored for draft releases", c.UserFraction)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid status: %s, supported values: %s, %s, %s, %s", c.Status, releaseStatusCompleted, releaseStatusInProgress, releaseStatusHalted, releaseStatusDraft)
+	}
+}
+
+// validatePreRegistrationStatus validates if the release can be shipped to a track with a pre-registration campaign.
+// The release is published to the pre-registered users at once when the campaign launches, so it can not be a staged rollout.
+func (c Configs) validatePreRegistrationStatus() error {
+	switch c.Status {
+	case "", releaseStatusCompleted:
+		if c.UserFraction != 0 {
+			return fmt.Errorf("user fraction (%v) can not be set for pre-registration releases, staged rollouts are not supported during pre-registration", c.UserFraction)
+		}
+		return nil
+	case releaseStatusDraft:
+		return nil
+	default:
+		return fmt.Errorf("invalid status for a pre-registration release: %s, supported values: %s, %s", c.Status, releaseStatusCompleted, releaseStatusDraft)
+	}
+}
+
+// retainedVersionCodes returns the version codes of the retain_version_codes input.
+func (c Configs) retainedVersionCodes() ([]int64, error) {
+	var versionCodes []int64
+	for _, e := range parseInputList(c.RetainVersionCodes) {
+		for _, code := range strings.Split(e, ",") {
+			if code = strings.TrimSpace(code); code == "" {
+				continue
+			}
+			versionCode, err := strconv.ParseInt(code, 10, 64)
+			if err != nil || versionCode <= 0 {
+				return nil, fmt.Errorf("invalid version code to retain: %s", code)
+			}
+			versionCodes = append(versionCodes, versionCode)
+		}
+	}
+	return versionCodes, nil
+}
+
 // validateJSONKeyPath validates if service_account_json_key_path input value exists if defined and has file:// URL scheme.
 func (c Configs) validateJSONKeyPath() error {
 	if !strings.HasPrefix(string(c.JSONKeyPath), "file://") {
@@ -59,34 +466,406 @@ func (c Configs) validateJSONKeyPath() error {
 	return nil
 }
 
-// validateWhatsnewsDir validates if whatsnews_dir input value exists if provided.
+// validateReleaseNotesPath validates if release_notes_path input value exists and the language is set, if provided.
+func (c Configs) validateReleaseNotesPath() error {
+	if c.ReleaseNotesPath == "" {
+		return nil
+	}
+
+	if exist, err := pathutil.IsPathExists(c.ReleaseNotesPath); err != nil {
+		return fmt.Errorf("failed to check if release notes file exist at: %s, error: %s", c.ReleaseNotesPath, err)
+	} else if !exist {
+		return errors.New("release notes file not exist at: " + c.ReleaseNotesPath)
+	}
+	if c.ReleaseNotesLanguage == "" {
+		return errors.New("release notes language is required for the release notes file")
+	}
+	return nil
+}
+
+// releaseNotesJSON returns the release notes of the release_notes_json input, a JSON object of the release notes by
+// language.
+func (c Configs) releaseNotesJSON() (map[string]string, error) {
+	var releaseNotes map[string]string
+	if err := json.Unmarshal([]byte(c.ReleaseNotesJSON), &releaseNotes); err != nil {
+		return nil, fmt.Errorf("invalid release notes JSON, should be an object of the release notes by language, like {\"en-US\": \"...\"}, error: %s", err)
+	}
+	for language := range releaseNotes {
+		if strings.TrimSpace(language) == "" {
+			return nil, errors.New("invalid release notes JSON, the language of a release notes is empty")
+		}
+	}
+	return releaseNotes, nil
+}
+
+// hasListingChanges returns true if the store listings or the release notes are updated by the deploy operation.
+func (c Configs) hasListingChanges() bool {
+	return c.MetadataDir != "" || c.PromoVideoURL != "" || (c.hasReleaseNotes() && !c.UploadOnly)
+}
+
+// hasReleaseNotes returns true if any of the release notes inputs is set.
+func (c Configs) hasReleaseNotes() bool {
+	return c.WhatsnewsDir != "" || c.ReleaseNotesPath != "" || c.ReleaseNotesJSON != "" || c.ReleaseNotesFromGitMessage
+}
+
+// allReleaseNotes returns the release notes of every what's new directory (the whatsnews_dir input and the ones of
+// the track_whatsnews_dirs input) combined with the release notes file and JSON.
+func (c Configs) allReleaseNotes() ([]map[string]string, error) {
+	dirs := c.whatsnewsDirs()
+	if len(dirs) == 0 {
+		if !c.hasReleaseNotes() {
+			return nil, nil
+		}
+		dirs = []string{""}
+	}
+
+	var allReleaseNotes []map[string]string
+	for _, dir := range dirs {
+		dirConfigs := c
+		dirConfigs.WhatsnewsDir = dir
+		releaseNotes, err := dirConfigs.releaseNotes()
+		if err != nil {
+			return nil, err
+		}
+		allReleaseNotes = append(allReleaseNotes, releaseNotes)
+	}
+	return allReleaseNotes, nil
+}
+
+// validateReleaseNotesLength validates if the release notes are not longer than Google Play allows, unless they are
+// truncated.
+func (c Configs) validateReleaseNotesLength() error {
+	if c.TruncateReleaseNotes {
+		return nil
+	}
+
+	allReleaseNotes, err := c.allReleaseNotes()
+	if err != nil {
+		return err
+	}
+	for _, releaseNotes := range allReleaseNotes {
+		for language, notes := range releaseNotes {
+			if c.StripReleaseNotesMarkup {
+				notes = stripMarkup(notes)
+			}
+			if _, err := limitReleaseNotes(language, notes, false); err != nil {
+				return fmt.Errorf("%s, shorten them or set the truncate_release_notes input to true", err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateReleaseNotesLocales validates if the locales of the release notes are supported by Google Play.
+func (c Configs) validateReleaseNotesLocales() error {
+	if c.ReleaseNotesLocaleCheck == localeCheckOff {
+		return nil
+	}
+
+	allReleaseNotes, err := c.allReleaseNotes()
+	if err != nil {
+		return err
+	}
+	var locales []string
+	for _, releaseNotes := range allReleaseNotes {
+		for locale := range releaseNotes {
+			if !containsString(locales, locale) {
+				locales = append(locales, locale)
+			}
+		}
+	}
+	return checkLocales(c.ReleaseNotesLocaleCheck, locales)
+}
+
+// validateWhatsnewsURL validates if whatsnews_url input value is a http(s) URL, if provided.
+func (c Configs) validateWhatsnewsURL() error {
+	if c.WhatsnewsURL == "" {
+		return nil
+	}
+	if u, err := url.Parse(c.WhatsnewsURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid what's new URL: %s, should be a http(s) URL", c.WhatsnewsURL)
+	}
+	return nil
+}
+
+// validateWhatsnewsDir validates if the directories of the whatsnews_dir input exist, if provided.
 func (c Configs) validateWhatsnewsDir() error {
-	if c.WhatsnewsDir == "" {
+	for _, dir := range parseInputList(c.WhatsnewsDir) {
+		if exist, err := pathutil.IsDirExists(dir); err != nil {
+			return fmt.Errorf("failed to check if what's new directory exist at: %s, error: %s", dir, err)
+		} else if !exist {
+			return errors.New("what's new directory not exist at: " + dir)
+		}
+	}
+	return nil
+}
+
+// trackWhatsnewsDirs returns the what's new directories of the track_whatsnews_dirs input by track, given as a list
+// of <track>:<directory> entries. The directories of a track given in multiple entries are returned as a pipe
+// separated list, like the whatsnews_dir input.
+func (c Configs) trackWhatsnewsDirs() (map[string]string, error) {
+	dirs := map[string]string{}
+	for _, entry := range parseInputList(c.TrackWhatsnewsDirs) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid track what's new directory entry: %s, should be <track>:<directory>", entry)
+		}
+		track, dir := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if trackDirs, ok := dirs[track]; ok {
+			dir = trackDirs + "|" + dir
+		}
+		dirs[track] = dir
+	}
+	return dirs, nil
+}
+
+// localeAliases returns the locales of the what's new files by their alias from the locale_aliases input, given as a
+// list of <alias>:<locale> entries.
+func (c Configs) localeAliases() (map[string]string, error) {
+	aliases := map[string]string{}
+	for _, e := range parseInputList(c.LocaleAliases) {
+		for _, entry := range strings.Split(e, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			parts := strings.Split(entry, ":")
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+				return nil, fmt.Errorf("invalid locale alias entry: %s, should be <alias>:<locale>", entry)
+			}
+			aliases[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return aliases, nil
+}
+
+// consoleAppIDs returns the Play Console app IDs of the play_console_app_id input by package name. A single app ID
+// without a package name is returned with an empty key, it applies to every package.
+func (c Configs) consoleAppIDs() (map[string]string, error) {
+	appIDs := map[string]string{}
+	entries := parseInputList(c.ConsoleAppID)
+	if len(entries) == 1 && !strings.Contains(entries[0], ":") {
+		appIDs[""] = entries[0]
+		return appIDs, nil
+	}
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid Play Console app ID entry: %s, should be <package name>:<app id>", entry)
+		}
+		appIDs[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return appIDs, nil
+}
+
+// consoleAppID returns the Play Console app ID of the package, empty if it is not given.
+func (c Configs) consoleAppID() string {
+	appIDs, err := c.consoleAppIDs()
+	if err != nil {
+		return ""
+	}
+	if appID, ok := appIDs[c.PackageName]; ok {
+		return appID
+	}
+	return appIDs[""]
+}
+
+// validateTrackWhatsnewsDirs validates if the directories of the track_whatsnews_dirs input exist.
+func (c Configs) validateTrackWhatsnewsDirs() error {
+	dirs, err := c.trackWhatsnewsDirs()
+	if err != nil {
+		return err
+	}
+	for track, trackDirs := range dirs {
+		for _, dir := range parseInputList(trackDirs) {
+			if exist, err := pathutil.IsDirExists(dir); err != nil {
+				return fmt.Errorf("failed to check if what's new directory of %s track exist at: %s, error: %s", track, dir, err)
+			} else if !exist {
+				return fmt.Errorf("what's new directory of %s track not exist at: %s", track, dir)
+			}
+		}
+	}
+	return nil
+}
+
+// whatsnewsDirs returns every what's new directory of the whatsnews_dir and track_whatsnews_dirs inputs.
+func (c Configs) whatsnewsDirs() []string {
+	var dirs []string
+	if c.WhatsnewsDir != "" {
+		dirs = append(dirs, c.WhatsnewsDir)
+	}
+	trackDirs, _ := c.trackWhatsnewsDirs()
+	for _, dir := range trackDirs {
+		if !containsString(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// forTrack returns the configs of the release of the given track: the what's new directory of the track from the
+// track_whatsnews_dirs input replaces the whatsnews_dir input.
+func (c Configs) forTrack(track string) Configs {
+	trackDirs, _ := c.trackWhatsnewsDirs()
+	for trackName, dir := range trackDirs {
+		if strings.EqualFold(trackName, track) {
+			log.Printf("Using the what's new directory of %s track: %s", track, dir)
+			c.WhatsnewsDir = dir
+		}
+	}
+	return c
+}
+
+// validateExpansionfileDir validates if expansionfile_dir input value exists if provided.
+func (c Configs) validateExpansionfileDir() error {
+	if c.ExpansionfileDir == "" {
 		return nil
 	}
 
-	if exist, err := pathutil.IsDirExists(c.WhatsnewsDir); err != nil {
-		return fmt.Errorf("failed to check if what's new directory exist at: %s, error: %s", c.WhatsnewsDir, err)
+	if exist, err := pathutil.IsDirExists(c.ExpansionfileDir); err != nil {
+		return fmt.Errorf("failed to check if expansion file directory exist at: %s, error: %s", c.ExpansionfileDir, err)
 	} else if !exist {
-		return errors.New("what's new directory not exist at: " + c.WhatsnewsDir)
+		return errors.New("expansion file directory not exist at: " + c.ExpansionfileDir)
 	}
 	return nil
 }
 
-// validateMappingFile validates if mapping_file input value exists if provided.
-func (c Configs) validateMappingFile() error {
-	if c.MappingFile == "" {
+// validateMetadataDir validates if the metadata directory exists and its store listings are valid, if provided.
+func (c Configs) validateMetadataDir() error {
+	if c.MetadataDir == "" {
 		return nil
 	}
 
-	if exist, err := pathutil.IsPathExists(c.MappingFile); err != nil {
-		return fmt.Errorf("failed to check if mapping file exist at: %s, error: %s", c.MappingFile, err)
+	if exist, err := pathutil.IsDirExists(c.MetadataDir); err != nil {
+		return fmt.Errorf("failed to check if metadata directory exist at: %s, error: %s", c.MetadataDir, err)
 	} else if !exist {
-		return errors.New("mapping file not exist at: " + c.MappingFile)
+		return errors.New("metadata directory not exist at: " + c.MetadataDir)
+	}
+	if _, err := readListings(c.MetadataDir); err != nil {
+		return err
+	}
+	_, err := readImages(c.MetadataDir)
+	return err
+}
+
+// validateListingMedia validates the promo video URL and the feature graphic of the store listings, if provided.
+func (c Configs) validateListingMedia() error {
+	if c.PromoVideoURL != "" {
+		if u, err := url.Parse(c.PromoVideoURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid promo video URL: %s", c.PromoVideoURL)
+		}
+	}
+
+	if c.FeatureGraphicPath == "" {
+		return nil
+	}
+	if exist, err := pathutil.IsPathExists(c.FeatureGraphicPath); err != nil {
+		return fmt.Errorf("failed to check if feature graphic exist at: %s, error: %s", c.FeatureGraphicPath, err)
+	} else if !exist {
+		return errors.New("feature graphic not exist at: " + c.FeatureGraphicPath)
+	}
+	if _, ok := imageContentTypes[strings.ToLower(filepath.Ext(c.FeatureGraphicPath))]; !ok {
+		return fmt.Errorf("unsupported feature graphic format: %s, supported formats: PNG and JPEG", c.FeatureGraphicPath)
+	}
+	return nil
+}
+
+// validateMappingFile validates if the files provided via mapping_file input value exist if provided.
+func (c Configs) validateMappingFile() error {
+	for _, entry := range parseInputList(c.MappingFile) {
+		pth := parseDeobfuscationFileEntry(entry).path
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return fmt.Errorf("failed to check if mapping file exist at: %s, error: %s", pth, err)
+		} else if !exist {
+			return errors.New("mapping file not exist at: " + pth)
+		}
+	}
+	return nil
+}
+
+// validateSigningCertificateSHA256 validates if the signing_certificate_sha256 input values are hex encoded SHA-256
+// fingerprints if provided.
+func (c Configs) validateSigningCertificateSHA256() error {
+	for _, fingerprint := range parseInputList(c.SigningCertificateSHA256) {
+		if b, err := hex.DecodeString(normalizeCertificateFingerprint(fingerprint)); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid signing certificate SHA-256 fingerprint: %s", fingerprint)
+		}
 	}
 	return nil
 }
 
+// userFraction parses the user_fraction input given as a fraction (0.1) or in percent (10%), 0 if it is not set.
+func (c Configs) userFraction() (float64, error) {
+	if strings.TrimSpace(c.UserFractionInput) == "" {
+		return 0, nil
+	}
+	userFraction, err := parseUserFraction(c.UserFractionInput)
+	if err == nil && userFraction == 1 {
+		err = errors.New("a staged rollout can not reach all of the users, leave the input empty to release to all users")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid user fraction (%s), should be greater than 0 and less than 1 (or 100%%): %s", c.UserFractionInput, err)
+	}
+	return userFraction, nil
+}
+
+// vitalsThresholds returns the max crash rate and the max ANR rate of the release for increasing its rollout, 0 if
+// the input is not set.
+func (c Configs) vitalsThresholds() (maxCrashRate float64, maxANRRate float64, err error) {
+	parse := func(name, value string) (float64, error) {
+		if strings.TrimSpace(value) == "" {
+			return 0, nil
+		}
+		rate, err := parseFraction(value)
+		if err != nil || rate <= 0 || rate > 1 {
+			return 0, fmt.Errorf("invalid %s (%s), should be greater than 0 and at most 1 (or 100%%)", name, value)
+		}
+		return rate, nil
+	}
+
+	if maxCrashRate, err = parse("max crash rate", c.MaxCrashRate); err != nil {
+		return 0, 0, err
+	}
+	if maxANRRate, err = parse("max ANR rate", c.MaxANRRate); err != nil {
+		return 0, 0, err
+	}
+	return maxCrashRate, maxANRRate, nil
+}
+
+// uploadTimeout returns the timeout of a single upload call, 0 means no timeout.
+func (c Configs) uploadTimeout() time.Duration {
+	return time.Duration(c.UploadTimeout) * time.Second
+}
+
+// apiMaxAttempts returns the max number of attempts of a Google Play API call, 0 means a single attempt.
+func (c Configs) apiMaxAttempts() int {
+	if c.APIMaxAttempts < 1 {
+		return 1
+	}
+	return c.APIMaxAttempts
+}
+
+// apiTimeout returns the timeout of a single Google Play API call apart from the uploads, 0 means no timeout.
+func (c Configs) apiTimeout() time.Duration {
+	return time.Duration(c.APITimeout) * time.Second
+}
+
+// deployTimeout returns the timeout of the whole deploy, 0 means no timeout.
+func (c Configs) deployTimeout() time.Duration {
+	return time.Duration(c.DeployTimeout) * time.Second
+}
+
+// apiTransport returns the transport of the Google Play API calls, which retries the transient failures, limits the
+// duration of every attempt and logs the attempts if debug_http is set.
+func (c Configs) apiTransport() http.RoundTripper {
+	var transport http.RoundTripper = http.DefaultTransport
+	if c.DebugHTTP {
+		transport = debugTransport{base: transport}
+	}
+	transport = timeoutTransport{base: transport, timeout: c.apiTimeout()}
+	return newRetryTransport(transport, c.apiMaxAttempts())
+}
+
 func splitElements(list []string, sep string) (s []string) {
 	for _, e := range list {
 		s = append(s, strings.Split(e, sep)...)
@@ -94,8 +873,9 @@ func splitElements(list []string, sep string) (s []string) {
 	return
 }
 
-func parseAppList(list string) (apps []string) {
-	log.Debugf("Parsing app list: '%v'", list)
+// splitInputList splits the given newline or pipe separated input value and returns the whitespace trimmed elements,
+// including the empty ones.
+func splitInputList(list string) (elements []string) {
 	list = strings.TrimSpace(list)
 	if len(list) == 0 {
 		return nil
@@ -106,52 +886,185 @@ func parseAppList(list string) (apps []string) {
 		s = splitElements(s, sep)
 	}
 
-	for _, app := range s {
-		app = strings.TrimSpace(app)
-		if len(app) > 0 {
-			apps = append(apps, app)
-			log.Debugf("Found app: %v", app)
+	for _, e := range s {
+		elements = append(elements, strings.TrimSpace(e))
+	}
+	return
+}
+
+// parseInputList splits the given newline or pipe separated input value and returns the non-empty elements.
+func parseInputList(list string) (elements []string) {
+	for _, e := range splitInputList(list) {
+		if len(e) > 0 {
+			elements = append(elements, e)
 		}
 	}
 	return
 }
 
-// appPaths returns the app to deploy, by preferring .aab files.
-func (c Configs) appPaths() ([]string, []string) {
-	var apks, aabs, warnings []string
-	for _, pth := range parseAppList(c.AppPath) {
+func parseAppList(list string) (apps []string) {
+	log.Debugf("Parsing app list: '%v'", list)
+	for _, app := range parseInputList(list) {
+		apps = append(apps, app)
+		log.Debugf("Found app: %v", app)
+	}
+	return
+}
+
+// bitriseAppEnvs are the outputs of the Bitrise Android build steps, used as app list if the app_path input is empty.
+var bitriseAppEnvs = []string{"BITRISE_AAB_PATH", "BITRISE_APK_PATH", "BITRISE_APK_PATH_LIST"}
+
+// appList returns the apps of the app_path input, or the apps exported by the Bitrise Android build steps if the input
+// is empty.
+func (c Configs) appList() []string {
+	if apps := parseAppList(c.AppPath); len(apps) > 0 {
+		return apps
+	}
+
+	var apps []string
+	for _, env := range bitriseAppEnvs {
+		envApps := parseAppList(os.Getenv(env))
+		if len(envApps) > 0 {
+			log.Debugf("Using apps from %s: %v", env, envApps)
+		}
+		apps = append(apps, envApps...)
+	}
+	return apps
+}
+
+// expandAppList resolves the glob patterns, the directories and the remote apps' local path in the given app list, the
+// rest of the elements are returned as they are.
+func expandAppList(list []string, recursive bool) ([]string, error) {
+	var apps []string
+	for _, app := range list {
+		if isRemoteApp(app) {
+			pth, err := remoteAppLocalPath(app)
+			if err != nil {
+				return nil, err
+			}
+			apps = append(apps, pth)
+			continue
+		}
+
+		if isDir, err := pathutil.IsDirExists(app); err != nil {
+			return nil, fmt.Errorf("failed to check if app path is a directory: %s, error: %s", app, err)
+		} else if isDir {
+			dirApps, err := appsInDir(app, recursive)
+			if err != nil {
+				return nil, err
+			}
+			if len(dirApps) == 0 {
+				return nil, fmt.Errorf("no app found in directory: %s", app)
+			}
+
+			log.Printf("App directory %s contains:", app)
+			for _, pth := range dirApps {
+				log.Printf("- %s", pth)
+			}
+			apps = append(apps, dirApps...)
+			continue
+		}
+
+		if !hasGlobPattern(app) {
+			apps = append(apps, app)
+			continue
+		}
+
+		matches, err := globPaths(app)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve app path pattern: %s, error: %s", app, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no app found matching the pattern: %s", app)
+		}
+
+		log.Printf("App path pattern %s resolved to:", app)
+		for _, match := range matches {
+			log.Printf("- %s", match)
+		}
+		apps = append(apps, matches...)
+	}
+	return apps, nil
+}
+
+// appPaths returns the apps to deploy in the order they were provided, .apk and .aab files can be mixed.
+// APKs having the same version code as one of the provided .aab files are skipped, as the bundle already serves
+// that version (the default app_path input lists both the APK and the AAB output of the build).
+func (c Configs) appPaths() ([]string, []string, error) {
+	appList, err := expandAppList(c.appList(), c.AppPathRecursive)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.selectApps(appList)
+}
+
+// selectApps returns the apps to deploy of the given resolved app_path list, see appPaths.
+func (c Configs) selectApps(appList []string) ([]string, []string, error) {
+	var err error
+	if filters := parseInputList(c.AppFilter); len(filters) > 0 {
+		if appList, err = filterApps(appList, filters); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	appList, warnings := uniqueApps(appList)
+
+	var apps, aabs []string
+	for _, pth := range appList {
 		pth = strings.TrimSpace(pth)
 		ext := strings.ToLower(filepath.Ext(pth))
 		if ext == ".aab" {
 			log.Infof("Found .aab file: %v", pth)
+			apps = append(apps, pth)
 			aabs = append(aabs, pth)
 		} else if ext == ".apk" {
 			log.Infof("Found .apk file: %v", pth)
-			apks = append(apks, pth)
+			apps = append(apps, pth)
 		} else {
 			warnings = append(warnings, fmt.Sprintf("unknown app path extension in path: %s, supported extensions: .apk, .aab", pth))
 		}
 	}
 
-	if len(aabs) > 0 && len(apks) > 0 {
-		warnings = append(warnings, fmt.Sprintf("Both .aab and .apk files provided, using the .aab file(s): %s", strings.Join(aabs, ",")))
+	if len(aabs) == 0 || len(aabs) == len(apps) {
+		return apps, warnings, nil
+	}
+
+	aabVersionCodes := map[int64]string{}
+	for _, pth := range aabs {
+		if manifest, err := readAppManifest(pth); err == nil {
+			aabVersionCodes[manifest.VersionCode] = pth
+		}
 	}
 
-	if len(aabs) > 0 {
-		return aabs, warnings
+	var filtered []string
+	for _, pth := range apps {
+		if strings.ToLower(filepath.Ext(pth)) == ".apk" {
+			if manifest, err := readAppManifest(pth); err == nil {
+				if aab, ok := aabVersionCodes[manifest.VersionCode]; ok {
+					warnings = append(warnings, fmt.Sprintf("%s has the same version code (%d) as %s, using the .aab file", pth, manifest.VersionCode, aab))
+					continue
+				}
+			}
+		}
+		filtered = append(filtered, pth)
 	}
+	return filtered, warnings, nil
+}
 
-	return apks, warnings
+// packageAppPaths returns the app paths built for the package of the configs, skipping the apps of the other package
+// names if multiple ones are given.
+func (c Configs) packageAppPaths() ([]string, error) {
+	apps := appsOfPackage(c.Apps, c.PackageName)
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("no app provided for package %s", c.PackageName)
+	}
+	return apps, nil
 }
 
 // validateApps validates if files provided via app_path are existing files,
 // if app_path is empty it validates if files provided via app_path input are existing .apk or .aab files.
 func (c Configs) validateApps() error {
-	apps, warnings := c.appPaths()
-	for _, warn := range warnings {
-		log.Warnf(warn)
-	}
-
+	apps := c.Apps
 	if len(apps) == 0 {
 		return fmt.Errorf("no app provided")
 	}
@@ -164,6 +1077,25 @@ func (c Configs) validateApps() error {
 		}
 	}
 
+	for _, pth := range apps {
+		if err := validateAppContent(pth); err != nil {
+			return err
+		}
+	}
+
+	if err := validateAppManifests(apps, c.packageNames()); err != nil {
+		return err
+	}
+
+	if c.VerifyReleaseBuild {
+		if err := validateReleaseBuilds(apps); err != nil {
+			return err
+		}
+	}
+
+	if fingerprints := parseInputList(c.SigningCertificateSHA256); len(fingerprints) > 0 {
+		return validateSigningCertificates(apps, fingerprints)
+	}
 	return nil
 }
 
@@ -173,7 +1105,7 @@ func expansionFiles(appPaths []string, expansionFilePathConfig string) ([]string
 	// "main:/file/path/1.obb|patch:/file/path/2.obb"
 	var expansionFileEntries = []string{}
 	if strings.TrimSpace(expansionFilePathConfig) != "" {
-		expansionFileEntries = strings.Split(expansionFilePathConfig, "|")
+		expansionFileEntries = splitInputList(expansionFilePathConfig)
 
 		if len(appPaths) != len(expansionFileEntries) {
 			return []string{}, fmt.Errorf("mismatching number of APKs(%d) and Expansionfiles(%d)", len(appPaths), len(expansionFileEntries))
@@ -186,3 +1118,84 @@ func expansionFiles(appPaths []string, expansionFilePathConfig string) ([]string
 	}
 	return expansionFileEntries, nil
 }
+
+// appFilesByPath pairs the expansion_file_path and mapping_file entries with the given resolved app_path list, in its
+// original order before the apps are filtered, deduplicated or split by package, and returns them by app path.
+func (c Configs) appFilesByPath(appList []string) (map[string]string, map[string][]deobfuscationFile, error) {
+	expansionEntries, err := expansionFiles(appList, c.ExpansionfilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	mappingEntries, err := mappingFiles(appList, c.MappingFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expansionFilesByPath := map[string]string{}
+	for i, entry := range expansionEntries {
+		expansionFilesByPath[strings.TrimSpace(appList[i])] = entry
+	}
+	mappingFilesByPath := map[string][]deobfuscationFile{}
+	for i, files := range mappingEntries {
+		mappingFilesByPath[strings.TrimSpace(appList[i])] = files
+	}
+	return expansionFilesByPath, mappingFilesByPath, nil
+}
+
+// deobfuscationFile is a deobfuscation file (R8/ProGuard mapping or native debug symbols) of an app.
+type deobfuscationFile struct {
+	fileType string
+	path     string
+}
+
+// deobfuscationFileTypes are the supported deobfuscation file types, in their upload order.
+var deobfuscationFileTypes = []string{"proguard", "nativeCode"}
+
+// parseDeobfuscationFileEntry returns the type and path of a mapping_file entry. Entries can be prefixed with
+// `proguard:` or `native:`, entries without a known prefix are ProGuard mapping files.
+func parseDeobfuscationFileEntry(entry string) deobfuscationFile {
+	if strings.HasPrefix(entry, "proguard:") {
+		return deobfuscationFile{fileType: "proguard", path: strings.TrimPrefix(entry, "proguard:")}
+	}
+	if strings.HasPrefix(entry, "native:") {
+		return deobfuscationFile{fileType: "nativeCode", path: strings.TrimPrefix(entry, "native:")}
+	}
+	return deobfuscationFile{fileType: "proguard", path: entry}
+}
+
+// mappingFiles gets the deobfuscation files of each app from the received configuration. The files of each type are
+// matched to the apps separately: a single file is used for every app, multiple files are matched to the apps by
+// their position.
+func mappingFiles(appPaths []string, mappingFileConfig string) ([][]deobfuscationFile, error) {
+	// "/path/to/mapping1.txt|/path/to/mapping2.txt" or "proguard:/path/to/mapping.txt|native:/path/to/symbols.zip"
+	filesByType := map[string][]deobfuscationFile{}
+	for _, entry := range parseInputList(mappingFileConfig) {
+		file := parseDeobfuscationFileEntry(entry)
+		filesByType[file.fileType] = append(filesByType[file.fileType], file)
+	}
+	if len(filesByType) == 0 {
+		return nil, nil
+	}
+
+	entries := make([][]deobfuscationFile, len(appPaths))
+	for _, fileType := range deobfuscationFileTypes {
+		files := filesByType[fileType]
+		if len(files) == 0 {
+			continue
+		}
+		if len(files) != 1 && len(files) != len(appPaths) {
+			return nil, fmt.Errorf("mismatching number of apps(%d) and %s deobfuscation files(%d)", len(appPaths), fileType, len(files))
+		}
+
+		log.Infof("Found %v %s deobfuscation file(s) to upload.", len(files), fileType)
+		for i := range entries {
+			file := files[0]
+			if len(files) > 1 {
+				file = files[i]
+			}
+			log.Debugf("%v - %v", i+1, file.path)
+			entries[i] = append(entries[i], file)
+		}
+	}
+	return entries, nil
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_fraction(t *testing.T) {
@@ -78,6 +82,11 @@ func Test_parseAppList(t *testing.T) {
 			list:     "/bitrise/deploy/app-bitrise-signed.aab\n/bitrise/deploy/app.aab",
 			wantApps: []string{"/bitrise/deploy/app-bitrise-signed.aab", "/bitrise/deploy/app.aab"},
 		},
+		{
+			name:     "whitespace around elements",
+			list:     "  app1.apk \r\n\tapp2.apk | app.aab\t\n\n",
+			wantApps: []string{"app1.apk", "app2.apk", "app.aab"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -104,12 +113,12 @@ func TestConfigs_appPaths(t *testing.T) {
 			wantWarnings: nil,
 		},
 		{
-			name: "prefers aab",
+			name: "mixed apk and aab",
 			config: Configs{
 				AppPath: "app.apk|app.aab",
 			},
-			wantApps:     []string{"app.aab"},
-			wantWarnings: []string{"Both .aab and .apk files provided, using the .aab file(s): app.aab"},
+			wantApps:     []string{"app.apk", "app.aab"},
+			wantWarnings: nil,
 		},
 		{
 			name: "multiple .aab",
@@ -136,7 +145,10 @@ func TestConfigs_appPaths(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotApps, gotWarnings := tt.config.appPaths()
+			gotApps, gotWarnings, err := tt.config.appPaths()
+			if err != nil {
+				t.Fatalf("Configs.appPaths() error = %v", err)
+			}
 			if !reflect.DeepEqual(gotApps, tt.wantApps) {
 				t.Errorf("Configs.appPaths() gotApps = %v, want %v", gotApps, tt.wantApps)
 			}
@@ -147,6 +159,262 @@ func TestConfigs_appPaths(t *testing.T) {
 	}
 }
 
+func TestConfigs_appPaths_sameVersionCode(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestConfigs_appPaths_sameVersionCode")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	apk := filepath.Join(tmpDir, "app.apk")
+	createTestApp(t, apk, newTestManifest("io.bitrise.sample", 1), nil)
+	aab := filepath.Join(tmpDir, "app.aab")
+	createTestApp(t, aab, newTestManifest("io.bitrise.sample", 1), nil)
+	wearAPK := filepath.Join(tmpDir, "wear.apk")
+	createTestApp(t, wearAPK, newTestManifest("io.bitrise.sample", 2), nil)
+
+	apps, warnings, err := Configs{AppPath: strings.Join([]string{apk, aab, wearAPK}, "\n")}.appPaths()
+	require.NoError(t, err)
+	require.Equal(t, []string{aab, wearAPK}, apps)
+	require.Equal(t, 1, len(warnings))
+}
+
+func TestConfigs_appPaths_duplicates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestConfigs_appPaths_duplicates")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	apk := filepath.Join(tmpDir, "app.apk")
+	createTestApp(t, apk, newTestManifest("io.bitrise.sample", 1), nil)
+	content, err := ioutil.ReadFile(apk)
+	require.NoError(t, err)
+	copied := filepath.Join(tmpDir, "copy", "app.apk")
+	require.NoError(t, os.MkdirAll(filepath.Dir(copied), 0700))
+	require.NoError(t, ioutil.WriteFile(copied, content, 0600))
+	other := filepath.Join(tmpDir, "other.apk")
+	createTestApp(t, other, newTestManifest("io.bitrise.sample", 2), nil)
+
+	sameDir := filepath.Join(tmpDir, "copy", "..", "app.apk")
+	apps, warnings, err := Configs{AppPath: strings.Join([]string{apk, sameDir, copied, other}, "|")}.appPaths()
+	require.NoError(t, err)
+	require.Equal(t, []string{apk, other}, apps)
+	require.Equal(t, 2, len(warnings))
+}
+
+func TestConfigs_appList(t *testing.T) {
+	for env, value := range map[string]string{
+		"BITRISE_AAB_PATH":      "app.aab",
+		"BITRISE_APK_PATH":      "app-universal.apk",
+		"BITRISE_APK_PATH_LIST": "app-arm.apk|app-x86.apk",
+	} {
+		original, isSet := os.LookupEnv(env)
+		require.NoError(t, os.Setenv(env, value))
+		defer func(env string) {
+			if isSet {
+				require.NoError(t, os.Setenv(env, original))
+			} else {
+				require.NoError(t, os.Unsetenv(env))
+			}
+		}(env)
+	}
+
+	require.Equal(t, []string{"custom.aab"}, Configs{AppPath: "custom.aab"}.appList())
+	require.Equal(t, []string{"app.aab", "app-universal.apk", "app-arm.apk", "app-x86.apk"}, Configs{AppPath: `\n`}.appList())
+}
+
+func TestConfigs_validateStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Configs
+		wantErr bool
+	}{
+		{"empty", Configs{}, false},
+		{"draft", Configs{Status: releaseStatusDraft}, false},
+		{"draft with user fraction", Configs{Status: releaseStatusDraft, UserFraction: 0.5}, false},
+		{"in progress", Configs{Status: releaseStatusInProgress, UserFraction: 0.5}, false},
+		{"halted", Configs{Status: releaseStatusHalted, UserFraction: 0.1}, false},
+		{"pre-registration", Configs{PreRegistration: true}, false},
+		{"pre-registration draft", Configs{PreRegistration: true, Status: releaseStatusDraft}, false},
+		{"pre-registration with user fraction", Configs{PreRegistration: true, UserFraction: 0.5}, true},
+		{"pre-registration in progress", Configs{PreRegistration: true, Status: releaseStatusInProgress, UserFraction: 0.5}, true},
+		{"pre-registration halted", Configs{PreRegistration: true, Status: releaseStatusHalted, UserFraction: 0.5}, true},
+		{"halted without user fraction", Configs{Status: releaseStatusHalted}, true},
+		{"invalid", Configs{Status: "DRAFT"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validateStatus(); (err != nil) != tt.wantErr {
+				t.Errorf("Configs.validateStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigs_userFraction(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    float64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0.1", 0.1, false},
+		{"10%", 0.1, false},
+		{" 25 % ", 0.25, false},
+		{"0", 0, true},
+		{"1", 0, true},
+		{"100%", 0, true},
+		{"1.5", 0, true},
+		{"-0.2", 0, true},
+		{"ten", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Configs{UserFractionInput: tt.input}.userFraction()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Configs.userFraction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Configs.userFraction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigs_validateReleaseCountries(t *testing.T) {
+	require.NoError(t, Configs{}.validateReleaseCountries())
+	require.NoError(t, Configs{ReleaseCountries: "us, GB", IncludeRestOfWorld: true}.validateReleaseCountries())
+	require.Error(t, Configs{ReleaseCountries: "USA"}.validateReleaseCountries())
+	require.Error(t, Configs{ReleaseCountries: "u1"}.validateReleaseCountries())
+}
+
+func TestConfigs_packageNames(t *testing.T) {
+	require.Equal(t, []string{"io.bitrise.sample"}, Configs{PackageName: "io.bitrise.sample"}.packageNames())
+	require.Equal(t, []string{"io.bitrise.a", "io.bitrise.b"}, Configs{PackageName: "io.bitrise.a, io.bitrise.b"}.packageNames())
+	require.Equal(t, []string{"io.bitrise.a", "io.bitrise.b"}, Configs{PackageName: "io.bitrise.a\nio.bitrise.b\n"}.packageNames())
+}
+
+func TestConfigs_vitalsThresholds(t *testing.T) {
+	maxCrashRate, maxANRRate, err := Configs{}.vitalsThresholds()
+	require.NoError(t, err)
+	require.Equal(t, 0.0, maxCrashRate)
+	require.Equal(t, 0.0, maxANRRate)
+
+	maxCrashRate, maxANRRate, err = Configs{MaxCrashRate: "1%", MaxANRRate: "0.005"}.vitalsThresholds()
+	require.NoError(t, err)
+	require.Equal(t, 0.01, maxCrashRate)
+	require.Equal(t, 0.005, maxANRRate)
+
+	_, _, err = Configs{MaxCrashRate: "0"}.vitalsThresholds()
+	require.Error(t, err)
+	_, _, err = Configs{MaxANRRate: "high"}.vitalsThresholds()
+	require.Error(t, err)
+}
+
+func TestConfigs_lowerTracksToClear(t *testing.T) {
+	tracks, err := Configs{ClearLowerTracks: "internal:true, alpha: false\nQA:TRUE"}.lowerTracksToClear()
+	require.NoError(t, err)
+	require.Equal(t, []string{"internal", "QA"}, tracks)
+
+	_, err = Configs{ClearLowerTracks: "internal"}.lowerTracksToClear()
+	require.Error(t, err)
+	_, err = Configs{ClearLowerTracks: "internal:yes"}.lowerTracksToClear()
+	require.Error(t, err)
+}
+
+func TestConfigs_tracks(t *testing.T) {
+	require.Equal(t, []string{"alpha"}, Configs{Track: "alpha"}.tracks())
+	require.Equal(t, []string{"internal", "alpha"}, Configs{Track: "internal, alpha,"}.tracks())
+	require.Equal(t, []string{"internal", "QA"}, Configs{Track: "internal|QA"}.tracks())
+	require.Equal(t, []string{"production"}, Configs{Track: "rollout"}.tracks())
+	require.Equal(t, "production", Configs{Track: "rollout"}.track())
+	require.Equal(t, "", Configs{}.track())
+}
+
+func TestConfigs_validateLegacyRolloutTrack(t *testing.T) {
+	require.NoError(t, Configs{Track: "production"}.validateLegacyRolloutTrack())
+	require.NoError(t, Configs{Track: "rollout", UserFraction: 0.1}.validateLegacyRolloutTrack())
+	require.Error(t, Configs{Track: "rollout"}.validateLegacyRolloutTrack())
+}
+
+func TestConfigs_trackWhatsnewsDirs(t *testing.T) {
+	configs := Configs{WhatsnewsDir: "whatsnew", TrackWhatsnewsDirs: "production:notes/production\ninternal: notes/qa"}
+	dirs, err := configs.trackWhatsnewsDirs()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"production": "notes/production", "internal": "notes/qa"}, dirs)
+	require.Equal(t, []string{"notes/production", "notes/qa", "whatsnew"}, configs.whatsnewsDirs())
+
+	require.Equal(t, "notes/production", configs.forTrack("production").WhatsnewsDir)
+	require.Equal(t, "notes/qa", configs.forTrack("Internal").WhatsnewsDir)
+	require.Equal(t, "whatsnew", configs.forTrack("beta").WhatsnewsDir)
+
+	dirs, err = Configs{TrackWhatsnewsDirs: "production:notes/shared|production:notes/production"}.trackWhatsnewsDirs()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"production": "notes/shared|notes/production"}, dirs)
+
+	_, err = Configs{TrackWhatsnewsDirs: "production"}.trackWhatsnewsDirs()
+	require.Error(t, err)
+	_, err = Configs{TrackWhatsnewsDirs: "production:"}.trackWhatsnewsDirs()
+	require.Error(t, err)
+}
+
+func TestConfigs_localeAliases(t *testing.T) {
+	aliases, err := Configs{LocaleAliases: "en:en-US, pt:pt-BR\nde: de-DE"}.localeAliases()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"en": "en-US", "pt": "pt-BR", "de": "de-DE"}, aliases)
+
+	_, err = Configs{LocaleAliases: "en"}.localeAliases()
+	require.Error(t, err)
+	_, err = Configs{LocaleAliases: "en:"}.localeAliases()
+	require.Error(t, err)
+}
+
+func TestConfigs_consoleAppID(t *testing.T) {
+	require.Equal(t, "4972", Configs{PackageName: "io.bitrise.sample", ConsoleAppID: "4972"}.consoleAppID())
+	require.Equal(t, "", Configs{PackageName: "io.bitrise.sample"}.consoleAppID())
+
+	configs := Configs{ConsoleAppID: "io.bitrise.sample:4972|io.bitrise.other:4973"}
+	configs.PackageName = "io.bitrise.other"
+	require.Equal(t, "4973", configs.consoleAppID())
+	configs.PackageName = "io.bitrise.missing"
+	require.Equal(t, "", configs.consoleAppID())
+
+	_, err := Configs{ConsoleAppID: "io.bitrise.sample:4972|4973"}.consoleAppIDs()
+	require.Error(t, err)
+}
+
+func TestConfigs_releaseNotesJSON(t *testing.T) {
+	releaseNotes, err := Configs{ReleaseNotesJSON: `{"en-US": "Bug fixes", "de-DE": "Fehlerbehebungen"}`}.releaseNotesJSON()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"en-US": "Bug fixes", "de-DE": "Fehlerbehebungen"}, releaseNotes)
+
+	_, err = Configs{ReleaseNotesJSON: `["Bug fixes"]`}.releaseNotesJSON()
+	require.Error(t, err)
+	_, err = Configs{ReleaseNotesJSON: `{"en-US": 1}`}.releaseNotesJSON()
+	require.Error(t, err)
+	_, err = Configs{ReleaseNotesJSON: `{"": "Bug fixes"}`}.releaseNotesJSON()
+	require.Error(t, err)
+}
+
+func TestConfigs_validateListingMedia(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestConfigs_validateListingMedia")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+	featureGraphic := filepath.Join(tmpDir, "feature.png")
+	require.NoError(t, ioutil.WriteFile(featureGraphic, []byte("png"), 0644))
+	notes := filepath.Join(tmpDir, "feature.txt")
+	require.NoError(t, ioutil.WriteFile(notes, []byte("txt"), 0644))
+
+	require.NoError(t, Configs{}.validateListingMedia())
+	require.NoError(t, Configs{PromoVideoURL: "https://www.youtube.com/watch?v=abc", FeatureGraphicPath: featureGraphic}.validateListingMedia())
+	require.Error(t, Configs{PromoVideoURL: "youtube.com/watch?v=abc"}.validateListingMedia())
+	require.Error(t, Configs{FeatureGraphicPath: filepath.Join(tmpDir, "missing.png")}.validateListingMedia())
+	require.Error(t, Configs{FeatureGraphicPath: notes}.validateListingMedia())
+}
+
 func Test_expansionFiles(t *testing.T) {
 	tests := []struct {
 		name                    string
@@ -162,6 +430,8 @@ func Test_expansionFiles(t *testing.T) {
 		{"multipleOmit", []string{"w.apk", "x.apk", "y.apk", "z.apk"}, "main:a.obb|||patch:c.obb", []string{"main:a.obb", "", "", "patch:c.obb"}, false},
 		{"invalid1", []string{"x.apk", "y.apk", "z.apk"}, "main:a.obb", []string{}, true},
 		{"invalid2", []string{"x.apk", "y.apk", "z.apk"}, "", []string{}, false},
+		{"newlineSeparated", []string{"x.apk", "y.apk", "z.apk"}, "main:a.obb\npatch:b.obb\npatch:c.obb\n", []string{"main:a.obb", "patch:b.obb", "patch:c.obb"}, false},
+		{"newlineOmit", []string{"x.apk", "y.apk", "z.apk"}, " main:a.obb \n\n patch:c.obb ", []string{"main:a.obb", "", "patch:c.obb"}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -176,3 +446,52 @@ func Test_expansionFiles(t *testing.T) {
 		})
 	}
 }
+
+func Test_mappingFiles(t *testing.T) {
+	proguard := func(pth string) deobfuscationFile { return deobfuscationFile{fileType: "proguard", path: pth} }
+	native := func(pth string) deobfuscationFile { return deobfuscationFile{fileType: "nativeCode", path: pth} }
+
+	tests := []struct {
+		name              string
+		appPaths          []string
+		mappingFileConfig string
+		entries           [][]deobfuscationFile
+		wantErr           bool
+	}{
+		{"empty", []string{"x.aab", "y.aab"}, "", nil, false},
+		{"single", []string{"x.aab", "y.aab"}, "a.txt", [][]deobfuscationFile{{proguard("a.txt")}, {proguard("a.txt")}}, false},
+		{"pipe separated", []string{"x.aab", "y.aab"}, "a.txt|b.txt", [][]deobfuscationFile{{proguard("a.txt")}, {proguard("b.txt")}}, false},
+		{"newline separated", []string{"x.aab", "y.aab"}, "a.txt\nb.txt\n", [][]deobfuscationFile{{proguard("a.txt")}, {proguard("b.txt")}}, false},
+		{"mismatch", []string{"x.aab", "y.aab", "z.aab"}, "a.txt|b.txt", nil, true},
+		{"typed", []string{"x.aab"}, "proguard:a.txt|native:symbols.zip", [][]deobfuscationFile{{proguard("a.txt"), native("symbols.zip")}}, false},
+		{"typed per app", []string{"x.aab", "y.aab"}, "native:x.zip|a.txt|native:y.zip", [][]deobfuscationFile{{proguard("a.txt"), native("x.zip")}, {proguard("a.txt"), native("y.zip")}}, false},
+		{"native only", []string{"x.aab"}, "native:symbols.zip", [][]deobfuscationFile{{native("symbols.zip")}}, false},
+		{"typed mismatch", []string{"x.aab", "y.aab", "z.aab"}, "a.txt|native:x.zip|native:y.zip", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mappingFiles(tt.appPaths, tt.mappingFileConfig)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("mappingFiles() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.entries) {
+				t.Errorf("mappingFiles() got = %v, want %v", got, tt.entries)
+			}
+		})
+	}
+}
+
+func TestConfigs_appFilesByPath(t *testing.T) {
+	configs := Configs{
+		ExpansionfilePath: "main:x.obb||main:z.obb",
+		MappingFile:       "x.txt|y.txt|z.txt",
+	}
+	expansionFiles, mappingFiles, err := configs.appFilesByPath([]string{"x.apk", "y.aab", " z.apk "})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"x.apk": "main:x.obb", "y.aab": "", "z.apk": "main:z.obb"}, expansionFiles)
+	require.Equal(t, []deobfuscationFile{{fileType: "proguard", path: "z.txt"}}, mappingFiles["z.apk"])
+
+	_, _, err = Configs{MappingFile: "x.txt|y.txt"}.appFilesByPath([]string{"x.apk", "y.aab", "z.apk"})
+	require.Error(t, err)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+const consoleURLEnvKey = "GOOGLE_PLAY_CONSOLE_URL"
+
+// consoleURL returns the Play Console URL of the releases overview of the app, which lists the releases of every
+// track. The Play Console addresses apps by their app ID, not by their package name.
+func consoleURL(developerID, appID string) string {
+	return fmt.Sprintf("https://play.google.com/console/developers/%s/app/%s/releases/overview", url.PathEscape(developerID), url.PathEscape(appID))
+}
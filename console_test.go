@@ -0,0 +1,11 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_consoleURL(t *testing.T) {
+	require.Equal(t, "https://play.google.com/console/developers/8523/app/4972/releases/overview", consoleURL("8523", "4972"))
+}
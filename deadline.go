@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// editDeleteTimeout is the timeout of deleting an edit, which has its own context so the edit is deleted after the
+// deploy timed out too.
+const editDeleteTimeout = 30 * time.Second
+
+// deployContext returns the context of the Google Play API calls of the step, which is cancelled after the given
+// timeout if it is set.
+func deployContext(deployTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if deployTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), deployTimeout)
+}
+
+// deployErrorCategory returns the category of the given error message of publishing a package, which is a timeout if
+// the deploy context expired.
+func deployErrorCategory(ctx context.Context, phaseCategory errorCategory, errorString string) errorCategory {
+	if ctx.Err() == context.DeadlineExceeded {
+		return errorCategoryTimeout
+	}
+	return publishErrorCategory(phaseCategory, errorString)
+}
+
+// sleepContext waits for the given duration, or until the given context is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_deployErrorCategory(t *testing.T) {
+	errorString := "Failed to upload APKs: failed to upload app bundle, error: context deadline exceeded"
+
+	ctx, cancel := deployContext(0)
+	defer cancel()
+	require.Equal(t, errorCategoryUpload, deployErrorCategory(ctx, errorCategoryUpload, errorString))
+
+	expiredCtx, expiredCancel := deployContext(time.Nanosecond)
+	defer expiredCancel()
+	<-expiredCtx.Done()
+	require.Equal(t, errorCategoryTimeout, deployErrorCategory(expiredCtx, errorCategoryUpload, errorString))
+}
+
+func Test_sleepContext(t *testing.T) {
+	require.NoError(t, sleepContext(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, sleepContext(ctx, time.Hour))
+}
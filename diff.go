@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/androidpublisher/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// fieldChange is a change of a store listing field or a release notes.
+type fieldChange struct {
+	name     string
+	current  string
+	proposed string
+}
+
+// listingChanges returns the changes of the given listing compared to the current one. Empty fields of the listing
+// are left unchanged, so they are not compared.
+func listingChanges(current, listing *androidpublisher.Listing) []fieldChange {
+	if current == nil {
+		current = &androidpublisher.Listing{}
+	}
+
+	fields := []struct {
+		name              string
+		current, proposed string
+	}{
+		{"title", current.Title, listing.Title},
+		{"short description", current.ShortDescription, listing.ShortDescription},
+		{"full description", current.FullDescription, listing.FullDescription},
+		{"promo video", current.Video, listing.Video},
+	}
+
+	var changes []fieldChange
+	for _, field := range fields {
+		if field.proposed != "" && field.proposed != field.current {
+			changes = append(changes, fieldChange{
+				name:     fmt.Sprintf("%s %s", listing.Language, field.name),
+				current:  field.current,
+				proposed: field.proposed,
+			})
+		}
+	}
+	return changes
+}
+
+// releaseNotesChanges returns the changes of the given release notes of the track compared to the current ones.
+func releaseNotesChanges(track string, current, releaseNotes []*androidpublisher.LocalizedText) []fieldChange {
+	currentByLanguage := map[string]string{}
+	for _, text := range current {
+		currentByLanguage[text.Language] = text.Text
+	}
+
+	var changes []fieldChange
+	for _, text := range releaseNotes {
+		if text.Text != currentByLanguage[text.Language] {
+			changes = append(changes, fieldChange{
+				name:     fmt.Sprintf("%s track %s release notes", track, text.Language),
+				current:  currentByLanguage[text.Language],
+				proposed: text.Text,
+			})
+		}
+	}
+	return changes
+}
+
+// printChanges prints the given changes as a colorized diff.
+func printChanges(changes []fieldChange) {
+	for _, change := range changes {
+		log.Printf("%s:", change.name)
+		if change.current != "" {
+			for _, line := range strings.Split(change.current, "\n") {
+				log.Printf("%s", colorstring.Red("- "+line))
+			}
+		}
+		for _, line := range strings.Split(change.proposed, "\n") {
+			log.Printf("%s", colorstring.Green("+ "+line))
+		}
+	}
+}
+
+// currentListing returns the current store listing of the given language, nil if the app has no listing for it yet.
+func currentListing(ctx context.Context, service *androidpublisher.Service, packageName, appEditID, language string) (*androidpublisher.Listing, error) {
+	listing, err := androidpublisher.NewEditsListingsService(service).Get(packageName, appEditID, language).Context(ctx).Do()
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s store listing, error: %s", language, err)
+	}
+	return listing, nil
+}
+
+// diffListings prints the changes of the store listings and of the release notes the step would make, compared to
+// the current values.
+func diffListings(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) error {
+	var changes []fieldChange
+
+	var listings []*androidpublisher.Listing
+	if configs.MetadataDir != "" {
+		var err error
+		if listings, err = readListings(configs.MetadataDir); err != nil {
+			return err
+		}
+	}
+	for _, listing := range listings {
+		current, err := currentListing(ctx, service, configs.PackageName, appEdit.Id, listing.Language)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, listingChanges(current, listing)...)
+	}
+
+	if configs.PromoVideoURL != "" {
+		listingsResponse, err := androidpublisher.NewEditsListingsService(service).List(configs.PackageName, appEdit.Id).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed to list store listings, error: %s", err)
+		}
+		for _, current := range listingsResponse.Listings {
+			changes = append(changes, listingChanges(current, &androidpublisher.Listing{Language: current.Language, Video: configs.PromoVideoURL})...)
+		}
+	}
+
+	if configs.hasReleaseNotes() && !configs.UploadOnly {
+		var variables map[string]string
+		if apps, err := configs.packageAppPaths(); err == nil {
+			versionCodes, err := manifestVersionCodes(apps)
+			if err != nil {
+				return err
+			}
+			variables = releaseNotesVariables(configs, versionCodes)
+		}
+
+		for _, trackName := range configs.tracks() {
+			releaseNotes, err := releaseNotesTexts(configs.forTrack(trackName), variables)
+			if err != nil {
+				return err
+			}
+			track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, trackName)
+			if err != nil {
+				return err
+			}
+			var current []*androidpublisher.LocalizedText
+			if release := liveRelease(track); release != nil {
+				current = release.ReleaseNotes
+			}
+			changes = append(changes, releaseNotesChanges(track.Track, current, releaseNotes)...)
+		}
+	}
+
+	if len(changes) == 0 {
+		log.Printf("No store listing or release notes changes")
+		return nil
+	}
+	printChanges(changes)
+	return nil
+}
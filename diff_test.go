@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+func Test_listingChanges(t *testing.T) {
+	current := &androidpublisher.Listing{Language: "en-US", Title: "Sample", ShortDescription: "A sample app", FullDescription: "The sample app."}
+
+	require.Nil(t, listingChanges(current, &androidpublisher.Listing{Language: "en-US", Title: "Sample"}))
+	require.Equal(t, []fieldChange{
+		{name: "en-US short description", current: "A sample app", proposed: "The sample app"},
+		{name: "en-US promo video", current: "", proposed: "https://www.youtube.com/watch?v=abc"},
+	}, listingChanges(current, &androidpublisher.Listing{Language: "en-US", ShortDescription: "The sample app", Video: "https://www.youtube.com/watch?v=abc"}))
+	require.Equal(t, []fieldChange{
+		{name: "de-DE title", current: "", proposed: "Beispiel"},
+	}, listingChanges(nil, &androidpublisher.Listing{Language: "de-DE", Title: "Beispiel"}))
+}
+
+func Test_releaseNotesChanges(t *testing.T) {
+	current := []*androidpublisher.LocalizedText{{Language: "en-US", Text: "Bug fixes"}, {Language: "de-DE", Text: "Fehlerbehebungen"}}
+	releaseNotes := []*androidpublisher.LocalizedText{{Language: "de-DE", Text: "Fehlerbehebungen"}, {Language: "en-US", Text: "Dark mode"}, {Language: "hu-HU", Text: "Sötét mód"}}
+
+	require.Equal(t, []fieldChange{
+		{name: "beta track en-US release notes", current: "Bug fixes", proposed: "Dark mode"},
+		{name: "beta track hu-HU release notes", current: "", proposed: "Sötét mód"},
+	}, releaseNotesChanges("beta", current, releaseNotes))
+	require.Nil(t, releaseNotesChanges("beta", current, current))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const errorCategoryEnvKey = "GOOGLE_PLAY_DEPLOY_ERROR_CATEGORY"
+
+// errorCategory is the category of a failure of the step, exported and used as the exit code, so workflows can
+// react differently to transient and permanent failures.
+type errorCategory struct {
+	name     string
+	exitCode int
+}
+
+var (
+	errorCategoryUnknown        = errorCategory{name: "unknown", exitCode: 1}
+	errorCategoryValidation     = errorCategory{name: "validation", exitCode: 2}
+	errorCategoryAuth           = errorCategory{name: "auth", exitCode: 3}
+	errorCategoryDownload       = errorCategory{name: "download", exitCode: 4}
+	errorCategoryUpload         = errorCategory{name: "upload", exitCode: 5}
+	errorCategoryTrackConflict  = errorCategory{name: "track_conflict", exitCode: 6}
+	errorCategoryCommitRejected = errorCategory{name: "commit_rejected", exitCode: 7}
+	errorCategoryTransient      = errorCategory{name: "transient", exitCode: 8}
+	errorCategoryAPI            = errorCategory{name: "api", exitCode: 9}
+	errorCategoryTimeout        = errorCategory{name: "timeout", exitCode: 10}
+)
+
+// googleAPIErrorCodePattern matches the HTTP status code of the Google API error messages, for example
+// "googleapi: Error 503: Service unavailable".
+var googleAPIErrorCodePattern = regexp.MustCompile(`googleapi: Error (\d{3})`)
+
+// permissionErrorPattern matches the Google API errors of a service account without permission, for example
+// "googleapi: Error 403: The caller does not have permission, forbidden". Other 403 errors, like a release which can
+// not be rolled out, are categorized by the phase that failed.
+var permissionErrorPattern = regexp.MustCompile(`googleapi: Error 403: .*, (forbidden|insufficientPermissions|permissionDenied)\b`)
+
+// publishErrorCategory returns the category of the given error message of publishing a package. Expired edits,
+// server errors and rate limiting are transient, missing credentials and permissions are auth errors, the rest is
+// categorized by the given category of the phase that failed, if any.
+func publishErrorCategory(phaseCategory errorCategory, errorString string) errorCategory {
+	if isEditExpiredError(errorString) || strings.Contains(strings.ToLower(errorString), "ratelimitexceeded") {
+		return errorCategoryTransient
+	}
+	if match := googleAPIErrorCodePattern.FindStringSubmatch(errorString); match != nil {
+		switch code := match[1]; {
+		case code == "401" || permissionErrorPattern.MatchString(errorString):
+			return errorCategoryAuth
+		case code == "429" || strings.HasPrefix(code, "5"):
+			return errorCategoryTransient
+		}
+	}
+
+	if phaseCategory != (errorCategory{}) {
+		return phaseCategory
+	}
+	if strings.Contains(errorString, "googleapi: ") {
+		return errorCategoryAPI
+	}
+	return errorCategoryUnknown
+}
+
+// failWithCategory prints the error, exports its category and exits with the exit code of the category.
+func failWithCategory(category errorCategory, format string, v ...interface{}) {
+	log.Errorf(format, v...)
+	if err := exportEnvironment(errorCategoryEnvKey, category.name); err != nil {
+		log.Warnf("Failed to export error category: %s", err)
+	}
+	os.Exit(category.exitCode)
+}
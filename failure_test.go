@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_publishErrorCategory(t *testing.T) {
+	tests := []struct {
+		phaseCategory errorCategory
+		errorString   string
+		want          errorCategory
+	}{
+		{errorCategoryCommitRejected, "Failed to commit edit, error: googleapi: Error 400: This Edit has expired, editExpired", errorCategoryTransient},
+		{errorCategoryUpload, "Failed to upload APKs: failed to upload app bundle, error: googleapi: Error 503: Service unavailable", errorCategoryTransient},
+		{errorCategory{}, "Failed to list tracks, reason: googleapi: Error 429: Quota exceeded", errorCategoryTransient},
+		{errorCategory{}, "Failed to list tracks, reason: googleapi: Error 403: Rate Limit Exceeded, rateLimitExceeded", errorCategoryTransient},
+		{errorCategory{}, "Failed to perform edit insert call, error: googleapi: Error 403: The caller does not have permission, forbidden", errorCategoryAuth},
+		{errorCategory{}, "Failed to perform edit insert call, error: googleapi: Error 401: Request had invalid authentication credentials., unauthorized", errorCategoryAuth},
+		{errorCategoryTrackConflict, "Failed to update track, reason: googleapi: Error 403: You cannot rollout this release because it does not allow any existing users to upgrade to the newly added APKs., ApkNoUpgradePaths", errorCategoryTrackConflict},
+		{errorCategoryCommitRejected, "Failed to commit edit, error: googleapi: Error 403: The caller does not have permission, insufficientPermissions", errorCategoryAuth},
+		{errorCategory{}, "Failed to perform edit insert call, error: googleapi: Error 403: Some other reason, other", errorCategoryAPI},
+		{errorCategoryCommitRejected, "Failed to commit edit, error: googleapi: Error 400: Changes cannot be sent for review automatically", errorCategoryCommitRejected},
+		{errorCategoryUpload, "Failed to upload APKs: failed to open app (app.aab), error: no such file", errorCategoryUpload},
+		{errorCategoryUpload, "Failed to wait for processing: timeout", errorCategoryUpload},
+		{errorCategoryTrackConflict, "Failed to update track, reason: googleapi: Error 400: Version code 42 has already been used", errorCategoryTrackConflict},
+		{errorCategoryTrackConflict, "Failed to check version codes: version code 41 is not higher than the live version code 42", errorCategoryTrackConflict},
+		{errorCategory{}, "Failed to update store listings: googleapi: Error 400: Invalid title", errorCategoryAPI},
+		{errorCategory{}, "Failed to back up store listings: failed to write listing backup", errorCategoryUnknown},
+		{errorCategory{}, "Failed to upload the report: no phase category attached", errorCategoryUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.errorString, func(t *testing.T) {
+			require.Equal(t, tt.want, publishErrorCategory(tt.phaseCategory, tt.errorString))
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// redactedValue replaces the credentials in the HTTP debug log.
+const redactedValue = "[REDACTED]"
+
+var (
+	// credentialHeaderPattern matches the headers carrying credentials.
+	credentialHeaderPattern = regexp.MustCompile(`(?im)^(Authorization|Proxy-Authorization|Cookie|Set-Cookie|X-Goog-Api-Key):[^\r\n]*`)
+	// credentialJSONFieldPattern matches the JSON fields carrying credentials, for example the access token of the
+	// token response or the private key of the service account.
+	credentialJSONFieldPattern = regexp.MustCompile(`"(access_token|refresh_token|id_token|private_key|private_key_id|client_secret|assertion)"\s*:\s*"[^"]*"`)
+	// credentialFormFieldPattern matches the form and query parameters carrying credentials, for example the signed
+	// JWT assertion of the token request.
+	credentialFormFieldPattern = regexp.MustCompile(`\b(assertion|access_token|refresh_token|client_secret|key)=[^&\s]+`)
+)
+
+// redactHTTPDump replaces the credentials of the given HTTP request or response dump.
+func redactHTTPDump(dump string) string {
+	dump = credentialHeaderPattern.ReplaceAllString(dump, "$1: "+redactedValue)
+	dump = credentialJSONFieldPattern.ReplaceAllString(dump, `"$1": "`+redactedValue+`"`)
+	return credentialFormFieldPattern.ReplaceAllString(dump, "$1="+redactedValue)
+}
+
+// isTextContent returns true if the body of the given content type is printable: the app, expansion and
+// deobfuscation file uploads are left out of the log.
+func isTextContent(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "json") || strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "x-www-form-urlencoded")
+}
+
+// debugTransport logs the HTTP requests and responses, with their credentials redacted.
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip logs the given request, sends it with the base transport, then logs the response.
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if dump, err := httputil.DumpRequestOut(req, isTextContent(req.Header.Get("Content-Type"))); err != nil {
+		log.Warnf("Failed to dump HTTP request, error: %s", err)
+	} else {
+		log.Printf("HTTP request:\n%s", redactHTTPDump(string(dump)))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		log.Printf("HTTP request failed, error: %s", err)
+		return resp, err
+	}
+
+	if dump, err := httputil.DumpResponse(resp, isTextContent(resp.Header.Get("Content-Type"))); err != nil {
+		log.Warnf("Failed to dump HTTP response, error: %s", err)
+	} else {
+		log.Printf("HTTP response:\n%s", redactHTTPDump(string(dump)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_redactHTTPDump(t *testing.T) {
+	tests := []struct {
+		name string
+		dump string
+		want string
+	}{
+		{
+			name: "authorization header",
+			dump: "GET /androidpublisher/v3/applications/io.bitrise.sample/edits/1 HTTP/1.1\r\nAuthorization: Bearer ya29.secret\r\nAccept: application/json\r\n",
+			want: "GET /androidpublisher/v3/applications/io.bitrise.sample/edits/1 HTTP/1.1\r\nAuthorization: [REDACTED]\r\nAccept: application/json\r\n",
+		},
+		{
+			name: "token request assertion",
+			dump: "POST /token HTTP/1.1\r\n\r\ngrant_type=urn%3Aietf%3Aparams%3Aoauth%3Agrant-type%3Ajwt-bearer&assertion=eyJhbGciOi.secret",
+			want: "POST /token HTTP/1.1\r\n\r\ngrant_type=urn%3Aietf%3Aparams%3Aoauth%3Agrant-type%3Ajwt-bearer&assertion=[REDACTED]",
+		},
+		{
+			name: "token response",
+			dump: `{"access_token":"ya29.secret","expires_in":3599,"token_type":"Bearer"}`,
+			want: `{"access_token": "[REDACTED]","expires_in":3599,"token_type":"Bearer"}`,
+		},
+		{
+			name: "api response",
+			dump: `{"id": "edit-id", "expiryTimeSeconds": "1700000000"}`,
+			want: `{"id": "edit-id", "expiryTimeSeconds": "1700000000"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, redactHTTPDump(tt.dump))
+		})
+	}
+}
+
+func Test_isTextContent(t *testing.T) {
+	require.True(t, isTextContent("application/json; charset=UTF-8"))
+	require.True(t, isTextContent("application/x-www-form-urlencoded"))
+	require.True(t, isTextContent("text/plain"))
+	require.False(t, isTextContent("application/octet-stream"))
+	require.False(t, isTextContent("multipart/related; boundary=abc"))
+	require.False(t, isTextContent(""))
+}
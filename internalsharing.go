@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/androidpublisher/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const internalAppSharingURLEnvKey = "GOOGLE_PLAY_INTERNAL_APP_SHARING_URL"
+
+// uploadInternalAppSharingApps uploads the apps of the package to internal app sharing. Returns the download URLs of
+// the uploaded apps, in the order of the apps.
+func uploadInternalAppSharingApps(ctx context.Context, configs Configs, service *androidpublisher.Service) ([]string, error) {
+	appPaths, err := configs.packageAppPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	var downloadURLs []string
+	for i, appPath := range appPaths {
+		log.Printf("Uploading %v %d/%d", appPath, i+1, len(appPaths))
+		artifact, err := uploadInternalAppSharingApp(ctx, configs, service, appPath)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf(" download URL: %s", artifact.DownloadUrl)
+		downloadURLs = append(downloadURLs, artifact.DownloadUrl)
+	}
+	return downloadURLs, nil
+}
+
+// uploadInternalAppSharingApp uploads the given app (apk or aab) to internal app sharing.
+func uploadInternalAppSharingApp(ctx context.Context, configs Configs, service *androidpublisher.Service, appPath string) (*androidpublisher.InternalAppSharingArtifact, error) {
+	appFile, err := os.Open(appPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open app (%s), error: %s", appPath, err)
+	}
+	defer func() {
+		if err := appFile.Close(); err != nil {
+			log.Warnf("failed to close (%s)", appPath)
+		}
+	}()
+
+	ctx, cancel := uploadContext(ctx, configs.uploadTimeout())
+	defer cancel()
+
+	internalAppSharingService := androidpublisher.NewInternalappsharingartifactsService(service)
+	var artifact *androidpublisher.InternalAppSharingArtifact
+	if strings.ToLower(filepath.Ext(appPath)) == ".aab" {
+		call := internalAppSharingService.Uploadbundle(configs.PackageName)
+		call.Media(appFile, googleapi.ContentType("application/octet-stream"))
+		call.Context(ctx)
+		artifact, err = call.Do()
+	} else {
+		call := internalAppSharingService.Uploadapk(configs.PackageName)
+		call.Media(appFile, googleapi.ContentType("application/vnd.android.package-archive"))
+		call.Context(ctx)
+		artifact, err = call.Do()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload app (%s) to internal app sharing, error: %s", appPath, err)
+	}
+	appSha1, appSha256, err := fileHashes(appPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyUploadedHashes(appPath, appSha1, appSha256, "", artifact.Sha256); err != nil {
+		return nil, err
+	}
+	return artifact, nil
+}
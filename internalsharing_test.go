@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/androidpublisher/v3"
+	"google.golang.org/api/option"
+)
+
+func Test_uploadInternalAppSharingApp(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_uploadInternalAppSharingApp")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	apkPth := filepath.Join(tmpDir, "app.apk")
+	require.NoError(t, ioutil.WriteFile(apkPth, []byte("apk"), 0644))
+	_, apkSha256, err := fileHashes(apkPth)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		sha256   string
+		wantPath string
+		wantErr  bool
+	}{
+		{name: "apk", sha256: apkSha256, wantPath: "/applications/internalappsharing/io.bitrise.sample/artifacts/apk"},
+		{name: "hash mismatch", sha256: "aa", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path[strings.Index(r.URL.Path, "/applications/"):]
+				_, err := w.Write([]byte(fmt.Sprintf(`{"downloadUrl": "https://play.google.com/apps/test/abc", "sha256": "%s"}`, tt.sha256)))
+				require.NoError(t, err)
+			}))
+			defer server.Close()
+
+			service, err := androidpublisher.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+			require.NoError(t, err)
+
+			artifact, err := uploadInternalAppSharingApp(context.Background(), Configs{PackageName: "io.bitrise.sample"}, service, apkPth)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPath, gotPath)
+			require.Equal(t, "https://play.google.com/apps/test/abc", artifact.DownloadUrl)
+		})
+	}
+}
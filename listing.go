@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"google.golang.org/api/androidpublisher/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// The store listing files of a locale directory of the metadata directory and the max length of their content.
+const (
+	titleFileName            = "title.txt"
+	shortDescriptionFileName = "short_description.txt"
+	fullDescriptionFileName  = "full_description.txt"
+	videoFileName            = "video.txt"
+
+	maxTitleLength            = 30
+	maxShortDescriptionLength = 80
+	maxFullDescriptionLength  = 4000
+
+	imagesDirName = "images"
+
+	featureGraphicImageType = "featureGraphic"
+)
+
+// imageTypes are the image types of the store listing, the names of the directories (or image files for the single
+// image types) in the images directory of a locale.
+var imageTypes = []string{
+	featureGraphicImageType,
+	"icon",
+	"phoneScreenshots",
+	"promoGraphic",
+	"sevenInchScreenshots",
+	"tenInchScreenshots",
+	"tvBanner",
+	"tvScreenshots",
+	"wearScreenshots",
+}
+
+// imageContentTypes are the content types of the supported image file extensions.
+var imageContentTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+}
+
+// readListings reads the store listings of the given metadata directory, which has a subdirectory per locale (like
+// en-US) containing a title.txt, short_description.txt, full_description.txt and video.txt (the promo video URL) file.
+// Missing files are left unchanged in the listing.
+func readListings(metadataDir string) ([]*androidpublisher.Listing, error) {
+	entries, err := ioutil.ReadDir(metadataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata directory (%s), error: %s", metadataDir, err)
+	}
+
+	var listings []*androidpublisher.Listing
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		listing := &androidpublisher.Listing{Language: entry.Name()}
+		fields := []struct {
+			fileName  string
+			maxLength int
+			value     *string
+		}{
+			{titleFileName, maxTitleLength, &listing.Title},
+			{shortDescriptionFileName, maxShortDescriptionLength, &listing.ShortDescription},
+			{fullDescriptionFileName, maxFullDescriptionLength, &listing.FullDescription},
+			{videoFileName, 0, &listing.Video},
+		}
+
+		found := false
+		for _, field := range fields {
+			pth := filepath.Join(metadataDir, entry.Name(), field.fileName)
+			if exist, err := pathutil.IsPathExists(pth); err != nil {
+				return nil, fmt.Errorf("failed to check if listing file exist at: %s, error: %s", pth, err)
+			} else if !exist {
+				continue
+			}
+
+			content, err := readTextFile(pth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read listing file (%s), error: %s", pth, err)
+			}
+			content = strings.TrimSpace(content)
+			if length := utf8.RuneCountInString(content); field.maxLength > 0 && length > field.maxLength {
+				return nil, fmt.Errorf("%s is %d characters long, the maximum is %d", pth, length, field.maxLength)
+			}
+			*field.value = content
+			found = true
+		}
+		if found {
+			listings = append(listings, listing)
+		}
+	}
+
+	sort.Slice(listings, func(i, j int) bool { return listings[i].Language < listings[j].Language })
+	return listings, nil
+}
+
+// updateListings updates the store listings of the app with the listings of the metadata_dir input.
+func updateListings(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) error {
+	listings, err := readListings(configs.MetadataDir)
+	if err != nil {
+		return err
+	}
+	if len(listings) == 0 {
+		log.Warnf("No store listing found in %s", configs.MetadataDir)
+		return nil
+	}
+
+	editsListingsService := androidpublisher.NewEditsListingsService(service)
+	for _, listing := range listings {
+		log.Printf("Updating %s store listing", listing.Language)
+		if _, err := editsListingsService.Patch(configs.PackageName, appEdit.Id, listing.Language, listing).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to update %s store listing, error: %s", listing.Language, err)
+		}
+	}
+	return nil
+}
+
+// updateListingMedia sets the promo video and the feature graphic of the promo_video_url and feature_graphic_path
+// inputs on every store listing of the app.
+func updateListingMedia(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) error {
+	editsListingsService := androidpublisher.NewEditsListingsService(service)
+	listingsResponse, err := editsListingsService.List(configs.PackageName, appEdit.Id).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list store listings, error: %s", err)
+	}
+	if len(listingsResponse.Listings) == 0 {
+		log.Warnf("The app has no store listing, not updating the promo video and the feature graphic")
+		return nil
+	}
+
+	editsImagesService := androidpublisher.NewEditsImagesService(service)
+	for _, listing := range listingsResponse.Listings {
+		if configs.PromoVideoURL != "" {
+			log.Printf("Updating %s promo video", listing.Language)
+			if _, err := editsListingsService.Patch(configs.PackageName, appEdit.Id, listing.Language, &androidpublisher.Listing{Video: configs.PromoVideoURL}).Context(ctx).Do(); err != nil {
+				return fmt.Errorf("failed to update %s promo video, error: %s", listing.Language, err)
+			}
+		}
+
+		if configs.FeatureGraphicPath != "" {
+			log.Printf("Replacing %s %s image", listing.Language, featureGraphicImageType)
+			if _, err := editsImagesService.Deleteall(configs.PackageName, appEdit.Id, listing.Language, featureGraphicImageType).Context(ctx).Do(); err != nil {
+				return fmt.Errorf("failed to delete %s %s image, error: %s", listing.Language, featureGraphicImageType, err)
+			}
+			if err := uploadImage(ctx, editsImagesService, configs.PackageName, appEdit.Id, listing.Language, featureGraphicImageType, configs.FeatureGraphicPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listingImages are the images of a locale of the store listing by image type.
+type listingImages struct {
+	language string
+	images   map[string][]string
+}
+
+// readImages reads the images of the given metadata directory. The images of a locale are in its images directory, in
+// a subdirectory per image type (like en-US/images/phoneScreenshots/1.png), single images can also be given as a file
+// named after the image type (like en-US/images/featureGraphic.png). Images are sorted by file name. The locales without
+// an images directory are skipped.
+func readImages(metadataDir string) ([]listingImages, error) {
+	entries, err := ioutil.ReadDir(metadataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata directory (%s), error: %s", metadataDir, err)
+	}
+
+	var result []listingImages
+	for _, entry := range entries {
+		imagesDir := filepath.Join(metadataDir, entry.Name(), imagesDirName)
+		if exist, err := pathutil.IsDirExists(imagesDir); err != nil {
+			return nil, fmt.Errorf("failed to check if images directory exist at: %s, error: %s", imagesDir, err)
+		} else if !entry.IsDir() || !exist {
+			continue
+		}
+
+		imageEntries, err := ioutil.ReadDir(imagesDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read images directory (%s), error: %s", imagesDir, err)
+		}
+		images := map[string][]string{}
+		for _, imageEntry := range imageEntries {
+			pth := filepath.Join(imagesDir, imageEntry.Name())
+			imageType := strings.TrimSuffix(imageEntry.Name(), filepath.Ext(imageEntry.Name()))
+			if !containsString(imageTypes, imageType) {
+				log.Warnf("Unknown image type: %s, skipping %s, supported types: %s", imageType, pth, strings.Join(imageTypes, ", "))
+				continue
+			}
+
+			if !imageEntry.IsDir() {
+				if _, ok := imageContentTypes[strings.ToLower(filepath.Ext(pth))]; ok {
+					images[imageType] = append(images[imageType], pth)
+				}
+				continue
+			}
+
+			files, err := ioutil.ReadDir(pth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read images directory (%s), error: %s", pth, err)
+			}
+			for _, file := range files {
+				if _, ok := imageContentTypes[strings.ToLower(filepath.Ext(file.Name()))]; ok && !file.IsDir() {
+					images[imageType] = append(images[imageType], filepath.Join(pth, file.Name()))
+				}
+			}
+		}
+		result = append(result, listingImages{language: entry.Name(), images: images})
+	}
+	return result, nil
+}
+
+// uploadImages replaces the images of the store listing with the images of the metadata_dir input, per locale and
+// image type. The image types without images in the directory are left unchanged, unless the sync_images input is set:
+// then they are deleted, and the image types with the same images as the store listing are not uploaded again.
+func uploadImages(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) error {
+	localeImages, err := readImages(configs.MetadataDir)
+	if err != nil {
+		return err
+	}
+
+	editsImagesService := androidpublisher.NewEditsImagesService(service)
+	for _, locale := range localeImages {
+		for _, imageType := range imageTypes {
+			images, ok := locale.images[imageType]
+			if !ok && !configs.SyncImages {
+				continue
+			}
+
+			if configs.SyncImages {
+				current, err := editsImagesService.List(configs.PackageName, appEdit.Id, locale.language, imageType).Context(ctx).Do()
+				if err != nil {
+					return fmt.Errorf("failed to list %s %s images, error: %s", locale.language, imageType, err)
+				}
+				changed, err := imagesChanged(images, current.Images)
+				if err != nil {
+					return err
+				}
+				if !changed {
+					log.Debugf("%s %s images are up to date", locale.language, imageType)
+					continue
+				}
+			}
+
+			if len(images) == 0 {
+				log.Printf("Deleting %s %s images, they are not in the metadata directory", locale.language, imageType)
+			} else {
+				log.Printf("Replacing %s %s images", locale.language, imageType)
+			}
+			if _, err := editsImagesService.Deleteall(configs.PackageName, appEdit.Id, locale.language, imageType).Context(ctx).Do(); err != nil {
+				return fmt.Errorf("failed to delete %s %s images, error: %s", locale.language, imageType, err)
+			}
+			for _, pth := range images {
+				if err := uploadImage(ctx, editsImagesService, configs.PackageName, appEdit.Id, locale.language, imageType, pth); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// imagesChanged returns true if the given local images differ from the current images of the store listing, compared
+// by their sha256 hash and order.
+func imagesChanged(localPaths []string, current []*androidpublisher.Image) (bool, error) {
+	if len(localPaths) != len(current) {
+		return true, nil
+	}
+	for i, pth := range localPaths {
+		_, sha256Hash, err := fileHashes(pth)
+		if err != nil {
+			return false, fmt.Errorf("failed to calculate the hash of image (%s), error: %s", pth, err)
+		}
+		if !strings.EqualFold(sha256Hash, current[i].Sha256) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// uploadImage uploads the given image of the store listing.
+func uploadImage(ctx context.Context, editsImagesService *androidpublisher.EditsImagesService, packageName, appEditID, language, imageType, pth string) error {
+	f, err := os.Open(pth)
+	if err != nil {
+		return fmt.Errorf("failed to open image (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close image (%s), error: %s", pth, err)
+		}
+	}()
+
+	contentType := imageContentTypes[strings.ToLower(filepath.Ext(pth))]
+	if _, err := editsImagesService.Upload(packageName, appEditID, language, imageType).Media(f, googleapi.ContentType(contentType)).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to upload image (%s), error: %s", pth, err)
+	}
+	log.Printf(" uploaded: %s", pth)
+	return nil
+}
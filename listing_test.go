@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+func Test_readListings(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_readListings")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	writeFile := func(pth, content string) {
+		pth = filepath.Join(tmpDir, pth)
+		require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+		require.NoError(t, ioutil.WriteFile(pth, []byte(content), 0644))
+	}
+	writeFile("en-US/title.txt", "Sample\n")
+	writeFile("en-US/short_description.txt", "A sample app")
+	writeFile("en-US/full_description.txt", "The sample app of Bitrise.")
+	writeFile("de-DE/title.txt", "Beispiel")
+	writeFile("fr-FR/video.txt", "https://example.com")
+	writeFile("README.md", "metadata")
+
+	listings, err := readListings(tmpDir)
+	require.NoError(t, err)
+	require.Equal(t, []*androidpublisher.Listing{
+		{Language: "de-DE", Title: "Beispiel"},
+		{Language: "en-US", Title: "Sample", ShortDescription: "A sample app", FullDescription: "The sample app of Bitrise."},
+		{Language: "fr-FR", Video: "https://example.com"},
+	}, listings)
+
+	writeFile("hu-HU/short_description.txt", strings.Repeat("á", maxShortDescriptionLength+1))
+	_, err = readListings(tmpDir)
+	require.Error(t, err)
+
+	_, err = readListings(filepath.Join(tmpDir, "missing"))
+	require.Error(t, err)
+}
+
+func Test_readImages(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_readImages")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	var paths []string
+	for _, pth := range []string{
+		"en-US/images/phoneScreenshots/2.png",
+		"en-US/images/phoneScreenshots/1.jpg",
+		"en-US/images/phoneScreenshots/notes.txt",
+		"en-US/images/featureGraphic.png",
+		"en-US/images/unknown/1.png",
+		"de-DE/title.txt",
+	} {
+		pth = filepath.Join(tmpDir, pth)
+		require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+		require.NoError(t, ioutil.WriteFile(pth, []byte("content"), 0644))
+		paths = append(paths, pth)
+	}
+
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "fr-FR", "images"), 0755))
+
+	images, err := readImages(tmpDir)
+	require.NoError(t, err)
+	require.Equal(t, []listingImages{
+		{
+			language: "en-US",
+			images: map[string][]string{
+				"phoneScreenshots": {paths[1], paths[0]},
+				"featureGraphic":   {paths[3]},
+			},
+		},
+		{language: "fr-FR", images: map[string][]string{}},
+	}, images)
+}
+
+func Test_imagesChanged(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_imagesChanged")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	first, second := filepath.Join(tmpDir, "1.png"), filepath.Join(tmpDir, "2.png")
+	require.NoError(t, ioutil.WriteFile(first, []byte("first"), 0644))
+	require.NoError(t, ioutil.WriteFile(second, []byte("second"), 0644))
+	_, firstHash, err := fileHashes(first)
+	require.NoError(t, err)
+	_, secondHash, err := fileHashes(second)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		local   []string
+		current []*androidpublisher.Image
+		want    bool
+	}{
+		{"same images", []string{first, second}, []*androidpublisher.Image{{Sha256: firstHash}, {Sha256: secondHash}}, false},
+		{"no images", nil, nil, false},
+		{"reordered", []string{second, first}, []*androidpublisher.Image{{Sha256: firstHash}, {Sha256: secondHash}}, true},
+		{"added", []string{first, second}, []*androidpublisher.Image{{Sha256: firstHash}}, true},
+		{"removed", nil, []*androidpublisher.Image{{Sha256: firstHash}}, true},
+		{"modified", []string{first}, []*androidpublisher.Image{{Sha256: secondHash}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := imagesChanged(tt.local, tt.current)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
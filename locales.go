@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+// The values of the release_notes_locale_check and release_notes_coverage_check inputs.
+const (
+	localeCheckWarn = "warn"
+	localeCheckFail = "fail"
+	localeCheckOff  = "off"
+)
+
+// supportedLocales are the language codes Google Play supports for the store listing and the release notes.
+// https://support.google.com/googleplay/android-developer/answer/9844778
+var supportedLocales = []string{
+	"af", "am", "ar", "az-AZ", "be", "bg", "bn-BD", "ca", "cs-CZ", "da-DK", "de-DE", "el-GR", "en-AU", "en-CA",
+	"en-GB", "en-IN", "en-SG", "en-US", "en-ZA", "es-419", "es-ES", "es-US", "et", "eu-ES", "fa", "fa-AE", "fa-AF",
+	"fa-IR", "fi-FI", "fil", "fr-CA", "fr-FR", "gl-ES", "gu", "hi-IN", "hr", "hu-HU", "hy-AM", "id", "is-IS", "it-IT",
+	"iw-IL", "ja-JP", "ka-GE", "kk", "km-KH", "kn-IN", "ko-KR", "ky-KG", "lo-LA", "lt", "lv", "mk-MK", "ml-IN", "mn-MN",
+	"mr-IN", "ms", "ms-MY", "my-MM", "ne-NP", "nl-NL", "no-NO", "pa", "pl-PL", "pt-BR", "pt-PT", "rm", "ro", "ru-RU",
+	"si-LK", "sk", "sl", "sq", "sr", "sv-SE", "sw", "ta-IN", "te-IN", "th", "tr-TR", "uk", "ur", "vi", "zh-CN", "zh-HK",
+	"zh-TW", "zu",
+}
+
+// isSupportedLanguage returns true if the language subtag of the given locale is the language of a locale Google Play
+// supports, like de for de-DE or en for en_US.
+func isSupportedLanguage(locale string) bool {
+	language := strings.ToLower(strings.FieldsFunc(locale, func(r rune) bool { return r == '-' || r == '_' })[0])
+	for _, supported := range supportedLocales {
+		if strings.ToLower(strings.SplitN(supported, "-", 2)[0]) == language {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestLocale returns the supported locale the given unsupported locale is probably a typo of, like en-US for en_US
+// or en-us, or an empty string if there is no such locale.
+func suggestLocale(locale string) string {
+	normalized := strings.ToLower(strings.Replace(locale, "_", "-", -1))
+	for _, supported := range supportedLocales {
+		if strings.ToLower(supported) == normalized {
+			return supported
+		}
+	}
+	return ""
+}
+
+// checkLocales checks if the given release notes locales are supported by Google Play, and warns or fails according
+// to the release_notes_locale_check input.
+func checkLocales(check string, locales []string) error {
+	if check == localeCheckOff {
+		return nil
+	}
+
+	sort.Strings(locales)
+	var unsupported []string
+	for _, locale := range locales {
+		if containsString(supportedLocales, locale) {
+			continue
+		}
+
+		message := fmt.Sprintf("release notes locale %s is not supported by Google Play", locale)
+		if suggestion := suggestLocale(locale); suggestion != "" {
+			message += fmt.Sprintf(", did you mean %s?", suggestion)
+		}
+		if check == localeCheckFail {
+			unsupported = append(unsupported, message)
+		} else {
+			log.Warnf(message)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("unsupported release notes locales:\n%s", strings.Join(unsupported, "\n"))
+	}
+	return nil
+}
+
+// missingLocales returns the languages of the store listings without release notes.
+func missingLocales(listingLanguages []string, releaseNotes map[string]string) []string {
+	var missing []string
+	for _, language := range listingLanguages {
+		if _, ok := releaseNotes[language]; !ok {
+			missing = append(missing, language)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// checkReleaseNotesCoverage checks if the release notes of every track cover the languages of the store listings of
+// the app, and warns or fails according to the release_notes_coverage_check input.
+func checkReleaseNotesCoverage(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) error {
+	listingsResponse, err := androidpublisher.NewEditsListingsService(service).List(configs.PackageName, appEdit.Id).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list store listings, error: %s", err)
+	}
+	var listingLanguages []string
+	for _, listing := range listingsResponse.Listings {
+		listingLanguages = append(listingLanguages, listing.Language)
+	}
+
+	var uncovered []string
+	for _, track := range configs.tracks() {
+		releaseNotes, err := configs.forTrack(track).releaseNotes()
+		if err != nil {
+			return err
+		}
+		missing := missingLocales(listingLanguages, releaseNotes)
+		if len(missing) == 0 {
+			log.Printf("The release notes of %s track cover every store listing language", track)
+			continue
+		}
+
+		log.Warnf("The release notes of %s track are missing for %d of %d store listing languages:", track, len(missing), len(listingLanguages))
+		for _, language := range missing {
+			log.Warnf("- %s", language)
+		}
+		uncovered = append(uncovered, fmt.Sprintf("%s (%s)", track, strings.Join(missing, ", ")))
+	}
+	if len(uncovered) > 0 && configs.ReleaseNotesCoverageCheck == localeCheckFail {
+		return fmt.Errorf("release notes are missing for store listing languages of: %s", strings.Join(uncovered, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_suggestLocale(t *testing.T) {
+	require.Equal(t, "en-US", suggestLocale("en_US"))
+	require.Equal(t, "pt-BR", suggestLocale("pt-br"))
+	require.Equal(t, "", suggestLocale("english"))
+}
+
+func Test_checkLocales(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   string
+		locales []string
+		wantErr bool
+	}{
+		{name: "supported", check: localeCheckFail, locales: []string{"en-US", "de-DE", "es-419", "hu-HU"}},
+		{name: "typo, warn", check: localeCheckWarn, locales: []string{"en-US", "en_US"}},
+		{name: "typo, fail", check: localeCheckFail, locales: []string{"en-US", "en_US"}, wantErr: true},
+		{name: "unknown, fail", check: localeCheckFail, locales: []string{"xx-XX"}, wantErr: true},
+		{name: "unknown, off", check: localeCheckOff, locales: []string{"xx-XX"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLocales(tt.check, tt.locales)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_missingLocales(t *testing.T) {
+	listingLanguages := []string{"en-US", "hu-HU", "de-DE"}
+	require.Equal(t, []string{"de-DE", "hu-HU"}, missingLocales(listingLanguages, map[string]string{"en-US": "Bug fixes", "fr-FR": "Corrections"}))
+	require.Nil(t, missingLocales(listingLanguages, map[string]string{"en-US": "", "hu-HU": "", "de-DE": ""}))
+	require.Nil(t, missingLocales(nil, map[string]string{"en-US": "Bug fixes"}))
+}
+
+func Test_isSupportedLanguage(t *testing.T) {
+	require.True(t, isSupportedLanguage("de"))
+	require.True(t, isSupportedLanguage("en_US"))
+	require.True(t, isSupportedLanguage("fil"))
+	require.False(t, isSupportedLanguage("old"))
+	require.False(t, isSupportedLanguage("foo-bar"))
+}
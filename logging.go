@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	logLevelQuiet  = "quiet"
+	logLevelNormal = "normal"
+	logLevelDebug  = "debug"
+)
+
+// colorEscapePrefix is the prefix of the colored log messages: the phase headers, the results, the warnings and the
+// errors. The plain messages are the details of the phases.
+var colorEscapePrefix = []byte("\x1b[")
+
+// quietWriter writes only the colored log messages to the underlying writer, the plain ones are dropped.
+type quietWriter struct {
+	w io.Writer
+}
+
+// Write writes the given log message if it is colored. It reports the message as written either way.
+func (q quietWriter) Write(p []byte) (int, error) {
+	if !bytes.HasPrefix(p, colorEscapePrefix) {
+		return len(p), nil
+	}
+	return q.w.Write(p)
+}
+
+// setLogLevel configures the logger for the given log_level input value: the quiet level prints only the phase
+// headers, the results, the warnings and the errors, the debug level prints the debug messages too.
+func setLogLevel(level string) {
+	log.SetOutWriter(os.Stdout)
+	log.SetEnableDebugLog(level == logLevelDebug)
+	if level == logLevelQuiet {
+		log.SetOutWriter(quietWriter{w: os.Stdout})
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_quietWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := quietWriter{w: &buf}
+
+	for _, message := range []string{
+		colorstring.Blue("Update track"),
+		"production track will be updated.",
+		colorstring.Yellow("Release is created as a halted staged rollout"),
+		" updated track: production",
+		colorstring.Green("Track updated"),
+	} {
+		n, err := fmt.Fprintln(w, message)
+		require.NoError(t, err)
+		require.Equal(t, len(message)+1, n)
+	}
+
+	require.Equal(t, colorstring.Blue("Update track")+"\n"+
+		colorstring.Yellow("Release is created as a halted staged rollout")+"\n"+
+		colorstring.Green("Track updated")+"\n", buf.String())
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-utils/log"
@@ -16,62 +18,77 @@ import (
 
 const changesNotSentForReviewMessage = "Changes cannot be sent for review automatically. Please set the query parameter changesNotSentForReview to true"
 
-func failf(format string, v ...interface{}) {
-	log.Errorf(format, v...)
-	os.Exit(1)
-}
+const (
+	editIDEnvKey        = "GOOGLE_PLAY_EDIT_ID"
+	editCreatedAtEnvKey = "GOOGLE_PLAY_EDIT_CREATED_AT"
+	editExpiresAtEnvKey = "GOOGLE_PLAY_EDIT_EXPIRES_AT"
+	committedAtEnvKey   = "GOOGLE_PLAY_COMMITTED_AT"
+	sentForReviewEnvKey = "GOOGLE_PLAY_CHANGES_SENT_FOR_REVIEW"
+)
 
 // uploadApplications uploads every application file (apk or aab) to the Google Play. Returns the version codes of
 // the uploaded apps.
-func uploadApplications(configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) (map[int64]int, error) {
-	appPaths, _ := configs.appPaths()
+func uploadApplications(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit, summary *packageSummary, skipUploaded bool) (map[int64]int, error) {
+	appPaths, err := configs.packageAppPaths()
+	if err != nil {
+		return nil, err
+	}
 	versionCodes := make(map[int64]int)
+	var uploadedVersionCodes []int64
+	var checksums []appChecksum
+	deobfuscationFiles := map[int64][]string{}
 
 	var versionCodeListLog bytes.Buffer
 	versionCodeListLog.WriteString("New version codes to upload: ")
 
-	expansionFilePaths, err := expansionFiles(appPaths, configs.ExpansionfilePath)
-	if err != nil {
-		return nil, err
+	var discoveredExpansionFiles map[int64][]string
+	if configs.ExpansionfileDir != "" {
+		if discoveredExpansionFiles, err = discoverExpansionFiles(configs.ExpansionfileDir, configs.PackageName); err != nil {
+			return nil, err
+		}
 	}
 
-	for i, appPath := range appPaths {
-		log.Printf("Uploading %v %d/%d", appPath, i+1, len(appPaths))
-		versionCode := int64(0)
-		appFile, err := os.Open(appPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open app (%s), error: %s", appPath, err)
+	var uploadedApps map[string]int64
+	if skipUploaded {
+		if uploadedApps, err = uploadedAppHashes(ctx, service, configs.PackageName, appEdit.Id); err != nil {
+			return nil, err
 		}
+	}
 
-		if strings.ToLower(filepath.Ext(appPath)) == ".aab" {
-			bundle, err := uploadAppBundle(service, configs.PackageName, appEdit.Id, appFile)
-			if err != nil {
-				return nil, err
-			}
-			versionCode = bundle.VersionCode
-		} else {
-			apk, err := uploadAppApk(service, configs.PackageName, appEdit.Id, appFile)
-			if err != nil {
-				return nil, err
-			}
-			versionCode = apk.VersionCode
+	var detectedMappingFiles [][]deobfuscationFile
+	if len(configs.AppMappingFiles) == 0 && configs.DetectMappingFile {
+		detectedMappingFiles = detectMappingFiles(appPaths)
+	}
 
-			if len(expansionFilePaths) > 0 {
-				if err := uploadExpansionFiles(service, expansionFilePaths[i], configs.PackageName, appEdit.Id, versionCode); err != nil {
-					return nil, err
-				}
-			}
+	for i, appPath := range appPaths {
+		log.Printf("Uploading %v %d/%d", appPath, i+1, len(appPaths))
+
+		expansionFileEntry := configs.AppExpansionFiles[appPath]
+		appMappingFiles := configs.AppMappingFiles[appPath]
+		if len(detectedMappingFiles) > 0 {
+			appMappingFiles = detectedMappingFiles[i]
 		}
 
-		// Upload mapping.txt
-		if configs.MappingFile != "" && versionCode != 0 {
-			if err := uploadMappingFile(service, configs, appEdit.Id, versionCode); err != nil {
-				return nil, err
-			}
-			if i < len(appPaths)-1 {
-				fmt.Println()
+		uploadStartedAt := time.Now()
+		versionCode, checksum, err := uploadApplication(ctx, configs, service, appEdit, appPath, uploadedApps, expansionFileEntry, discoveredExpansionFiles, appMappingFiles)
+		if err != nil {
+			logUploadReport(appPaths, uploadedVersionCodes, i)
+			return nil, err
+		}
+		summary.recordPhase("upload "+filepath.Base(appPath), uploadStartedAt)
+		uploadedVersionCodes = append(uploadedVersionCodes, versionCode)
+		checksums = append(checksums, checksum)
+		for _, mappingFile := range appMappingFiles {
+			if !containsString(deobfuscationFiles[versionCode], mappingFile.fileType) {
+				deobfuscationFiles[versionCode] = append(deobfuscationFiles[versionCode], mappingFile.fileType)
 			}
 		}
+		if _, ok := deobfuscationFiles[versionCode]; !ok {
+			deobfuscationFiles[versionCode] = []string{}
+		}
+		if len(appMappingFiles) > 0 && i < len(appPaths)-1 {
+			fmt.Println()
+		}
 
 		versionCodes[versionCode]++
 		versionCodeListLog.WriteString(fmt.Sprintf("%d", versionCode))
@@ -81,18 +98,149 @@ func uploadApplications(configs Configs, service *androidpublisher.Service, appE
 	}
 	log.Printf("Done uploading of %v apps", len(appPaths))
 	log.Printf(versionCodeListLog.String())
+	if err := exportAppChecksums(checksums); err != nil {
+		return nil, err
+	}
+	summary.DeobfuscationFiles = deobfuscationFiles
+	if err := exportEnvironment(deobfuscationFilesEnvKey, deobfuscationFilesList(deobfuscationFiles)); err != nil {
+		return nil, err
+	}
 	return versionCodes, nil
 }
 
-// updateTracks updates the given track with a new release with the given version codes.
-func updateTracks(configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit, versionCodes []int64) error {
-	editsTracksService := androidpublisher.NewEditsTracksService(service)
+// uploadApplication uploads the given application file (apk or aab) with its expansion and deobfuscation files.
+// Returns the version code and the checksum of the uploaded app.
+func uploadApplication(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit, appPath string, uploadedApps map[string]int64, expansionFileEntry string, discoveredExpansionFiles map[int64][]string, mappingFiles []deobfuscationFile) (int64, appChecksum, error) {
+	versionCode := int64(0)
+	appFile, err := os.Open(appPath)
+	if err != nil {
+		return 0, appChecksum{}, fmt.Errorf("failed to open app (%s), error: %s", appPath, err)
+	}
+	defer func() {
+		if err := appFile.Close(); err != nil {
+			log.Warnf("failed to close (%s)", appPath)
+		}
+	}()
 
-	newRelease, err := createTrackRelease(configs, versionCodes)
+	appSha1, appSha256, err := fileHashes(appPath)
 	if err != nil {
-		return err
+		return 0, appChecksum{}, err
+	}
+	checksum := appChecksum{path: appPath, sha256: appSha256}
+	if uploadedVersionCode, ok := uploadedApps[appSha256]; ok {
+		log.Printf(" already uploaded with version code %d, skipping upload", uploadedVersionCode)
+		versionCode = uploadedVersionCode
+		checksum.playSha256 = appSha256
+	} else if strings.ToLower(filepath.Ext(appPath)) == ".aab" {
+		bundle, err := uploadAppBundle(ctx, service, configs.PackageName, appEdit.Id, appFile, configs.uploadTimeout(), configs.DeviceTierConfigID)
+		if err != nil {
+			return 0, appChecksum{}, err
+		}
+		if err := verifyUploadedHashes(appPath, appSha1, appSha256, bundle.Sha1, bundle.Sha256); err != nil {
+			return 0, appChecksum{}, err
+		}
+		versionCode = bundle.VersionCode
+		checksum.playSha256 = bundle.Sha256
+	} else {
+		apk, err := uploadAppApk(ctx, service, configs.PackageName, appEdit.Id, appFile, configs.uploadTimeout())
+		if err != nil {
+			return 0, appChecksum{}, err
+		}
+		if apk.Binary != nil {
+			if err := verifyUploadedHashes(appPath, appSha1, appSha256, apk.Binary.Sha1, apk.Binary.Sha256); err != nil {
+				return 0, appChecksum{}, err
+			}
+			checksum.playSha256 = apk.Binary.Sha256
+		}
+		versionCode = apk.VersionCode
+	}
+
+	// Upload or reference the expansion files of the apk, also if the apk itself was uploaded earlier, as the
+	// expansion files of the skipped apk are not part of this edit otherwise.
+	if strings.ToLower(filepath.Ext(appPath)) != ".aab" {
+		expansionFileEntries := discoveredExpansionFiles[versionCode]
+		if expansionFileEntry != "" {
+			expansionFileEntries = []string{expansionFileEntry}
+		}
+		for _, entry := range expansionFileEntries {
+			if err := uploadExpansionFiles(ctx, service, entry, configs.PackageName, appEdit.Id, versionCode, configs.uploadTimeout()); err != nil {
+				return 0, appChecksum{}, err
+			}
+		}
 	}
 
+	// Upload mapping.txt
+	if versionCode != 0 {
+		for _, mappingFile := range mappingFiles {
+			if err := uploadMappingFile(ctx, service, configs.PackageName, appEdit.Id, mappingFile, versionCode, configs.uploadTimeout()); err != nil {
+				return 0, appChecksum{}, err
+			}
+		}
+	}
+	return versionCode, checksum, nil
+}
+
+// logUploadReport prints which apps were uploaded, which one failed and which ones were not attempted.
+func logUploadReport(appPaths []string, uploadedVersionCodes []int64, failedIdx int) {
+	log.Warnf("Upload failed, none of the apps are released")
+	for i, versionCode := range uploadedVersionCodes {
+		log.Printf("- uploaded: %s (version code: %d)", appPaths[i], versionCode)
+	}
+	log.Printf("- failed: %s", appPaths[failedIdx])
+	for _, pth := range appPaths[failedIdx+1:] {
+		log.Printf("- not uploaded: %s", pth)
+	}
+}
+
+// updateTracks updates the given tracks with a new release with the given version codes.
+func updateTracks(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit, versionCodes []int64) error {
+	for _, track := range configs.tracks() {
+		newRelease, err := createTrackRelease(configs.forTrack(track), versionCodes)
+		if err != nil {
+			return err
+		}
+		if configs.AppendVersionCodes || configs.UpdateNamedRelease || configs.RemoveDraftReleases || configs.ExistingRolloutPolicy != existingRolloutReplace {
+			currentTrack, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, track)
+			if err != nil {
+				return err
+			}
+			if configs.RemoveDraftReleases && removeDraftReleases(currentTrack) > 0 {
+				if err := updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, currentTrack); err != nil {
+					return fmt.Errorf("failed to remove the draft releases of %s track, error: %s", track, err)
+				}
+			}
+			if configs.AppendVersionCodes {
+				appendLiveVersionCodes(newRelease, currentTrack)
+			}
+			if configs.UpdateNamedRelease && replaceNamedRelease(currentTrack, newRelease) {
+				log.Infof("%s track will be updated.", currentTrack.Track)
+				if err := updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, currentTrack); err != nil {
+					return fmt.Errorf("failed to update %s track, error: %s", track, err)
+				}
+				continue
+			}
+			releases, err := resolveExistingRollout(configs.ExistingRolloutPolicy, currentTrack, newRelease)
+			if err != nil {
+				return err
+			}
+			if releases != nil {
+				log.Infof("%s track will be updated.", currentTrack.Track)
+				currentTrack.Releases = releases
+				if err := updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, currentTrack); err != nil {
+					return fmt.Errorf("failed to update %s track, error: %s", track, err)
+				}
+				continue
+			}
+		}
+		if err := updateTrack(ctx, service, configs.PackageName, appEdit.Id, track, newRelease); err != nil {
+			return fmt.Errorf("failed to update %s track, error: %s", track, err)
+		}
+	}
+	return nil
+}
+
+// updateTrack sets the given release on the given track.
+func updateTrack(ctx context.Context, service *androidpublisher.Service, packageName, appEditID, track string, release *androidpublisher.TrackRelease) error {
 	// Note we get error if we creating multiple instances of a release with the Completed status.
 	// Example: "error: googleapi: Error 400: Too many completed releases specified., releasesTooManyCompletedReleases".
 	// Also receiving error when deploying a Completed release when a rollout is in progress:
@@ -102,18 +250,16 @@ func updateTracks(configs Configs, service *androidpublisher.Service, appEdit *a
 	// inProgress preserves complete release even if not specified in releases array.
 	// In case only a completed release specified, it halts inProgress releases.
 
-	log.Infof("%s track will be updated.", configs.Track)
-	editsTracksUpdateCall := editsTracksService.Update(configs.PackageName, appEdit.Id, configs.Track, &androidpublisher.Track{
-		Track:    configs.Track,
-		Releases: []*androidpublisher.TrackRelease{newRelease},
-	})
-	track, err := editsTracksUpdateCall.Do()
+	// A track can carry multiple releases (for example a completed release and a staged rollout or a draft), so the new
+	// release replaces only the release with the same status, the other releases of the track are kept.
+	currentTrack, err := getTrack(ctx, service, packageName, appEditID, track)
 	if err != nil {
-		return fmt.Errorf("update call failed, error: %s", err)
+		return err
 	}
 
-	log.Printf(" updated track: %s", track.Track)
-	return nil
+	log.Infof("%s track will be updated.", currentTrack.Track)
+	currentTrack.Releases = mergeRelease(currentTrack, release)
+	return updateTrackReleases(ctx, service, packageName, appEditID, currentTrack)
 }
 
 func versionCodeMapToSlice(codeMap map[int64]int) []int64 {
@@ -131,93 +277,659 @@ func versionCodeMapToSlice(codeMap map[int64]int) []int64 {
 func main() {
 	//
 	// Getting configs
+	startedAt := time.Now()
 	fmt.Println()
 	log.Infof("Getting configuration")
 	var configs Configs
 	if err := stepconf.Parse(&configs); err != nil {
-		failf("Couldn't create config: %s\n", err)
+		failWithCategory(errorCategoryValidation, "Couldn't create config: %s\n", err)
+	}
+	setLogLevel(configs.LogLevel)
+	if configs.LogLevel != logLevelQuiet {
+		stepconf.Print(configs)
+	}
+	userFraction, err := configs.userFraction()
+	if err != nil {
+		failWithCategory(errorCategoryValidation, err.Error())
+	}
+	configs.UserFraction = userFraction
+	if configs.isDeploy() {
+		if err := downloadRemoteApps(configs.appList()); err != nil {
+			failWithCategory(errorCategoryDownload, "Failed to download apps: %s", err)
+		}
+		appList, err := expandAppList(configs.appList(), configs.AppPathRecursive)
+		if err != nil {
+			failWithCategory(errorCategoryValidation, "Failed to find apps: %s", err)
+		}
+		apps, warnings, err := configs.selectApps(appList)
+		if err != nil {
+			failWithCategory(errorCategoryValidation, "Failed to find apps: %s", err)
+		}
+		for _, warn := range warnings {
+			log.Warnf(warn)
+		}
+		configs.Apps = apps
+		if configs.AppExpansionFiles, configs.AppMappingFiles, err = configs.appFilesByPath(appList); err != nil {
+			failWithCategory(errorCategoryValidation, err.Error())
+		}
+	}
+	if err := configs.validateWhatsnewsURL(); err != nil {
+		failWithCategory(errorCategoryValidation, err.Error())
+	}
+	if configs.WhatsnewsURL != "" {
+		dir, err := downloadWhatsnews(configs.WhatsnewsURL)
+		if err != nil {
+			failWithCategory(errorCategoryDownload, "Failed to download what's new files: %s", err)
+		}
+		configs.WhatsnewsDir = strings.Join(append(parseInputList(configs.WhatsnewsDir), dir), "|")
 	}
-	stepconf.Print(configs)
 	if err := configs.validate(); err != nil {
-		failf(err.Error())
+		failWithCategory(errorCategoryValidation, err.Error())
 	}
 	log.Donef("Configuration read successfully")
 
+	ctx, cancel := deployContext(configs.deployTimeout())
+	defer cancel()
+
 	//
 	// Create client and service
 	fmt.Println()
 	log.Infof("Authenticating")
-	client, err := createHTTPClient(string(configs.JSONKeyPath))
+	authStartedAt := time.Now()
+	client, err := createHTTPClient(string(configs.JSONKeyPath), configs.apiTransport(), androidpublisher.AndroidpublisherScope)
 	if err != nil {
-		failf("Failed to create HTTP client: %v", err)
+		failWithCategory(errorCategoryAuth, "Failed to create HTTP client: %v", err)
 	}
-	service, err := androidpublisher.NewService(context.TODO(), option.WithHTTPClient(client))
+	service, err := androidpublisher.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		failf("Failed to create publisher service, error: %s", err)
+		failWithCategory(errorCategoryAuth, "Failed to create publisher service, error: %s", err)
 	}
+	authTiming := newPhaseTiming("auth", authStartedAt)
 	log.Donef("Authenticated client created")
 
-	errorString := executeEdit(service, configs, false)
+	if configs.ChangesNotSentForReview {
+		log.Warnf("The changes are not sent for review automatically. Please make sure to send the changes to review from Google Play Console UI.")
+	}
+
+	packageNames := configs.packageNames()
+	summary := deploySummary{StartedAt: startedAt.Format(time.RFC3339), Timings: []phaseTiming{authTiming}}
+	var failed []string
+	var errorString string
+	var failureCategory errorCategory
+	var sharingURLs []string
+	for i, packageName := range packageNames {
+		packageConfigs := configs
+		packageConfigs.PackageName = packageName
+		if len(packageNames) > 1 {
+			fmt.Println()
+			log.Infof("Publishing %s (%d/%d)", packageName, i+1, len(packageNames))
+		}
+
+		packageSummary := newPackageSummary(packageConfigs)
+		if (configs.DeploySummaryDir != "" || configs.AnnotateBuild) && configs.isDeploy() {
+			if err := packageSummary.recordArtifacts(packageConfigs); err != nil {
+				log.Warnf("Failed to add the apps to the deploy summary: %s", err)
+			}
+		}
+		errorString = publishPackage(ctx, service, packageConfigs, packageSummary)
+		if errorString != "" && ctx.Err() == context.DeadlineExceeded {
+			errorString = fmt.Sprintf("Deploy timed out after %s: %s", configs.deployTimeout(), errorString)
+		}
+		packageSummary.Error = errorString
+		if errorString != "" {
+			packageSummary.ErrorCategory = deployErrorCategory(ctx, packageSummary.phaseCategory, errorString).name
+		}
+		if appID := packageConfigs.consoleAppID(); errorString == "" && configs.ConsoleDeveloperID != "" && appID != "" {
+			packageSummary.ConsoleURL = consoleURL(configs.ConsoleDeveloperID, appID)
+			log.Printf("Play Console: %s", packageSummary.ConsoleURL)
+			if err := exportEnvironment(consoleURLEnvKey, packageSummary.ConsoleURL); err != nil {
+				log.Warnf("Failed to export Play Console URL: %s", err)
+			}
+		}
+		if errorString == "" && configs.InternalAppSharing && configs.isDeploy() && !configs.ListingDryRun {
+			fmt.Println()
+			log.Infof("Upload to internal app sharing")
+			downloadURLs, err := uploadInternalAppSharingApps(ctx, packageConfigs, service)
+			if err != nil {
+				packageSummary.recordCall("internalappsharingartifacts.upload", err.Error())
+				log.Warnf("Failed to upload to internal app sharing: %s", err)
+			} else {
+				packageSummary.recordCall("internalappsharingartifacts.upload", "")
+				sharingURLs = append(sharingURLs, downloadURLs...)
+				packageSummary.SharingURLs = downloadURLs
+				log.Donef("Apps shared internally")
+			}
+		}
+		summary.Packages = append(summary.Packages, *packageSummary)
+		if errorString == "" {
+			if packageSummary.CommittedAt != "" && (configs.isDeploy() && !configs.UploadOnly || !configs.isDeploy() && len(packageSummary.VersionCodes) > 0) {
+				fmt.Println()
+				log.Infof("Export release")
+				if err := exportCommittedRelease(ctx, service, packageConfigs, packageSummary.VersionCodes); err != nil {
+					log.Warnf("Failed to export release: %s", err)
+				}
+			}
+			if configs.ReleaseStateTimeout > 0 && configs.isDeploy() && !configs.UploadOnly && !configs.ListingDryRun && !configs.SkipCommit {
+				fmt.Println()
+				log.Infof("Check release state")
+				if err := pollReleaseState(ctx, service, packageConfigs); err != nil {
+					log.Warnf("Failed to check release state: %s", err)
+				}
+			}
+			continue
+		}
+		if len(failed) == 0 {
+			failureCategory = deployErrorCategory(ctx, packageSummary.phaseCategory, errorString)
+		}
+		failed = append(failed, packageName)
+		if len(packageNames) == 1 {
+			break
+		}
+		log.Errorf("Failed to publish %s: %s", packageName, errorString)
+		if !configs.ContinueOnPackageFailure || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(sharingURLs) > 0 {
+		if err := exportEnvironment(internalAppSharingURLEnvKey, strings.Join(sharingURLs, "\n")); err != nil {
+			log.Warnf("Failed to export internal app sharing download URL: %s", err)
+		}
+	}
+
+	// The final report is printed in the quiet mode too.
+	setLogLevel(logLevelNormal)
+
+	fmt.Println()
+	log.Infof("Timings")
+	if err := printTimings(summary); err != nil {
+		log.Warnf("Failed to export timings: %s", err)
+	}
+
+	summary.FinishedAt = time.Now().Format(time.RFC3339)
+	summary.Succeeded = len(failed) == 0
+	if configs.DeploySummaryDir != "" {
+		fmt.Println()
+		if err := writeDeploySummary(configs, summary); err != nil {
+			log.Warnf("Failed to write deploy summary: %s", err)
+		}
+	}
+
+	if configs.AnnotateBuild {
+		if err := annotateBuild(summary); err != nil {
+			log.Warnf("Failed to add build annotation: %s", err)
+		}
+	}
+
+	if len(packageNames) == 1 && len(failed) > 0 {
+		failWithCategory(failureCategory, errorString)
+	}
+	if len(packageNames) > 1 {
+		fmt.Println()
+		logPackageReport(packageNames, failed, configs.ContinueOnPackageFailure)
+		if len(failed) > 0 {
+			failWithCategory(failureCategory, "Failed to publish %d of %d packages", len(failed), len(packageNames))
+		}
+	}
+}
+
+// publishPackage performs the changes of the step for the package of the given configs in a new edit, or in the edit
+// of the edit_id input. The changes are replayed in a new edit if the edit expires, and committed without sending to
+// review if that is enabled, in the resumed edit if there is one.
+func publishPackage(ctx context.Context, service *androidpublisher.Service, configs Configs, summary *packageSummary) (errorString string) {
+	errorString = executeEdit(ctx, service, configs, summary, configs.ChangesNotSentForReview, false)
+	if isEditExpiredError(errorString) {
+		log.Warnf(errorString)
+		log.Warnf("The edit expired, replaying the changes in a new edit. The apps already uploaded are not uploaded again.")
+		// The expired edit can not be resumed, the changes are replayed in a new edit.
+		configs.EditID = ""
+		errorString = executeEdit(ctx, service, configs, summary, configs.ChangesNotSentForReview, true)
+	}
 	if errorString == "" {
-		return
+		return ""
 	}
-	if strings.Contains(errorString, changesNotSentForReviewMessage) {
+	if !configs.ChangesNotSentForReview && strings.Contains(errorString, changesNotSentForReviewMessage) {
 		if configs.RetryWithoutSendingToReview {
 			log.Warnf(errorString)
 			log.Warnf("Trying to commit edit with setting changesNotSentForReview to true. Please make sure to send the changes to review from Google Play Console UI.")
-			errorString = executeEdit(service, configs, true)
-			if errorString == "" {
-				return
-			}
-		} else {
-			log.Warnf("Sending the edit to review failed. Please change \"Retry changes without sending to review\" input to true if you wish to send the changes with the changesNotSentForReview flag. Please note that in that case the review has to be manually initiated from Google Play Console UI")
+			// A resumed edit is kept on failure, so the commit is retried on it instead of abandoning it for a new edit.
+			return executeEdit(ctx, service, configs, summary, true, true)
 		}
+		log.Warnf("Sending the edit to review failed. Please change \"Retry changes without sending to review\" input to true if you wish to send the changes with the changesNotSentForReview flag. Please note that in that case the review has to be manually initiated from Google Play Console UI")
 	}
-	failf(errorString)
+	return errorString
 }
 
-func executeEdit(service *androidpublisher.Service, configs Configs, changesNotSentForReview bool) (errorString string) {
-	editsService := androidpublisher.NewEditsService(service)
-	//
-	// Create insert edit
-	fmt.Println()
-	log.Infof("Create new edit")
-	editsInsertCall := editsService.Insert(configs.PackageName, &androidpublisher.AppEdit{})
-	appEdit, err := editsInsertCall.Do()
-	if err != nil {
-		return fmt.Sprintf("Failed to perform edit insert call, error: %s", err)
+// logPackageReport prints which of the given packages were published, failed or skipped after a failure.
+func logPackageReport(packageNames, failed []string, continueOnFailure bool) {
+	log.Infof("Publish report")
+	failedPackages := map[string]bool{}
+	for _, packageName := range failed {
+		failedPackages[packageName] = true
+	}
+	stopped := false
+	for _, packageName := range packageNames {
+		switch {
+		case stopped:
+			log.Printf("- skipped: %s", packageName)
+		case failedPackages[packageName]:
+			log.Errorf("- failed: %s", packageName)
+			stopped = !continueOnFailure
+		default:
+			log.Donef("- published: %s", packageName)
+		}
+	}
+}
+
+// isEditExpiredError returns true if the given error message is about an expired edit.
+func isEditExpiredError(errorString string) bool {
+	lower := strings.ToLower(errorString)
+	return strings.Contains(lower, "editexpired") || strings.Contains(lower, "edit has expired")
+}
+
+// deleteEdit deletes the given edit, so none of its changes are kept and abandoned edits do not pile up. It is deleted
+// after the deploy timed out too.
+func deleteEdit(service *androidpublisher.Service, packageName, appEditID string) {
+	log.Infof("Deleting edit")
+	ctx, cancel := context.WithTimeout(context.Background(), editDeleteTimeout)
+	defer cancel()
+	if err := androidpublisher.NewEditsService(service).Delete(packageName, appEditID).Context(ctx).Do(); err != nil {
+		log.Warnf("Failed to delete edit (%s), error: %s", appEditID, err)
+		return
+	}
+	log.Donef("Edit deleted")
+}
+
+// updateStoreListings backs up and updates the store listings, their images and media.
+func updateStoreListings(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit, summary *packageSummary) (errorString string) {
+	if configs.hasListingChanges() && configs.ListingBackupDir != "" {
+		fmt.Println()
+		log.Infof("Back up store listings")
+		if err := backupListings(ctx, configs, service, appEdit); err != nil {
+			return fmt.Sprintf("Failed to back up store listings: %v", err)
+		}
+		log.Donef("Store listings backed up")
+	}
+
+	if configs.MetadataDir != "" {
+		fmt.Println()
+		log.Infof("Update store listings")
+		if err := updateListings(ctx, configs, service, appEdit); err != nil {
+			return fmt.Sprintf("Failed to update store listings: %v", err)
+		}
+		if err := uploadImages(ctx, configs, service, appEdit); err != nil {
+			return summary.failPhase(errorCategoryUpload, fmt.Sprintf("Failed to upload store listing images: %v", err))
+		}
+		log.Donef("Store listings updated")
+	}
+
+	if configs.PromoVideoURL != "" || configs.FeatureGraphicPath != "" {
+		fmt.Println()
+		log.Infof("Update store listing media")
+		if err := updateListingMedia(ctx, configs, service, appEdit); err != nil {
+			return fmt.Sprintf("Failed to update store listing media: %v", err)
+		}
+		log.Donef("Store listing media updated")
+	}
+
+	return ""
+}
+
+// deployApplications uploads the applications and assigns them to the track.
+func deployApplications(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit, summary *packageSummary, skipUploaded bool) (errorString string) {
+	if !configs.UploadOnly {
+		fmt.Println()
+		log.Infof("Verify tracks")
+		if err := verifyTracks(ctx, service, configs.PackageName, appEdit.Id, configs.tracks()); err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to verify tracks: %v", err))
+		}
+		log.Donef("Tracks %s found", strings.Join(configs.tracks(), ", "))
+	}
+
+	if configs.hasListingChanges() {
+		fmt.Println()
+		log.Infof("Store listing changes")
+		if err := diffListings(ctx, configs, service, appEdit); err != nil {
+			return fmt.Sprintf("Failed to compare store listings: %v", err)
+		}
+		log.Donef("Store listings compared")
+	}
+
+	if !configs.UploadOnly && !configs.AppendVersionCodes {
+		fmt.Println()
+		log.Infof("Check version codes")
+		if err := validateVersionCodesAgainstTracks(ctx, configs, service, appEdit); err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to check version codes: %v", err))
+		}
+		log.Donef("Version codes are higher than the live ones")
 	}
-	log.Printf(" editID: %s", appEdit.Id)
-	log.Donef("Edit insert created")
 
 	//
 	// Upload applications
 	fmt.Println()
 	log.Infof("Upload apks or app bundles")
-	versionCodes, err := uploadApplications(configs, service, appEdit)
+	versionCodes, err := uploadApplications(ctx, configs, service, appEdit, summary, skipUploaded)
 	if err != nil {
-		return fmt.Sprintf("Failed to upload APKs: %v", err)
+		return summary.failPhase(errorCategoryUpload, fmt.Sprintf("Failed to upload APKs: %v", err))
 	}
 	log.Donef("Applications uploaded")
 
+	versionCodeSlice := versionCodeMapToSlice(versionCodes)
+	if configs.ProcessingTimeout > 0 {
+		fmt.Println()
+		log.Infof("Wait for processing")
+		processingStartedAt := time.Now()
+		if err := waitForProcessing(ctx, service, configs.PackageName, appEdit.Id, versionCodeSlice, time.Duration(configs.ProcessingTimeout)*time.Second); err != nil {
+			return summary.failPhase(errorCategoryUpload, fmt.Sprintf("Failed to wait for processing: %v", err))
+		}
+		summary.recordPhase("processing", processingStartedAt)
+		log.Donef("Applications processed")
+	}
+
+	if errorString := updateStoreListings(ctx, configs, service, appEdit, summary); errorString != "" {
+		return errorString
+	}
+
 	// Update track
 	fmt.Println()
-	log.Infof("Update track")
-	versionCodeSlice := versionCodeMapToSlice(versionCodes)
-	if err := updateTracks(configs, service, appEdit, versionCodeSlice); err != nil {
-		return fmt.Sprintf("Failed to update track, reason: %v", err)
+	if configs.UploadOnly {
+		log.Warnf("Upload only mode, the uploaded apps (version codes: %v) are not assigned to any track", versionCodeSlice)
+	} else {
+		log.Infof("Check shadowed releases")
+		if err := checkShadowedReleases(ctx, configs, service, appEdit, versionCodeSlice); err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to check shadowed releases: %v", err))
+		}
+
+		if configs.hasReleaseNotes() && configs.ReleaseNotesCoverageCheck != localeCheckOff {
+			fmt.Println()
+			log.Infof("Check release notes coverage")
+			if err := checkReleaseNotesCoverage(ctx, configs, service, appEdit); err != nil {
+				return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to check release notes coverage: %v", err))
+			}
+		}
+
+		fmt.Println()
+		log.Infof("Update track")
+		trackStartedAt := time.Now()
+		if err := updateTracks(ctx, configs, service, appEdit, versionCodeSlice); err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to update track, reason: %v", err))
+		}
+		summary.recordPhase("track update", trackStartedAt)
+		log.Donef("Track updated")
+
+		if configs.ClearLowerTracks != "" {
+			fmt.Println()
+			log.Infof("Clear lower tracks")
+			cleared, err := clearLowerTracks(ctx, configs, service, appEdit)
+			if err != nil {
+				return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to clear lower tracks, reason: %v", err))
+			}
+			summary.ClearedReleases = cleared
+			log.Donef("Lower tracks cleared")
+		}
+	}
+
+	return ""
+}
+
+// updateListingOnly updates the store listings and the release notes of the latest release of the track, without
+// uploading any app.
+func updateListingOnly(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit, summary *packageSummary) (errorString string) {
+	if configs.hasListingChanges() {
+		fmt.Println()
+		log.Infof("Store listing changes")
+		if err := diffListings(ctx, configs, service, appEdit); err != nil {
+			return fmt.Sprintf("Failed to compare store listings: %v", err)
+		}
+		log.Donef("Store listings compared")
+	}
+
+	if errorString := updateStoreListings(ctx, configs, service, appEdit, summary); errorString != "" {
+		return errorString
+	}
+
+	if configs.hasReleaseNotes() {
+		fmt.Println()
+		log.Infof("Update release notes")
+		if err := updateReleaseNotes(ctx, configs, service, appEdit); err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to update release notes, reason: %v", err))
+		}
+		log.Donef("Release notes updated")
+	}
+	return ""
+}
+
+// dryRunListings prints the store listing and release notes changes, the edit is not committed in the dry run mode.
+func dryRunListings(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) (errorString string) {
+	fmt.Println()
+	log.Infof("Store listing changes")
+	if err := diffListings(ctx, configs, service, appEdit); err != nil {
+		return fmt.Sprintf("Failed to compare store listings: %v", err)
+	}
+	log.Donef("Store listings compared")
+
+	// Nothing to commit in the dry run mode.
+	fmt.Println()
+	log.Warnf("Listing dry run mode, no apps are uploaded and no changes are committed")
+	return ""
+}
+
+// performOperation performs the operation of the step in the given edit. Returns false if the edit should not be
+// committed, because the operation does not change anything.
+func performOperation(ctx context.Context, service *androidpublisher.Service, configs Configs, appEdit *androidpublisher.AppEdit, summary *packageSummary, skipUploaded bool) (errorString string, commit bool) {
+	switch configs.Operation {
+	case operationPromote:
+		fmt.Println()
+		log.Infof("Promote release")
+		release, err := promoteRelease(ctx, configs, service, appEdit)
+		if err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to promote release, reason: %v", err)), false
+		}
+		summary.VersionCodes = release.VersionCodes
+		log.Donef("Release promoted")
+	case operationHaltRollout:
+		fmt.Println()
+		log.Infof("Halt rollout")
+		release, err := haltRollout(ctx, configs, service, appEdit)
+		if err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to halt rollout, reason: %v", err)), false
+		}
+		summary.VersionCodes = release.VersionCodes
+		log.Donef("Rollout halted")
+	case operationUpdateRollout:
+		fmt.Println()
+		log.Infof("Update rollout")
+		release, err := updateRollout(ctx, configs, service, appEdit)
+		if err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to update rollout, reason: %v", err)), false
+		}
+		summary.VersionCodes = release.VersionCodes
+		log.Donef("Rollout updated")
+	case operationCompleteRollout:
+		fmt.Println()
+		log.Infof("Complete rollout")
+		release, err := completeRollout(ctx, configs, service, appEdit)
+		if err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to complete rollout, reason: %v", err)), false
+		}
+		summary.VersionCodes = release.VersionCodes
+		log.Donef("Rollout completed")
+	case operationRollback:
+		fmt.Println()
+		log.Infof("Roll back release")
+		release, err := rollback(ctx, configs, service, appEdit)
+		if err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to roll back release, reason: %v", err)), false
+		}
+		summary.VersionCodes = release.VersionCodes
+		log.Donef("Release rolled back")
+	case operationRampRollout:
+		fmt.Println()
+		log.Infof("Ramp rollout")
+		release, err := rampRollout(ctx, configs, service, appEdit)
+		if err != nil {
+			return summary.failPhase(errorCategoryTrackConflict, fmt.Sprintf("Failed to ramp rollout, reason: %v", err)), false
+		}
+		if release == nil {
+			// Nothing to commit, the edit is deleted instead of committing and sending an empty edit for review.
+			log.Donef("Rollout unchanged")
+			return "", false
+		}
+		summary.VersionCodes = release.VersionCodes
+		log.Donef("Rollout ramped")
+	case operationListTracks:
+		fmt.Println()
+		log.Infof("List tracks")
+		if err := listTracks(ctx, configs, service, appEdit); err != nil {
+			return fmt.Sprintf("Failed to list tracks, reason: %v", err), false
+		}
+		log.Donef("Tracks listed")
+
+		// Nothing to commit in the read-only mode.
+		return "", false
+	case operationUpdateListing:
+		if configs.ListingDryRun {
+			return dryRunListings(ctx, configs, service, appEdit), false
+		}
+		if errorString := updateListingOnly(ctx, configs, service, appEdit, summary); errorString != "" {
+			return errorString, false
+		}
+	default:
+		if configs.ListingDryRun {
+			return dryRunListings(ctx, configs, service, appEdit), false
+		}
+		if errorString := deployApplications(ctx, configs, service, appEdit, summary, skipUploaded); errorString != "" {
+			return errorString, false
+		}
+	}
+	return "", true
+}
+
+// executeEdit performs the operation in a new edit, or in the edit of the edit_id input, and commits it unless
+// skip_commit is set. If skipUploaded is set or the edit is resumed, the apps already uploaded for the app (matched by
+// their sha256 hash) are not uploaded again. The outcomes of the calls are added to the summary.
+func executeEdit(ctx context.Context, service *androidpublisher.Service, configs Configs, summary *packageSummary, changesNotSentForReview, skipUploaded bool) (errorString string) {
+	summary.phaseCategory = errorCategory{}
+	editsService := androidpublisher.NewEditsService(service)
+	var appEdit *androidpublisher.AppEdit
+	if configs.EditID != "" {
+		//
+		// Get the edit to resume
+		fmt.Println()
+		log.Infof("Resume edit")
+		var err error
+		appEdit, err = editsService.Get(configs.PackageName, configs.EditID).Context(ctx).Do()
+		if err != nil {
+			errorString := fmt.Sprintf("Failed to get edit (%s), error: %s", configs.EditID, err)
+			summary.recordCall("edits.get", errorString)
+			return errorString
+		}
+		summary.recordCall("edits.get", "")
+		summary.EditCreatedAt = ""
+		// The apps uploaded to the edit earlier are not uploaded again.
+		skipUploaded = true
+	} else {
+		//
+		// Create insert edit
+		fmt.Println()
+		log.Infof("Create new edit")
+		editsInsertCall := editsService.Insert(configs.PackageName, &androidpublisher.AppEdit{})
+		var err error
+		appEdit, err = editsInsertCall.Context(ctx).Do()
+		if err != nil {
+			errorString := fmt.Sprintf("Failed to perform edit insert call, error: %s", err)
+			summary.recordCall("edits.insert", errorString)
+			return errorString
+		}
+		summary.recordCall("edits.insert", "")
+		summary.EditCreatedAt = time.Now().Format(time.RFC3339)
+	}
+	summary.EditIDs = append(summary.EditIDs, appEdit.Id)
+	summary.EditExpiresAt = ""
+	summary.ClearedReleases = nil
+	log.Printf(" editID: %s", appEdit.Id)
+	if expiresAt, ok := editExpiryTime(appEdit); ok {
+		summary.EditExpiresAt = expiresAt.Format(time.RFC3339)
+		log.Printf(" expires at: %s (in %s)", summary.EditExpiresAt, time.Until(expiresAt).Round(time.Minute))
+	}
+	if configs.EditID != "" {
+		log.Donef("Edit resumed")
+	} else {
+		log.Donef("Edit insert created")
+	}
+	defer func() {
+		// A resumed edit is kept, so the step can be rerun with it.
+		if errorString != "" && configs.EditID == "" {
+			deleteEdit(service, configs.PackageName, appEdit.Id)
+		}
+	}()
+	if err := exportEnvironments([]envVar{
+		{editIDEnvKey, appEdit.Id},
+		{editCreatedAtEnvKey, summary.EditCreatedAt},
+		{editExpiresAtEnvKey, summary.EditExpiresAt},
+	}); err != nil {
+		return fmt.Sprintf("Failed to export edit details: %v", err)
+	}
+
+	errorString, commit := performOperation(ctx, service, configs, appEdit, summary, skipUploaded)
+	summary.recordCall(summary.Operation, errorString)
+	if errorString != "" {
+		return errorString
+	}
+	if !commit {
+		if configs.EditID == "" {
+			fmt.Println()
+			deleteEdit(service, configs.PackageName, appEdit.Id)
+		}
+		return ""
+	}
+
+	if configs.SkipCommit {
+		fmt.Println()
+		log.Warnf("The edit is not committed, resume it with the edit_id input to commit its changes: %s", appEdit.Id)
+		return ""
 	}
-	log.Donef("Track updated")
 
 	//
 	// Commit edit
 	fmt.Println()
 	log.Infof("Committing edit")
+	commitStartedAt := time.Now()
 	editsCommitCall := editsService.Commit(configs.PackageName, appEdit.Id)
 	editsCommitCall.ChangesNotSentForReview(changesNotSentForReview)
-	if _, err := editsCommitCall.Do(); err != nil {
-		return fmt.Sprintf("Failed to commit edit, error: %s", err)
+	if _, err := editsCommitCall.Context(ctx).Do(); err != nil {
+		errorString := fmt.Sprintf("Failed to commit edit, error: %s", err)
+		summary.recordCall("edits.commit", errorString)
+		return summary.failPhase(errorCategoryCommitRejected, errorString)
 	}
+	summary.recordCall("edits.commit", "")
+	summary.recordPhase("commit", commitStartedAt)
+	summary.CommittedAt = time.Now().Format(time.RFC3339)
+	sentForReview := !changesNotSentForReview
+	summary.SentForReview = &sentForReview
 	log.Donef("Edit committed")
+	if changesNotSentForReview {
+		log.Warnf("The changes are not sent for review, they go live only after sending them to review on the Google Play Console.")
+	}
+	if err := exportEnvironments([]envVar{
+		{committedAtEnvKey, summary.CommittedAt},
+		{sentForReviewEnvKey, strconv.FormatBool(sentForReview)},
+	}); err != nil {
+		log.Warnf("Failed to export commit details: %s", err)
+	}
+	if configs.ClearLowerTracks != "" {
+		if err := exportEnvironment(clearedReleasesEnvKey, strings.Join(summary.ClearedReleases, "\n")); err != nil {
+			log.Warnf("Failed to export cleared releases: %s", err)
+		}
+	}
 	return ""
 }
+
+// editExpiryTime returns the time the given edit expires at, false if Google Play did not report it.
+func editExpiryTime(appEdit *androidpublisher.AppEdit) (time.Time, bool) {
+	seconds, err := strconv.ParseInt(appEdit.ExpiryTimeSeconds, 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0).UTC(), true
+}
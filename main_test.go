@@ -1,9 +1,16 @@
 package main
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/api/androidpublisher/v3"
 )
 
 func TestParseURI(t *testing.T) {
@@ -71,3 +78,54 @@ func TestParseURI(t *testing.T) {
 		require.Equal(t, false, isRemote)
 	}
 }
+
+func TestDownloadFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app.aab" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := w.Write([]byte("app content"))
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "TestDownloadFile")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	t.Log("downloadFile - existing file")
+	{
+		dst := filepath.Join(tmpDir, "sub", "app.aab")
+		require.NoError(t, downloadFile(server.URL+"/app.aab", dst))
+
+		content, err := ioutil.ReadFile(dst)
+		require.NoError(t, err)
+		require.Equal(t, "app content", string(content))
+	}
+
+	t.Log("downloadFile - not found")
+	{
+		require.Error(t, downloadFile(server.URL+"/missing.aab", filepath.Join(tmpDir, "missing.aab")))
+	}
+}
+
+func Test_isEditExpiredError(t *testing.T) {
+	require.True(t, isEditExpiredError("Failed to commit edit, error: googleapi: Error 400: This Edit has expired., editExpired"))
+	require.True(t, isEditExpiredError("Failed to update track, reason: googleapi: Error 400: This edit has expired"))
+	require.False(t, isEditExpiredError("Failed to commit edit, error: googleapi: Error 403: "+changesNotSentForReviewMessage))
+	require.False(t, isEditExpiredError(""))
+}
+
+func Test_editExpiryTime(t *testing.T) {
+	expiresAt, ok := editExpiryTime(&androidpublisher.AppEdit{ExpiryTimeSeconds: "1700000000"})
+	require.True(t, ok)
+	require.Equal(t, time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC), expiresAt)
+
+	_, ok = editExpiryTime(&androidpublisher.AppEdit{})
+	require.False(t, ok)
+	_, ok = editExpiryTime(&androidpublisher.AppEdit{ExpiryTimeSeconds: "invalid"})
+	require.False(t, ok)
+}
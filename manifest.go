@@ -0,0 +1,677 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	apkManifestPath = "AndroidManifest.xml"
+	aabManifestPath = "base/manifest/AndroidManifest.xml"
+
+	// Android resource ids of the manifest attributes the step reads.
+	versionCodeAttrResID = 0x0101021b
+	versionNameAttrResID = 0x0101021c
+	debuggableAttrResID  = 0x0101000f
+)
+
+// appManifest holds the fields of an app's AndroidManifest.xml the step validates before uploading.
+type appManifest struct {
+	PackageName string
+	VersionCode int64
+	VersionName string
+	Debuggable  bool
+}
+
+// manifestAttribute is an attribute of the manifest or application element, integer and boolean values are stored
+// in intValue if the attribute is compiled.
+type manifestAttribute struct {
+	name     string
+	resID    uint32
+	value    string
+	intValue *int64
+}
+
+// manifestFromAttributes returns the manifest fields from the attributes of the manifest and application elements.
+func manifestFromAttributes(manifestAttributes, applicationAttributes []manifestAttribute) (appManifest, error) {
+	var manifest appManifest
+	for _, attr := range manifestAttributes {
+		switch {
+		case attr.name == "package":
+			manifest.PackageName = attr.value
+		case attr.resID == versionCodeAttrResID || attr.name == "versionCode":
+			if attr.intValue != nil {
+				manifest.VersionCode = *attr.intValue
+				continue
+			}
+			versionCode, err := strconv.ParseInt(attr.value, 10, 64)
+			if err != nil {
+				return appManifest{}, fmt.Errorf("invalid version code in manifest: %s", attr.value)
+			}
+			manifest.VersionCode = versionCode
+		case attr.resID == versionNameAttrResID || attr.name == "versionName":
+			manifest.VersionName = attr.value
+		}
+	}
+
+	for _, attr := range applicationAttributes {
+		if attr.resID == debuggableAttrResID || attr.name == "debuggable" {
+			if attr.intValue != nil {
+				manifest.Debuggable = *attr.intValue != 0
+			} else {
+				manifest.Debuggable = attr.value == "true"
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// readAppManifest reads the manifest of the given .apk or .aab file. APKs contain the manifest in Android binary XML
+// format, while app bundles contain it in the protobuf format of aapt2.
+func readAppManifest(pth string) (appManifest, error) {
+	r, err := zip.OpenReader(pth)
+	if err != nil {
+		return appManifest{}, fmt.Errorf("failed to open app (%s) as zip, error: %s", pth, err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Warnf("failed to close (%s)", pth)
+		}
+	}()
+
+	isAAB := strings.ToLower(filepath.Ext(pth)) == ".aab"
+	manifestPth := apkManifestPath
+	if isAAB {
+		manifestPth = aabManifestPath
+	}
+
+	var content []byte
+	for _, f := range r.File {
+		if f.Name != manifestPth {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return appManifest{}, fmt.Errorf("failed to open %s in app (%s), error: %s", manifestPth, pth, err)
+		}
+		content, err = ioutil.ReadAll(rc)
+		if cerr := rc.Close(); cerr != nil {
+			log.Warnf("failed to close %s in app (%s)", manifestPth, pth)
+		}
+		if err != nil {
+			return appManifest{}, fmt.Errorf("failed to read %s in app (%s), error: %s", manifestPth, pth, err)
+		}
+		break
+	}
+	if content == nil {
+		return appManifest{}, fmt.Errorf("no %s found in app (%s)", manifestPth, pth)
+	}
+
+	if isAAB {
+		return parseProtoManifest(content)
+	}
+	return parseBinaryXMLManifest(content)
+}
+
+// manifestVersionCodes returns the version codes of the given apps.
+func manifestVersionCodes(appPaths []string) ([]int64, error) {
+	var versionCodes []int64
+	for _, pth := range appPaths {
+		manifest, err := readAppManifest(pth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the version code of app (%s), error: %s", pth, err)
+		}
+		versionCodes = append(versionCodes, manifest.VersionCode)
+	}
+	return versionCodes, nil
+}
+
+//
+// Android binary XML
+
+const (
+	resStringPoolType   = 0x0001
+	resXMLType          = 0x0003
+	resXMLStartElement  = 0x0102
+	resXMLResourceMap   = 0x0180
+	resStringPoolUTF8   = 1 << 8
+	resValueTypeString  = 0x03
+	resValueTypeIntDec  = 0x10
+	resValueTypeIntHex  = 0x11
+	resValueTypeBoolean = 0x12
+	noIndex             = 0xffffffff
+)
+
+// parseBinaryXMLManifest parses the manifest and application elements of an Android binary XML document.
+func parseBinaryXMLManifest(content []byte) (appManifest, error) {
+	if len(content) < 8 || binary.LittleEndian.Uint16(content) != resXMLType {
+		return appManifest{}, errors.New("invalid binary XML manifest")
+	}
+
+	var stringPool []string
+	var resourceMap []uint32
+	var manifestAttributes, applicationAttributes []manifestAttribute
+	manifestFound := false
+	offset := int(binary.LittleEndian.Uint16(content[2:]))
+	for offset+8 <= len(content) {
+		chunkType := binary.LittleEndian.Uint16(content[offset:])
+		headerSize := int(binary.LittleEndian.Uint16(content[offset+2:]))
+		chunkSize := int(binary.LittleEndian.Uint32(content[offset+4:]))
+		if chunkSize < 8 || offset+chunkSize > len(content) {
+			return appManifest{}, errors.New("invalid binary XML manifest: malformed chunk")
+		}
+		chunk := content[offset : offset+chunkSize]
+		offset += chunkSize
+
+		switch chunkType {
+		case resStringPoolType:
+			pool, err := parseStringPool(chunk)
+			if err != nil {
+				return appManifest{}, err
+			}
+			stringPool = pool
+		case resXMLResourceMap:
+			for i := headerSize; i+4 <= len(chunk); i += 4 {
+				resourceMap = append(resourceMap, binary.LittleEndian.Uint32(chunk[i:]))
+			}
+		case resXMLStartElement:
+			name, attributes, err := parseStartElement(chunk, headerSize, stringPool, resourceMap)
+			if err != nil {
+				return appManifest{}, err
+			}
+
+			// the first element is the manifest element
+			if !manifestFound {
+				manifestFound = true
+				manifestAttributes = attributes
+			} else if name == "application" {
+				applicationAttributes = attributes
+				return manifestFromAttributes(manifestAttributes, applicationAttributes)
+			}
+		}
+	}
+	if !manifestFound {
+		return appManifest{}, errors.New("invalid binary XML manifest: no manifest element found")
+	}
+	return manifestFromAttributes(manifestAttributes, applicationAttributes)
+}
+
+// parseStartElement returns the name and the attributes of a binary XML start element chunk.
+func parseStartElement(chunk []byte, headerSize int, stringPool []string, resourceMap []uint32) (string, []manifestAttribute, error) {
+	// ns, name, attributeStart, attributeSize, attributeCount
+	if len(chunk) < headerSize+20 {
+		return "", nil, errors.New("invalid binary XML manifest: malformed element")
+	}
+	ext := chunk[headerSize:]
+	attributeStart := int(binary.LittleEndian.Uint16(ext[8:]))
+	attributeSize := int(binary.LittleEndian.Uint16(ext[10:]))
+	attributeCount := int(binary.LittleEndian.Uint16(ext[12:]))
+	if attributeSize < 20 || attributeStart+attributeCount*attributeSize > len(ext) {
+		return "", nil, errors.New("invalid binary XML manifest: malformed attributes")
+	}
+
+	lookup := func(idx uint32) string {
+		if idx == noIndex || int(idx) >= len(stringPool) {
+			return ""
+		}
+		return stringPool[idx]
+	}
+
+	var attributes []manifestAttribute
+	for i := 0; i < attributeCount; i++ {
+		a := ext[attributeStart+i*attributeSize:]
+		nameIdx := binary.LittleEndian.Uint32(a[4:])
+		dataType := a[15]
+		data := binary.LittleEndian.Uint32(a[16:])
+
+		attr := manifestAttribute{
+			name:  lookup(nameIdx),
+			value: lookup(binary.LittleEndian.Uint32(a[8:])),
+		}
+		if int(nameIdx) < len(resourceMap) {
+			attr.resID = resourceMap[nameIdx]
+		}
+		switch dataType {
+		case resValueTypeString:
+			if attr.value == "" {
+				attr.value = lookup(data)
+			}
+		case resValueTypeIntDec, resValueTypeIntHex, resValueTypeBoolean:
+			v := int64(data)
+			attr.intValue = &v
+		}
+		attributes = append(attributes, attr)
+	}
+	return lookup(binary.LittleEndian.Uint32(ext[4:])), attributes, nil
+}
+
+func parseStringPool(chunk []byte) ([]string, error) {
+	if len(chunk) < 28 {
+		return nil, errors.New("invalid binary XML manifest: malformed string pool")
+	}
+	headerSize := int(binary.LittleEndian.Uint16(chunk[2:]))
+	stringCount := int(binary.LittleEndian.Uint32(chunk[8:]))
+	flags := binary.LittleEndian.Uint32(chunk[16:])
+	stringsStart := int(binary.LittleEndian.Uint32(chunk[20:]))
+	if headerSize+stringCount*4 > len(chunk) || stringsStart > len(chunk) {
+		return nil, errors.New("invalid binary XML manifest: malformed string pool")
+	}
+
+	pool := make([]string, stringCount)
+	for i := range pool {
+		start := stringsStart + int(binary.LittleEndian.Uint32(chunk[headerSize+i*4:]))
+		if start >= len(chunk) {
+			return nil, errors.New("invalid binary XML manifest: malformed string pool")
+		}
+
+		var err error
+		if flags&resStringPoolUTF8 != 0 {
+			pool[i], err = decodeUTF8PoolString(chunk[start:])
+		} else {
+			pool[i], err = decodeUTF16PoolString(chunk[start:])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+func decodeUTF8PoolString(b []byte) (string, error) {
+	// the string is prefixed with its utf-16 and utf-8 length
+	_, n, err := decodeUTF8PoolStringLength(b)
+	if err != nil {
+		return "", err
+	}
+	length, m, err := decodeUTF8PoolStringLength(b[n:])
+	if err != nil {
+		return "", err
+	}
+
+	start := n + m
+	if start+length > len(b) {
+		return "", errors.New("invalid binary XML manifest: malformed string")
+	}
+	return string(b[start : start+length]), nil
+}
+
+// decodeUTF8PoolStringLength decodes a string length of an UTF-8 string pool, which is stored on 1 or 2 bytes.
+func decodeUTF8PoolStringLength(b []byte) (int, int, error) {
+	if len(b) < 1 {
+		return 0, 0, errors.New("invalid binary XML manifest: malformed string")
+	}
+	if b[0]&0x80 == 0 {
+		return int(b[0]), 1, nil
+	}
+	if len(b) < 2 {
+		return 0, 0, errors.New("invalid binary XML manifest: malformed string")
+	}
+	return int(b[0]&0x7f)<<8 | int(b[1]), 2, nil
+}
+
+func decodeUTF16PoolString(b []byte) (string, error) {
+	if len(b) < 2 {
+		return "", errors.New("invalid binary XML manifest: malformed string")
+	}
+	length := int(binary.LittleEndian.Uint16(b))
+	offset := 2
+	if length&0x8000 != 0 {
+		if len(b) < 4 {
+			return "", errors.New("invalid binary XML manifest: malformed string")
+		}
+		length = (length&0x7fff)<<16 | int(binary.LittleEndian.Uint16(b[2:]))
+		offset = 4
+	}
+	if offset+length*2 > len(b) {
+		return "", errors.New("invalid binary XML manifest: malformed string")
+	}
+
+	chars := make([]uint16, length)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(b[offset+i*2:])
+	}
+	return string(utf16.Decode(chars)), nil
+}
+
+//
+// aapt2 protobuf XML
+
+// protoField is a field of a protobuf message, only varint and length delimited values are kept.
+type protoField struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+// parseProtoMessage decodes the fields of a protobuf encoded message.
+func parseProtoMessage(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("invalid protobuf message")
+		}
+		b = b[n:]
+
+		field := protoField{number: int(key >> 3)}
+		switch key & 0x7 {
+		case 0: // varint
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("invalid protobuf message")
+			}
+			field.varint = v
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return nil, errors.New("invalid protobuf message")
+			}
+			b = b[8:]
+		case 2: // length delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errors.New("invalid protobuf message")
+			}
+			field.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return nil, errors.New("invalid protobuf message")
+			}
+			b = b[4:]
+		default:
+			return nil, errors.New("invalid protobuf message: unsupported wire type")
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// parseProtoManifest parses the manifest and application elements of an aapt2 protobuf XmlNode.
+func parseProtoManifest(content []byte) (appManifest, error) {
+	name, manifestAttributes, children, err := parseProtoXMLNode(content)
+	if err != nil {
+		return appManifest{}, err
+	}
+	if name == "" {
+		return appManifest{}, errors.New("invalid protobuf manifest: no manifest element found")
+	}
+
+	for _, child := range children {
+		name, applicationAttributes, _, err := parseProtoXMLNode(child)
+		if err != nil {
+			return appManifest{}, err
+		}
+		if name == "application" {
+			return manifestFromAttributes(manifestAttributes, applicationAttributes)
+		}
+	}
+	return manifestFromAttributes(manifestAttributes, nil)
+}
+
+// parseProtoXMLNode returns the name, the attributes and the encoded child nodes of an aapt2 XmlNode element.
+// The name is empty if the node is not an element.
+func parseProtoXMLNode(b []byte) (string, []manifestAttribute, [][]byte, error) {
+	// XmlNode.element = 1
+	node, err := parseProtoMessage(b)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid protobuf manifest, error: %s", err)
+	}
+	var element []byte
+	for _, f := range node {
+		if f.number == 1 {
+			element = f.bytes
+		}
+	}
+	if element == nil {
+		return "", nil, nil, nil
+	}
+
+	// XmlElement: name = 3, attribute = 4, child = 5
+	elementFields, err := parseProtoMessage(element)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid protobuf manifest, error: %s", err)
+	}
+	var name string
+	var attributes []manifestAttribute
+	var children [][]byte
+	for _, f := range elementFields {
+		switch f.number {
+		case 3:
+			name = string(f.bytes)
+		case 4:
+			attr, err := parseProtoXMLAttribute(f.bytes)
+			if err != nil {
+				return "", nil, nil, err
+			}
+			attributes = append(attributes, attr)
+		case 5:
+			children = append(children, f.bytes)
+		}
+	}
+	return name, attributes, children, nil
+}
+
+func parseProtoXMLAttribute(b []byte) (manifestAttribute, error) {
+	// XmlAttribute: name = 2, value = 3, resource_id = 5, compiled_item = 6
+	fields, err := parseProtoMessage(b)
+	if err != nil {
+		return manifestAttribute{}, fmt.Errorf("invalid protobuf manifest attribute, error: %s", err)
+	}
+
+	var attr manifestAttribute
+	for _, f := range fields {
+		switch f.number {
+		case 2:
+			attr.name = string(f.bytes)
+		case 3:
+			attr.value = string(f.bytes)
+		case 5:
+			attr.resID = uint32(f.varint)
+		case 6:
+			v, err := parseProtoCompiledInt(f.bytes)
+			if err != nil {
+				return manifestAttribute{}, err
+			}
+			attr.intValue = v
+		}
+	}
+	return attr, nil
+}
+
+// parseProtoCompiledInt returns the integer value of an aapt2 Item, if it holds a primitive integer or boolean.
+func parseProtoCompiledInt(b []byte) (*int64, error) {
+	// Item.prim = 7
+	itemFields, err := parseProtoMessage(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf manifest item, error: %s", err)
+	}
+	for _, itemField := range itemFields {
+		if itemField.number != 7 {
+			continue
+		}
+
+		// Primitive: int_decimal_value = 6, int_hexadecimal_value = 7, boolean_value = 8
+		primFields, err := parseProtoMessage(itemField.bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protobuf manifest primitive, error: %s", err)
+		}
+		for _, primField := range primFields {
+			if primField.number == 6 || primField.number == 7 || primField.number == 8 {
+				v := int64(uint32(primField.varint))
+				return &v, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// validateAppContent checks if the given app is an .apk or .aab file based on its content, not only its extension.
+// Returns a descriptive error for the common mistakes: mapping files, zipped build outputs, failed or corrupted
+// downloads and apps with mismatching extension.
+func validateAppContent(pth string) error {
+	header, err := readFileHeader(pth, 512)
+	if err != nil {
+		return fmt.Errorf("failed to read app (%s), error: %s", pth, err)
+	}
+
+	if len(header) == 0 {
+		return fmt.Errorf("app (%s) is an empty file, the download or the build might have failed", pth)
+	}
+	if !bytes.HasPrefix(header, []byte("PK\x03\x04")) {
+		text := strings.TrimSpace(string(header))
+		switch {
+		case strings.Contains(strings.SplitN(text, "\n", 2)[0], " -> "):
+			return fmt.Errorf("app (%s) is a mapping file, provide it in the mapping_file input", pth)
+		case strings.HasPrefix(text, "<"):
+			return fmt.Errorf("app (%s) is an HTML or XML document, the download might have failed", pth)
+		default:
+			return fmt.Errorf("app (%s) is not an .apk or .aab file (not a zip archive)", pth)
+		}
+	}
+
+	r, err := zip.OpenReader(pth)
+	if err != nil {
+		return fmt.Errorf("app (%s) is corrupted or truncated, error: %s", pth, err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Warnf("failed to close (%s)", pth)
+		}
+	}()
+
+	files := map[string]bool{}
+	var nestedApps []string
+	for _, f := range r.File {
+		files[f.Name] = true
+		if ext := strings.ToLower(filepath.Ext(f.Name)); ext == ".apk" || ext == ".aab" {
+			nestedApps = append(nestedApps, f.Name)
+		}
+	}
+
+	isAAB := strings.ToLower(filepath.Ext(pth)) == ".aab"
+	switch {
+	case isAAB && files[aabManifestPath], !isAAB && files[apkManifestPath]:
+		return nil
+	case isAAB && files[apkManifestPath]:
+		return fmt.Errorf("app (%s) is an APK, but has .aab extension", pth)
+	case !isAAB && files[aabManifestPath]:
+		return fmt.Errorf("app (%s) is an app bundle, but has .apk extension", pth)
+	case len(nestedApps) > 0:
+		return fmt.Errorf("app (%s) is a zip of build outputs (%s), provide the apps themselves", pth, strings.Join(nestedApps, ", "))
+	default:
+		return fmt.Errorf("app (%s) is a zip archive without AndroidManifest.xml, not an .apk or .aab file", pth)
+	}
+}
+
+// readFileHeader returns the first n bytes of the given file, or the whole file if it is shorter.
+func readFileHeader(pth string, n int) ([]byte, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("failed to close (%s)", pth)
+		}
+	}()
+
+	header := make([]byte, n)
+	read, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return header[:read], nil
+}
+
+// appVersion identifies an app by its package name and version code.
+type appVersion struct {
+	packageName string
+	versionCode int64
+}
+
+// validateAppManifests checks if the package name of the given apps matches one of the expected package names and
+// their version codes are unique within their package. Apps with unreadable manifest are skipped with a warning.
+func validateAppManifests(appPaths []string, packageNames []string) error {
+	appsByVersion := map[appVersion]string{}
+	for _, pth := range appPaths {
+		manifest, err := readAppManifest(pth)
+		if err != nil {
+			log.Warnf("Failed to inspect app (%s), skipping validation: %s", pth, err)
+			continue
+		}
+		log.Printf("%s: package name: %s, version code: %d", pth, manifest.PackageName, manifest.VersionCode)
+
+		if manifest.PackageName != "" && !containsString(packageNames, manifest.PackageName) {
+			return fmt.Errorf("package name of app (%s) is %s, which does not match the package_name input: %s", pth, manifest.PackageName, strings.Join(packageNames, ", "))
+		}
+
+		if manifest.VersionCode == 0 {
+			continue
+		}
+		version := appVersion{packageName: manifest.PackageName, versionCode: manifest.VersionCode}
+		if other, ok := appsByVersion[version]; ok {
+			return fmt.Errorf("apps (%s, %s) have the same version code: %d, every uploaded app of a package needs a unique version code", other, pth, manifest.VersionCode)
+		}
+		appsByVersion[version] = pth
+	}
+	return nil
+}
+
+// appsOfPackage returns the apps built for the given package name. Apps with unreadable manifest are kept, so their
+// package name is validated on upload.
+func appsOfPackage(appPaths []string, packageName string) []string {
+	var apps []string
+	for _, pth := range appPaths {
+		if manifest, err := readAppManifest(pth); err == nil && manifest.PackageName != "" && manifest.PackageName != packageName {
+			log.Printf("Skipping %s, it is built for %s", pth, manifest.PackageName)
+			continue
+		}
+		apps = append(apps, pth)
+	}
+	return apps
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateReleaseBuilds checks if none of the given apps is debuggable and all of them are signed.
+func validateReleaseBuilds(appPaths []string) error {
+	for _, pth := range appPaths {
+		manifest, err := readAppManifest(pth)
+		if err != nil {
+			return fmt.Errorf("failed to inspect app (%s), error: %s", pth, err)
+		}
+		if manifest.Debuggable {
+			return fmt.Errorf("app (%s) is debuggable, debug builds should not be published", pth)
+		}
+
+		signed, err := isAppSigned(pth)
+		if err != nil {
+			return fmt.Errorf("failed to check the signature of app (%s), error: %s", pth, err)
+		}
+		if !signed {
+			return fmt.Errorf("app (%s) is not signed", pth)
+		}
+	}
+	return nil
+}
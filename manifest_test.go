@@ -0,0 +1,383 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testXMLAttribute is a manifest element attribute of the binary and protobuf XML test documents.
+type testXMLAttribute struct {
+	name     string
+	resID    uint32
+	value    string
+	dataType uint8
+	data     uint32
+}
+
+// testManifest is the content of the AndroidManifest.xml of the test apps.
+type testManifest struct {
+	attributes            []testXMLAttribute
+	applicationAttributes []testXMLAttribute
+}
+
+func newTestManifest(packageName string, versionCode uint32) testManifest {
+	return testManifest{
+		attributes: []testXMLAttribute{
+			{name: "versionCode", resID: versionCodeAttrResID, dataType: resValueTypeIntDec, data: versionCode},
+			{name: "package", value: packageName, dataType: resValueTypeString},
+		},
+		applicationAttributes: []testXMLAttribute{
+			{name: "label", value: "Sample", dataType: resValueTypeString},
+		},
+	}
+}
+
+// testBinaryXMLManifest returns an Android binary XML document with a manifest and an application element.
+func testBinaryXMLManifest(manifest testManifest) []byte {
+	// attribute names first, so the resource map can refer to them
+	var pool []string
+	var resIDs []uint32
+	for _, attr := range append(manifest.attributes, manifest.applicationAttributes...) {
+		pool = append(pool, attr.name)
+		resIDs = append(resIDs, attr.resID)
+	}
+	index := func(s string) uint32 {
+		for i, e := range pool {
+			if e == s {
+				return uint32(i)
+			}
+		}
+		pool = append(pool, s)
+		return uint32(len(pool) - 1)
+	}
+
+	le := binary.LittleEndian
+	u16 := func(b *bytes.Buffer, v uint16) { _ = binary.Write(b, le, v) }
+	u32 := func(b *bytes.Buffer, v uint32) { _ = binary.Write(b, le, v) }
+
+	var elements bytes.Buffer
+	nameOffset := 0
+	for _, element := range []struct {
+		name       string
+		attributes []testXMLAttribute
+	}{
+		{"manifest", manifest.attributes},
+		{"application", manifest.applicationAttributes},
+	} {
+		nameIdx := index(element.name)
+		u16(&elements, resXMLStartElement)
+		u16(&elements, 16)
+		u32(&elements, uint32(16+20+20*len(element.attributes)))
+		u32(&elements, 1)
+		u32(&elements, noIndex)
+		u32(&elements, noIndex)
+		u32(&elements, nameIdx)
+		u16(&elements, 20)
+		u16(&elements, 20)
+		u16(&elements, uint16(len(element.attributes)))
+		u16(&elements, 0)
+		u16(&elements, 0)
+		u16(&elements, 0)
+		for i, attr := range element.attributes {
+			valueIdx := uint32(noIndex)
+			if attr.value != "" {
+				valueIdx = index(attr.value)
+			}
+			data := attr.data
+			if attr.dataType == resValueTypeString {
+				data = valueIdx
+			}
+
+			u32(&elements, noIndex)
+			u32(&elements, uint32(nameOffset+i))
+			u32(&elements, valueIdx)
+			u16(&elements, 8)
+			elements.WriteByte(0)
+			elements.WriteByte(attr.dataType)
+			u32(&elements, data)
+		}
+		nameOffset += len(element.attributes)
+	}
+
+	var poolData bytes.Buffer
+	var offsets []uint32
+	for _, s := range pool {
+		offsets = append(offsets, uint32(poolData.Len()))
+		chars := utf16.Encode([]rune(s))
+		u16(&poolData, uint16(len(chars)))
+		for _, c := range chars {
+			u16(&poolData, c)
+		}
+		u16(&poolData, 0)
+	}
+	for poolData.Len()%4 != 0 {
+		poolData.WriteByte(0)
+	}
+
+	var stringPool bytes.Buffer
+	u16(&stringPool, resStringPoolType)
+	u16(&stringPool, 28)
+	u32(&stringPool, uint32(28+4*len(pool)+poolData.Len()))
+	u32(&stringPool, uint32(len(pool)))
+	u32(&stringPool, 0)
+	u32(&stringPool, 0)
+	u32(&stringPool, uint32(28+4*len(pool)))
+	u32(&stringPool, 0)
+	for _, o := range offsets {
+		u32(&stringPool, o)
+	}
+	stringPool.Write(poolData.Bytes())
+
+	var resourceMap bytes.Buffer
+	u16(&resourceMap, resXMLResourceMap)
+	u16(&resourceMap, 8)
+	u32(&resourceMap, uint32(8+4*len(resIDs)))
+	for _, resID := range resIDs {
+		u32(&resourceMap, resID)
+	}
+
+	var doc bytes.Buffer
+	u16(&doc, resXMLType)
+	u16(&doc, 8)
+	u32(&doc, uint32(8+stringPool.Len()+resourceMap.Len()+elements.Len()))
+	doc.Write(stringPool.Bytes())
+	doc.Write(resourceMap.Bytes())
+	doc.Write(elements.Bytes())
+	return doc.Bytes()
+}
+
+func protoKey(b *bytes.Buffer, number int, wireType uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	b.Write(buf[:binary.PutUvarint(buf, uint64(number)<<3|wireType)])
+}
+
+func protoVarint(b *bytes.Buffer, number int, v uint64) {
+	protoKey(b, number, 0)
+	buf := make([]byte, binary.MaxVarintLen64)
+	b.Write(buf[:binary.PutUvarint(buf, v)])
+}
+
+func protoBytes(b *bytes.Buffer, number int, v []byte) {
+	protoKey(b, number, 2)
+	buf := make([]byte, binary.MaxVarintLen64)
+	b.Write(buf[:binary.PutUvarint(buf, uint64(len(v)))])
+	b.Write(v)
+}
+
+// testProtoManifest returns an aapt2 protobuf XmlNode with a manifest and an application element. Integer and
+// boolean attributes are stored as compiled items, without their raw string value.
+func testProtoManifest(manifest testManifest) []byte {
+	protoElement := func(name string, attributes []testXMLAttribute, children ...[]byte) []byte {
+		var element bytes.Buffer
+		protoBytes(&element, 3, []byte(name))
+		for _, attr := range attributes {
+			var a bytes.Buffer
+			protoBytes(&a, 2, []byte(attr.name))
+			if attr.value != "" {
+				protoBytes(&a, 3, []byte(attr.value))
+			}
+			if attr.resID != 0 {
+				protoVarint(&a, 5, uint64(attr.resID))
+			}
+			if attr.dataType != resValueTypeString {
+				var prim, item bytes.Buffer
+				primField := 6
+				if attr.dataType == resValueTypeBoolean {
+					primField = 8
+				}
+				protoVarint(&prim, primField, uint64(attr.data))
+				protoBytes(&item, 7, prim.Bytes())
+				protoBytes(&a, 6, item.Bytes())
+			}
+			protoBytes(&element, 4, a.Bytes())
+		}
+		for _, child := range children {
+			protoBytes(&element, 5, child)
+		}
+
+		var node bytes.Buffer
+		protoBytes(&node, 1, element.Bytes())
+		return node.Bytes()
+	}
+
+	return protoElement("manifest", manifest.attributes, protoElement("application", manifest.applicationAttributes))
+}
+
+// createTestApp creates an .apk or .aab file (based on the extension of the given path) with the given manifest and
+// extra files.
+func createTestApp(t *testing.T, pth string, manifest testManifest, files map[string][]byte) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+
+	manifestPth, content := apkManifestPath, testBinaryXMLManifest(manifest)
+	if filepath.Ext(pth) == ".aab" {
+		manifestPth, content = aabManifestPath, testProtoManifest(manifest)
+	}
+	if files == nil {
+		files = map[string][]byte{}
+	}
+	files[manifestPth] = content
+
+	for name, content := range files {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, ioutil.WriteFile(pth, buf.Bytes(), 0600))
+}
+
+func Test_readAppManifest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_readAppManifest")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	t.Log("readAppManifest - apk")
+	{
+		pth := filepath.Join(tmpDir, "app.apk")
+		testManifest := newTestManifest("io.bitrise.sample", 42)
+		testManifest.attributes = append(testManifest.attributes, testXMLAttribute{name: "versionName", resID: versionNameAttrResID, value: "1.0.42", dataType: resValueTypeString})
+		createTestApp(t, pth, testManifest, nil)
+
+		manifest, err := readAppManifest(pth)
+		require.NoError(t, err)
+		require.Equal(t, appManifest{PackageName: "io.bitrise.sample", VersionCode: 42, VersionName: "1.0.42"}, manifest)
+	}
+
+	t.Log("readAppManifest - aab")
+	{
+		pth := filepath.Join(tmpDir, "app.aab")
+		testManifest := newTestManifest("io.bitrise.sample", 43)
+		testManifest.attributes = append(testManifest.attributes, testXMLAttribute{name: "versionName", resID: versionNameAttrResID, value: "1.0.43", dataType: resValueTypeString})
+		createTestApp(t, pth, testManifest, nil)
+
+		manifest, err := readAppManifest(pth)
+		require.NoError(t, err)
+		require.Equal(t, appManifest{PackageName: "io.bitrise.sample", VersionCode: 43, VersionName: "1.0.43"}, manifest)
+	}
+
+	t.Log("readAppManifest - not a zip")
+	{
+		pth := filepath.Join(tmpDir, "mapping.apk")
+		require.NoError(t, ioutil.WriteFile(pth, []byte("mapping"), 0600))
+
+		_, err := readAppManifest(pth)
+		require.Error(t, err)
+	}
+}
+
+func Test_decodeUTF8PoolString(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 200)
+
+	got, err := decodeUTF8PoolString(append([]byte{7, 7}, []byte("package")...))
+	require.NoError(t, err)
+	require.Equal(t, "package", got)
+
+	got, err = decodeUTF8PoolString(append([]byte{0x80, 200, 0x80, 200}, long...))
+	require.NoError(t, err)
+	require.Equal(t, string(long), got)
+
+	_, err = decodeUTF8PoolString([]byte{7, 7, 'p'})
+	require.Error(t, err)
+}
+
+func Test_validateAppManifests(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_validateAppManifests")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	app1 := filepath.Join(tmpDir, "app1.aab")
+	createTestApp(t, app1, newTestManifest("io.bitrise.sample", 1), nil)
+	app2 := filepath.Join(tmpDir, "app2.aab")
+	createTestApp(t, app2, newTestManifest("io.bitrise.sample", 2), nil)
+	duplicate := filepath.Join(tmpDir, "duplicate.apk")
+	createTestApp(t, duplicate, newTestManifest("io.bitrise.sample", 2), nil)
+	other := filepath.Join(tmpDir, "other.apk")
+	createTestApp(t, other, newTestManifest("io.bitrise.other", 3), nil)
+	otherSameVersion := filepath.Join(tmpDir, "other-same-version.apk")
+	createTestApp(t, otherSameVersion, newTestManifest("io.bitrise.other", 2), nil)
+
+	require.NoError(t, validateAppManifests([]string{app1, app2}, []string{"io.bitrise.sample"}))
+	require.Error(t, validateAppManifests([]string{app1, app2, duplicate}, []string{"io.bitrise.sample"}))
+	require.Error(t, validateAppManifests([]string{app1, other}, []string{"io.bitrise.sample"}))
+	require.NoError(t, validateAppManifests([]string{app1, other}, []string{"io.bitrise.sample", "io.bitrise.other"}))
+	require.NoError(t, validateAppManifests([]string{app1, app2, otherSameVersion}, []string{"io.bitrise.sample", "io.bitrise.other"}))
+
+	require.Equal(t, []string{app1, app2}, appsOfPackage([]string{app1, other, app2}, "io.bitrise.sample"))
+	require.Equal(t, []string{other}, appsOfPackage([]string{app1, other, app2}, "io.bitrise.other"))
+}
+
+func Test_validateAppContent(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_validateAppContent")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	manifest := newTestManifest("io.bitrise.sample", 1)
+	apk := filepath.Join(tmpDir, "app.apk")
+	createTestApp(t, apk, manifest, nil)
+	aab := filepath.Join(tmpDir, "app.aab")
+	createTestApp(t, aab, manifest, nil)
+
+	apkAsAAB := filepath.Join(tmpDir, "apk.aab")
+	content, err := ioutil.ReadFile(apk)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(apkAsAAB, content, 0600))
+	truncated := filepath.Join(tmpDir, "truncated.apk")
+	require.NoError(t, ioutil.WriteFile(truncated, content[:len(content)/2], 0600))
+
+	outputs := filepath.Join(tmpDir, "outputs.apk")
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	_, err = w.Create("release/app.apk")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, ioutil.WriteFile(outputs, buf.Bytes(), 0600))
+
+	mapping := filepath.Join(tmpDir, "mapping.apk")
+	require.NoError(t, ioutil.WriteFile(mapping, []byte("io.bitrise.sample.MainActivity -> a.a:\n"), 0600))
+	html := filepath.Join(tmpDir, "html.aab")
+	require.NoError(t, ioutil.WriteFile(html, []byte("<!DOCTYPE html><html>Access denied</html>"), 0600))
+	empty := filepath.Join(tmpDir, "empty.aab")
+	require.NoError(t, ioutil.WriteFile(empty, []byte{}, 0600))
+
+	tests := []struct {
+		name    string
+		pth     string
+		wantErr string
+	}{
+		{"apk", apk, ""},
+		{"aab", aab, ""},
+		{"apk with .aab extension", apkAsAAB, "is an APK, but has .aab extension"},
+		{"truncated", truncated, "is corrupted or truncated"},
+		{"zip of outputs", outputs, "is a zip of build outputs"},
+		{"mapping file", mapping, "is a mapping file"},
+		{"html", html, "the download might have failed"},
+		{"empty", empty, "is an empty file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAppContent(tt.pth)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// The patterns of the HTML and Markdown syntax removed from the release notes.
+var (
+	htmlLineBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|h[1-6])>`)
+	htmlListItemPattern  = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlTagPattern       = regexp.MustCompile(`<[^>]+>`)
+
+	markdownCodeFencePattern  = regexp.MustCompile("^\\s*(```|~~~)")
+	markdownRulePattern       = regexp.MustCompile(`^\s*([-*_]\s*){3,}$`)
+	markdownHeadingPattern    = regexp.MustCompile(`^\s*#{1,6}\s+`)
+	markdownQuotePattern      = regexp.MustCompile(`^\s*>\s?`)
+	markdownListItemPattern   = regexp.MustCompile(`^(\s*)[-*+]\s+`)
+	markdownImagePattern      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLinkPattern       = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	markdownStrongPattern     = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	markdownEmphasisPattern   = regexp.MustCompile(`\*([^*\s][^*]*)\*`)
+	markdownStrikePattern     = regexp.MustCompile(`~~([^~]+)~~`)
+	markdownInlineCodePattern = regexp.MustCompile("`([^`]+)`")
+	blankLinesPattern         = regexp.MustCompile(`\n{3,}`)
+)
+
+// stripMarkup converts release notes written in Markdown or HTML to plain text, as Google Play shows the release
+// notes as they are. List items are kept as bullet points, links are replaced with their text.
+func stripMarkup(text string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = htmlLineBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlListItemPattern.ReplaceAllString(text, "• ")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if markdownCodeFencePattern.MatchString(line) || markdownRulePattern.MatchString(line) {
+			continue
+		}
+		line = markdownHeadingPattern.ReplaceAllString(line, "")
+		line = markdownQuotePattern.ReplaceAllString(line, "")
+		line = markdownListItemPattern.ReplaceAllString(line, "$1• ")
+		line = markdownImagePattern.ReplaceAllString(line, "$1")
+		line = markdownLinkPattern.ReplaceAllString(line, "$1")
+		line = markdownStrongPattern.ReplaceAllString(line, "$1$2")
+		line = markdownEmphasisPattern.ReplaceAllString(line, "$1")
+		line = markdownStrikePattern.ReplaceAllString(line, "$1")
+		line = markdownInlineCodePattern.ReplaceAllString(line, "$1")
+		lines = append(lines, strings.TrimRight(line, " \t"))
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_stripMarkup(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "plain text",
+			text: "Bug fixes and improvements.\n",
+			want: "Bug fixes and improvements.",
+		},
+		{
+			name: "markdown",
+			text: "## What's new\n\n- **Dark mode** for the [settings](https://example.com/settings)\n* Fixed a *rare* crash in `sync`\n\n---\n> ~~Old~~ New onboarding",
+			want: "What's new\n\n• Dark mode for the settings\n• Fixed a rare crash in sync\n\nOld New onboarding",
+		},
+		{
+			name: "html",
+			text: "<p>What&#39;s new</p><ul><li>Dark mode</li><li>Faster <b>sync</b> &amp; backup</li></ul>",
+			want: "What's new\n• Dark mode\n• Faster sync & backup",
+		},
+		{
+			name: "snake case and multiplication",
+			text: "Renamed user_id to account_id, 2 * 3 items",
+			want: "Renamed user_id to account_id, 2 * 3 items",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, stripMarkup(tt.text))
+		})
+	}
+}
@@ -3,22 +3,26 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/retry"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
-	"google.golang.org/api/androidpublisher/v3"
 )
 
-// createHTTPClient creates an HTTP client for the communication during the uploads.
-func createHTTPClient(jsonKeyPth string) (*http.Client, error) {
+// createHTTPClient creates an HTTP client authorized for the given scopes with the service account JSON key. The
+// token requests and the API calls are sent with the given transport.
+func createHTTPClient(jsonKeyPth string, transport http.RoundTripper, scopes ...string) (*http.Client, error) {
 	jsonKeyPth, isRemote, err := parseURI(string(jsonKeyPth))
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare key path (%s), error: %s", jsonKeyPth, err)
@@ -31,27 +35,29 @@ func createHTTPClient(jsonKeyPth string) (*http.Client, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to download json key file, error: %s", err)
 		}
-		authConfig, authConfErr = google.JWTConfigFromJSON(jsonContent, androidpublisher.AndroidpublisherScope)
+		authConfig, authConfErr = google.JWTConfigFromJSON(jsonContent, scopes...)
 		if authConfErr != nil {
 			return nil, err
 		}
 	} else {
-		authConfig, authConfErr = jwtConfigFromJSONKeyFile(jsonKeyPth)
+		authConfig, authConfErr = jwtConfigFromJSONKeyFile(jsonKeyPth, scopes...)
 		if authConfErr != nil {
 			return nil, fmt.Errorf("failed to create auth config from json key file %v, error: %s", jsonKeyPth, err)
 		}
 	}
-	return authConfig.Client(context.TODO()), nil
+
+	ctx := context.WithValue(context.TODO(), oauth2.HTTPClient, &http.Client{Transport: transport})
+	return authConfig.Client(ctx), nil
 }
 
 // jwtConfigFromJSONKeyFile gets the jwt config from the given file.
-func jwtConfigFromJSONKeyFile(pth string) (*jwt.Config, error) {
+func jwtConfigFromJSONKeyFile(pth string, scopes ...string) (*jwt.Config, error) {
 	jsonKeyBytes, err := fileutil.ReadBytesFromFile(pth)
 	if err != nil {
 		return nil, err
 	}
 
-	cfg, err := google.JWTConfigFromJSON(jsonKeyBytes, androidpublisher.AndroidpublisherScope)
+	cfg, err := google.JWTConfigFromJSON(jsonKeyBytes, scopes...)
 	if err != nil {
 		return nil, err
 	}
@@ -99,3 +105,68 @@ func downloadContent(downloadURL string) ([]byte, error) {
 
 	return contentBytes, nil
 }
+
+// downloadFileWithRetry calls downloadFile method with a given number of retries and waiting interval between the retries.
+func downloadFileWithRetry(downloadURL, dst string, numberOfRetries, waitInterval uint) error {
+	return retry.Times(numberOfRetries).Wait(time.Duration(waitInterval) * time.Second).Try(func(attempt uint) error {
+		if attempt > 0 {
+			log.Warnf("Retrying download of %s (%d/%d)", downloadURL, attempt, numberOfRetries)
+		}
+		return downloadFile(downloadURL, dst)
+	})
+}
+
+// downloadFile streams the body of the response of the given url into the destination file, logging the progress.
+func downloadFile(downloadURL, dst string) error {
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return fmt.Errorf("failed to download from (%s), error: %s", downloadURL, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("failed to close (%s) body", downloadURL)
+		}
+	}()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to download from (%s), status code: %d", downloadURL, resp.StatusCode)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return fmt.Errorf("failed to create download directory, error: %s", err)
+	}
+	file, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create file (%s), error: %s", dst, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("failed to close (%s)", dst)
+		}
+	}()
+
+	progress := &progressWriter{total: resp.ContentLength}
+	if _, err := io.Copy(file, io.TeeReader(resp.Body, progress)); err != nil {
+		return fmt.Errorf("failed to download from (%s), error: %s", downloadURL, err)
+	}
+	log.Printf(" downloaded %d bytes", progress.written)
+	return nil
+}
+
+// progressWriter counts the bytes written into it and logs the progress in every 10 percent.
+type progressWriter struct {
+	total   int64
+	written int64
+	logged  int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if w.total > 0 {
+		if percent := w.written * 100 / w.total; percent >= w.logged+10 {
+			w.logged = percent - percent%10
+			log.Printf(" %d%% (%d/%d bytes)", w.logged, w.written, w.total)
+		}
+	}
+	return len(p), nil
+}
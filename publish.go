@@ -1,13 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 
-	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/log"
 	"google.golang.org/api/androidpublisher/v3"
 	"google.golang.org/api/googleapi"
@@ -20,8 +29,14 @@ const (
 	releaseStatusHalted     = "halted"
 )
 
+// maxReleaseNotesLength is the max length of the release notes of a language.
+const maxReleaseNotesLength = 500
+
+// processingPollInterval is the interval of checking if the uploaded apps are processed.
+const processingPollInterval = 10 * time.Second
+
 // uploadExpansionFiles uploads the expansion files for given applications, like .obb files.
-func uploadExpansionFiles(service *androidpublisher.Service, expFileEntry string, packageName string, appEditID string, versionCode int64) error {
+func uploadExpansionFiles(ctx context.Context, service *androidpublisher.Service, expFileEntry string, packageName string, appEditID string, versionCode int64, uploadTimeout time.Duration) error {
 	cleanExpFileConfigEntry := strings.TrimSpace(expFileEntry)
 	if !validateExpansionFileConfig(cleanExpFileConfigEntry) {
 		return fmt.Errorf("invalid expansion file config: %s", expFileEntry)
@@ -31,6 +46,15 @@ func uploadExpansionFiles(service *androidpublisher.Service, expFileEntry string
 	if err != nil {
 		return err
 	}
+
+	referencedVersionCode, isReference, err := expFileReferencedVersion(expFilePth)
+	if err != nil {
+		return err
+	}
+	if isReference {
+		return referenceExpansionFile(ctx, service, packageName, appEditID, versionCode, expFileType, referencedVersionCode)
+	}
+
 	expansionFile, err := os.Open(expFilePth)
 	if err != nil {
 		return fmt.Errorf("failed to read expansion file (%v), error: %s", expansionFile, err)
@@ -39,6 +63,11 @@ func uploadExpansionFiles(service *androidpublisher.Service, expFileEntry string
 	editsExpansionFilesService := androidpublisher.NewEditsExpansionfilesService(service)
 	editsExpansionFilesCall := editsExpansionFilesService.Upload(packageName, appEditID, versionCode, expFileType)
 	editsExpansionFilesCall.Media(expansionFile, googleapi.ContentType("application/octet-stream"))
+
+	ctx, cancel := uploadContext(ctx, uploadTimeout)
+	defer cancel()
+	editsExpansionFilesCall.Context(ctx)
+
 	if _, err := editsExpansionFilesCall.Do(); err != nil {
 		return fmt.Errorf("failed to upload expansion file, error: %s", err)
 	}
@@ -46,6 +75,54 @@ func uploadExpansionFiles(service *androidpublisher.Service, expFileEntry string
 	return nil
 }
 
+// callParameter is a query parameter of an API call which has no setter in the client library.
+type callParameter struct {
+	key, value string
+}
+
+// Get implements googleapi.CallOption.
+func (p callParameter) Get() (string, string) {
+	return p.key, p.value
+}
+
+// uploadContext returns the context of a media upload call, which is cancelled with the given parent context or after
+// the given timeout if it is set.
+func uploadContext(ctx context.Context, uploadTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if uploadTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, uploadTimeout)
+}
+
+// referenceExpansionFile points the expansion file of the given application to the expansion file of an earlier
+// version, so the same .obb file does not need to be uploaded again.
+func referenceExpansionFile(ctx context.Context, service *androidpublisher.Service, packageName string, appEditID string, versionCode int64, expFileType string, referencedVersionCode int64) error {
+	log.Debugf("Referencing %s expansion file of version code '%v' with package name '%v', AppEditId '%v', version code '%v'", expFileType, referencedVersionCode, packageName, appEditID, versionCode)
+	editsExpansionFilesService := androidpublisher.NewEditsExpansionfilesService(service)
+	editsExpansionFilesCall := editsExpansionFilesService.Update(packageName, appEditID, versionCode, expFileType, &androidpublisher.ExpansionFile{
+		ReferencesVersion: referencedVersionCode,
+	})
+	if _, err := editsExpansionFilesCall.Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to reference expansion file of version code %d, error: %s", referencedVersionCode, err)
+	}
+	log.Infof("Referenced %s expansion file of version code %d", expFileType, referencedVersionCode)
+	return nil
+}
+
+// expFileReferencedVersion returns the version code and true if the given expansion file path refers to the expansion
+// file of a previously uploaded version (like "@123"), false otherwise.
+func expFileReferencedVersion(expFilePth string) (int64, bool, error) {
+	if !strings.HasPrefix(expFilePth, "@") {
+		return 0, false, nil
+	}
+
+	versionCode, err := strconv.ParseInt(strings.TrimPrefix(expFilePth, "@"), 10, 64)
+	if err != nil || versionCode <= 0 {
+		return 0, false, fmt.Errorf("invalid expansion file version code reference: %s", expFilePth)
+	}
+	return versionCode, true, nil
+}
+
 // expFilePth gets the expansion file path from a given config entry
 func expFileInfo(expFileConfigEntry string) (string, string, error) {
 	// "main:/file/path/1.obb"
@@ -72,34 +149,105 @@ func validateExpansionFileConfig(expFileEntry string) bool {
 }
 
 // uploadMappingFile uploads the mapping files (that are used for deobfuscation) to Google Play.
-func uploadMappingFile(service *androidpublisher.Service, configs Configs, appEditID string, versionCode int64) error {
-	log.Debugf("Getting mapping file from %v", configs.MappingFile)
-	mappingFile, err := os.Open(configs.MappingFile)
+func uploadMappingFile(ctx context.Context, service *androidpublisher.Service, packageName string, appEditID string, mappingFile deobfuscationFile, versionCode int64, uploadTimeout time.Duration) error {
+	log.Debugf("Getting %s deobfuscation file from %v", mappingFile.fileType, mappingFile.path)
+	file, err := os.Open(mappingFile.path)
 	if err != nil {
-		return fmt.Errorf("failed to read mapping file (%s), error: %s", configs.MappingFile, err)
+		return fmt.Errorf("failed to read mapping file (%s), error: %s", mappingFile.path, err)
 	}
-	log.Debugf("Uploading mapping file %v with package name '%v', AppEditId '%v', version code '%v'", configs.MappingFile, configs.PackageName, appEditID, versionCode)
+	log.Debugf("Uploading mapping file %v with package name '%v', AppEditId '%v', version code '%v'", mappingFile.path, packageName, appEditID, versionCode)
 	editsDeobfuscationFilesService := androidpublisher.NewEditsDeobfuscationfilesService(service)
-	editsDeobfuscationFilesUploadCall := editsDeobfuscationFilesService.Upload(configs.PackageName, appEditID, versionCode, "proguard")
-	editsDeobfuscationFilesUploadCall.Media(mappingFile, googleapi.ContentType("application/octet-stream"))
+	editsDeobfuscationFilesUploadCall := editsDeobfuscationFilesService.Upload(packageName, appEditID, versionCode, mappingFile.fileType)
+	editsDeobfuscationFilesUploadCall.Media(file, googleapi.ContentType("application/octet-stream"))
+
+	ctx, cancel := uploadContext(ctx, uploadTimeout)
+	defer cancel()
+	editsDeobfuscationFilesUploadCall.Context(ctx)
 
 	if _, err = editsDeobfuscationFilesUploadCall.Do(); err != nil {
-		return fmt.Errorf("failed to upload mapping file, error: %s", err)
+		return fmt.Errorf("failed to upload %s deobfuscation file, error: %s", mappingFile.fileType, err)
 	}
 
-	log.Printf(" uploaded mapping file for apk version: %d", versionCode)
+	log.Printf(" uploaded %s deobfuscation file for version: %d", mappingFile.fileType, versionCode)
 	return nil
 }
 
+// uploadedAppHashes returns the version codes of the app bundles and APKs uploaded for the app by their sha256 hash.
+func uploadedAppHashes(ctx context.Context, service *androidpublisher.Service, packageName, appEditID string) (map[string]int64, error) {
+	bundles, err := androidpublisher.NewEditsBundlesService(service).List(packageName, appEditID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list app bundles, error: %s", err)
+	}
+	apks, err := androidpublisher.NewEditsApksService(service).List(packageName, appEditID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list APKs, error: %s", err)
+	}
+
+	hashes := map[string]int64{}
+	for _, bundle := range bundles.Bundles {
+		hashes[strings.ToLower(bundle.Sha256)] = bundle.VersionCode
+	}
+	for _, apk := range apks.Apks {
+		if apk.Binary != nil {
+			hashes[strings.ToLower(apk.Binary.Sha256)] = apk.VersionCode
+		}
+	}
+	return hashes, nil
+}
+
+// waitForProcessing polls the uploaded apps of the edit until all of the given version codes are listed, so Google
+// Play finished processing them, or the given timeout elapses.
+func waitForProcessing(ctx context.Context, service *androidpublisher.Service, packageName, appEditID string, versionCodes []int64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		uploaded, err := uploadedVersionCodes(ctx, service, packageName, appEditID)
+		if err != nil {
+			return err
+		}
+		pending := missingVersionCodes(uploaded, versionCodes)
+		if len(pending) == 0 {
+			return nil
+		}
+		if !time.Now().Add(processingPollInterval).Before(deadline) {
+			return fmt.Errorf("apps (version codes: %v) are still processing after %s", pending, timeout)
+		}
+		log.Printf("Apps (version codes: %v) are still processing, checking again in %s", pending, processingPollInterval)
+		if err := sleepContext(ctx, processingPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// missingVersionCodes returns the version codes which are not in the given list.
+func missingVersionCodes(list []int64, versionCodes []int64) []int64 {
+	var missing []int64
+	for _, versionCode := range versionCodes {
+		if !containsVersionCode(list, versionCode) {
+			missing = append(missing, versionCode)
+		}
+	}
+	return missing
+}
+
 // uploadAppBundle uploads aab files to Google Play. Returns the uploaded bundle itself or an error.
-func uploadAppBundle(service *androidpublisher.Service, packageName string, appEditID string, appFile *os.File) (*androidpublisher.Bundle, error) {
+func uploadAppBundle(ctx context.Context, service *androidpublisher.Service, packageName string, appEditID string, appFile *os.File, uploadTimeout time.Duration, deviceTierConfigID string) (*androidpublisher.Bundle, error) {
 	log.Debugf("Uploading file %v with package name '%v', AppEditId '%v", appFile, packageName, appEditID)
 	editsBundlesService := androidpublisher.NewEditsBundlesService(service)
 
 	editsBundlesUploadCall := editsBundlesService.Upload(packageName, appEditID)
 	editsBundlesUploadCall.Media(appFile, googleapi.ContentType("application/octet-stream"))
 
-	bundle, err := editsBundlesUploadCall.Do()
+	ctx, cancel := uploadContext(ctx, uploadTimeout)
+	defer cancel()
+	editsBundlesUploadCall.Context(ctx)
+
+	var opts []googleapi.CallOption
+	if deviceTierConfigID != "" {
+		log.Printf(" device tier config: %s", deviceTierConfigID)
+		opts = append(opts, callParameter{key: "deviceTierConfigId", value: deviceTierConfigID})
+	}
+
+	bundle, err := editsBundlesUploadCall.Do(opts...)
 	if err != nil {
 		return &androidpublisher.Bundle{}, fmt.Errorf("failed to upload app bundle, error: %s", err)
 	}
@@ -108,13 +256,17 @@ func uploadAppBundle(service *androidpublisher.Service, packageName string, appE
 }
 
 // uploadAppApk uploads an apk file to Google Play. Returns the apk itself or an error.
-func uploadAppApk(service *androidpublisher.Service, packageName string, appEditID string, appFile *os.File) (*androidpublisher.Apk, error) {
+func uploadAppApk(ctx context.Context, service *androidpublisher.Service, packageName string, appEditID string, appFile *os.File, uploadTimeout time.Duration) (*androidpublisher.Apk, error) {
 	log.Debugf("Uploading file %v with package name '%v', AppEditId '%v", appFile, packageName, appEditID)
 	editsApksService := androidpublisher.NewEditsApksService(service)
 
 	editsApksUploadCall := editsApksService.Upload(packageName, appEditID)
 	editsApksUploadCall.Media(appFile, googleapi.ContentType("application/vnd.android.package-archive"))
 
+	ctx, cancel := uploadContext(ctx, uploadTimeout)
+	defer cancel()
+	editsApksUploadCall.Context(ctx)
+
 	apk, err := editsApksUploadCall.Do()
 	if err != nil {
 		return &androidpublisher.Apk{}, fmt.Errorf("failed to upload apk, error: %s", err)
@@ -124,23 +276,15 @@ func uploadAppApk(service *androidpublisher.Service, packageName string, appEdit
 }
 
 // updates the listing info of a given release.
-func updateListing(whatsNewsDir string, release *androidpublisher.TrackRelease) error {
-	log.Debugf("Checking if updating listing is required, whats new dir is '%v'", whatsNewsDir)
-	if whatsNewsDir != "" {
+func updateListing(config Configs, variables map[string]string, release *androidpublisher.TrackRelease) error {
+	log.Debugf("Checking if updating listing is required, whats new dir is '%v', release notes path is '%v'", config.WhatsnewsDir, config.ReleaseNotesPath)
+	if config.hasReleaseNotes() {
 		fmt.Println()
 		log.Infof("Update listing started")
 
-		recentChangesMap, err := readLocalisedRecentChanges(whatsNewsDir)
+		releaseNotes, err := releaseNotesTexts(config, variables)
 		if err != nil {
-			return fmt.Errorf("failed to read whatsnews, error: %s", err)
-		}
-
-		var releaseNotes []*androidpublisher.LocalizedText
-		for language, recentChanges := range recentChangesMap {
-			releaseNotes = append(releaseNotes, &androidpublisher.LocalizedText{
-				Language: language,
-				Text:     recentChanges,
-			})
+			return err
 		}
 		release.ReleaseNotes = releaseNotes
 		log.Infof("Update listing finished")
@@ -148,32 +292,238 @@ func updateListing(whatsNewsDir string, release *androidpublisher.TrackRelease)
 	return nil
 }
 
+// releaseNotesTexts returns the release notes of the release by language, converted to plain text, with the
+// placeholders replaced and limited to the max length.
+func releaseNotesTexts(config Configs, variables map[string]string) ([]*androidpublisher.LocalizedText, error) {
+	recentChangesMap, err := config.releaseNotes()
+	if err != nil {
+		return nil, err
+	}
+
+	var releaseNotes []*androidpublisher.LocalizedText
+	for language, recentChanges := range recentChangesMap {
+		if config.StripReleaseNotesMarkup {
+			recentChanges = stripMarkup(recentChanges)
+		}
+		recentChanges, err := limitReleaseNotes(language, expandReleaseNotes(recentChanges, variables), config.TruncateReleaseNotes)
+		if err != nil {
+			return nil, err
+		}
+		releaseNotes = append(releaseNotes, &androidpublisher.LocalizedText{
+			Language: language,
+			Text:     recentChanges,
+		})
+	}
+	sort.Slice(releaseNotes, func(i, j int) bool { return releaseNotes[i].Language < releaseNotes[j].Language })
+	return releaseNotes, nil
+}
+
+// releaseNotesPlaceholderPattern matches the placeholders of the release notes: {version_name}, {version_code},
+// {git_tag} and {env:NAME} for any environment variable.
+var releaseNotesPlaceholderPattern = regexp.MustCompile(`\{(version_name|version_code|git_tag|env:[A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// releaseNotesVariables returns the values of the release notes placeholders for the release of the given version
+// codes. The version name is read from the app with the highest version code.
+func releaseNotesVariables(config Configs, versionCodes []int64) map[string]string {
+	variables := map[string]string{}
+	if gitTag, ok := os.LookupEnv("BITRISE_GIT_TAG"); ok {
+		variables["git_tag"] = gitTag
+	}
+
+	var versionCode int64
+	for _, code := range versionCodes {
+		if code > versionCode {
+			versionCode = code
+		}
+	}
+	if versionCode == 0 {
+		return variables
+	}
+	variables["version_code"] = strconv.FormatInt(versionCode, 10)
+
+	apps, err := config.packageAppPaths()
+	if err != nil {
+		log.Debugf("Failed to get the apps for the version name, error: %s", err)
+		return variables
+	}
+	for _, pth := range apps {
+		manifest, err := readAppManifest(pth)
+		if err != nil {
+			log.Debugf("Failed to read the manifest of app (%s), error: %s", pth, err)
+			continue
+		}
+		if manifest.VersionCode == versionCode && manifest.VersionName != "" {
+			variables["version_name"] = manifest.VersionName
+			break
+		}
+	}
+	return variables
+}
+
+// expandReleaseNotes replaces the placeholders of the release notes with the given variables or environment variables.
+// Placeholders without a value are left unchanged.
+func expandReleaseNotes(releaseNotes string, variables map[string]string) string {
+	return releaseNotesPlaceholderPattern.ReplaceAllStringFunc(releaseNotes, func(placeholder string) string {
+		name := strings.Trim(placeholder, "{}")
+
+		var value string
+		var ok bool
+		if strings.HasPrefix(name, "env:") {
+			value, ok = os.LookupEnv(strings.TrimPrefix(name, "env:"))
+		} else {
+			value, ok = variables[name]
+		}
+		if !ok {
+			log.Warnf("No value found for %s in the release notes, leaving it unchanged", placeholder)
+			return placeholder
+		}
+		return value
+	})
+}
+
+// releaseNotes reads the release notes of the what's new directories, the release notes file and the release notes
+// JSON by language. The what's new directories are merged per language, the later directories override the earlier
+// ones. The release notes file overrides the what's new directories, the JSON overrides both for its languages.
+// If none of them gives release notes, the git message is used if release_notes_from_git_message is set.
+func (c Configs) releaseNotes() (map[string]string, error) {
+	aliases, err := c.localeAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	recentChangesMap := map[string]string{}
+	for _, dir := range parseInputList(c.WhatsnewsDir) {
+		dirRecentChanges, err := readLocalisedRecentChanges(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read whatsnews, error: %s", err)
+		}
+		for language, recentChanges := range applyLocaleAliases(dirRecentChanges, aliases) {
+			if _, ok := recentChangesMap[language]; ok {
+				log.Debugf("Release notes of %s are overridden by %s", language, dir)
+			}
+			recentChangesMap[language] = recentChanges
+		}
+	}
+	if c.ReleaseNotesPath != "" {
+		recentChanges, err := readTextFile(c.ReleaseNotesPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read release notes, error: %s", err)
+		}
+		if _, ok := recentChangesMap[c.ReleaseNotesLanguage]; ok {
+			log.Warnf("Release notes of %s are given in the what's new directory too, using %s", c.ReleaseNotesLanguage, c.ReleaseNotesPath)
+		}
+		recentChangesMap[c.ReleaseNotesLanguage] = recentChanges
+	}
+	if c.ReleaseNotesJSON != "" {
+		jsonReleaseNotes, err := c.releaseNotesJSON()
+		if err != nil {
+			return nil, err
+		}
+		for language, recentChanges := range jsonReleaseNotes {
+			if _, ok := recentChangesMap[language]; ok {
+				log.Warnf("Release notes of %s are given in the release notes JSON too, using the JSON", language)
+			}
+			recentChangesMap[language] = recentChanges
+		}
+	}
+	if len(recentChangesMap) == 0 && c.ReleaseNotesFromGitMessage {
+		if message := strings.TrimSpace(os.Getenv("BITRISE_GIT_MESSAGE")); message != "" {
+			log.Printf("No release notes given, using the git message as the %s release notes", c.ReleaseNotesLanguage)
+			recentChangesMap[c.ReleaseNotesLanguage] = message
+		} else {
+			log.Warnf("No release notes given and the git message ($BITRISE_GIT_MESSAGE) is empty")
+		}
+	}
+	return recentChangesMap, nil
+}
+
+// applyLocaleAliases replaces the locale aliases (like en) of the release notes with their locale (like en-US). The
+// release notes given for the locale itself take precedence over the ones given for its alias.
+func applyLocaleAliases(releaseNotes map[string]string, aliases map[string]string) map[string]string {
+	result := map[string]string{}
+	for language, notes := range releaseNotes {
+		if _, isAlias := aliases[language]; !isAlias {
+			result[language] = notes
+		}
+	}
+	for language, notes := range releaseNotes {
+		locale, isAlias := aliases[language]
+		if !isAlias {
+			continue
+		}
+		if _, ok := result[locale]; ok {
+			log.Warnf("Release notes are given for both %s and its alias %s, using %s", locale, language, locale)
+			continue
+		}
+		log.Printf("Using the release notes of %s for %s", language, locale)
+		result[locale] = notes
+	}
+	return result
+}
+
+// limitReleaseNotes returns an error if the given release notes are longer than Google Play allows, or truncates them
+// at a word boundary with an ellipsis if truncate is set.
+func limitReleaseNotes(language, releaseNotes string, truncate bool) (string, error) {
+	runes := []rune(releaseNotes)
+	if len(runes) <= maxReleaseNotesLength {
+		return releaseNotes, nil
+	}
+	if !truncate {
+		return "", fmt.Errorf("release notes of %s are %d characters long, the maximum is %d", language, len(runes), maxReleaseNotesLength)
+	}
+
+	const ellipsis = "…"
+	truncated := string(runes[:maxReleaseNotesLength-utf8.RuneCountInString(ellipsis)])
+	if i := strings.LastIndexFunc(truncated, unicode.IsSpace); i > 0 {
+		truncated = truncated[:i]
+	}
+	truncated = strings.TrimRightFunc(truncated, unicode.IsSpace) + ellipsis
+	log.Warnf("Release notes of %s are %d characters long, truncated to %d characters", language, len(runes), utf8.RuneCountInString(truncated))
+	return truncated, nil
+}
+
+// recentChangesFileNamePattern matches the name of a what's new file (without its .txt extension): the locale,
+// optionally prefixed with whatsnew-, like whatsnew-en-US, en-US or de.
+var recentChangesFileNamePattern = regexp.MustCompile(`^(whatsnew-)?([a-zA-Z]{2,3}(?:[-_][0-9a-zA-Z]+)*)$`)
+
 // readLocalisedRecentChanges reads the recent changes from the given path and returns them as a map.
+// The files are named after their locale (a BCP-47 language tag), like whatsnew-en-US, whatsnew-en-US.txt, en-US.txt
+// or whatsnew-de, other files are ignored. Files without the whatsnew- prefix are read only if their language is
+// supported by Google Play, so files like old.txt or foo-bar.txt are not uploaded as release notes.
 func readLocalisedRecentChanges(recentChangesDir string) (map[string]string, error) {
 	recentChangesMap := map[string]string{}
 
-	pattern := filepath.Join(recentChangesDir, "whatsnew-*")
-	recentChangesPaths, err := filepath.Glob(pattern)
+	entries, err := ioutil.ReadDir(recentChangesDir)
 	if err != nil {
 		return map[string]string{}, err
 	}
 
 	// The language code (a BCP-47 language tag) of the localized listing to read or modify
 	// https://tools.ietf.org/html/bcp47#section-2.1
-	pattern = `whatsnew-(?P<locale>([0-9a-zA-Z].*(-|$))+)`
-	re := regexp.MustCompile(pattern)
-
-	for _, recentChangesPath := range recentChangesPaths {
-		matches := re.FindStringSubmatch(recentChangesPath)
-		if len(matches) >= 2 {
-			language := matches[1]
-			content, err := fileutil.ReadStringFromFile(recentChangesPath)
-			if err != nil {
-				return map[string]string{}, err
-			}
+	recentChangesPaths := map[string]string{}
+	for _, entry := range entries {
+		recentChangesPath := filepath.Join(recentChangesDir, entry.Name())
+		matches := recentChangesFileNamePattern.FindStringSubmatch(strings.TrimSuffix(entry.Name(), ".txt"))
+		if entry.IsDir() || len(matches) < 3 {
+			log.Debugf("Skipping %s, not a what's new file", recentChangesPath)
+			continue
+		}
 
-			recentChangesMap[language] = content
+		language := matches[2]
+		if matches[1] == "" && !isSupportedLanguage(language) {
+			log.Warnf("Skipping %s, %s is not a language supported by Google Play, prefix the file name with whatsnew- to use it as release notes", recentChangesPath, language)
+			continue
 		}
+		if pth, ok := recentChangesPaths[language]; ok {
+			return map[string]string{}, fmt.Errorf("multiple what's new files found for %s: %s, %s", language, pth, recentChangesPath)
+		}
+		content, err := readTextFile(recentChangesPath)
+		if err != nil {
+			return map[string]string{}, err
+		}
+
+		recentChangesPaths[language] = recentChangesPath
+		recentChangesMap[language] = content
 	}
 	if len(recentChangesMap) > 0 {
 		log.Debugf("Found the following recent changes:")
@@ -188,6 +538,84 @@ func readLocalisedRecentChanges(recentChangesDir string) (map[string]string, err
 	return recentChangesMap, nil
 }
 
+// readTextFile reads the given text file tolerantly: a UTF-8 byte order mark is removed, UTF-16 files (with a byte
+// order mark) are converted to UTF-8 and CRLF line endings are converted to LF. Returns an error if the content is not
+// valid UTF-8.
+func readTextFile(pth string) (string, error) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(content, []byte{0xEF, 0xBB, 0xBF}):
+		content = content[3:]
+	case bytes.HasPrefix(content, []byte{0xFF, 0xFE}), bytes.HasPrefix(content, []byte{0xFE, 0xFF}):
+		content = decodeUTF16(content)
+	}
+	if !utf8.Valid(content) {
+		return "", fmt.Errorf("%s is not a valid UTF-8 text file", pth)
+	}
+	return strings.Replace(string(content), "\r\n", "\n", -1), nil
+}
+
+// decodeUTF16 converts the given UTF-16 content, starting with a byte order mark, to UTF-8.
+func decodeUTF16(content []byte) []byte {
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if content[0] == 0xFE {
+		byteOrder = binary.BigEndian
+	}
+
+	chars := make([]uint16, 0, len(content)/2)
+	for i := 2; i+1 < len(content); i += 2 {
+		chars = append(chars, byteOrder.Uint16(content[i:]))
+	}
+	return []byte(string(utf16.Decode(chars)))
+}
+
+// resolveTrack returns the name of the given track as it is listed in the edit. Besides the built-in tracks (internal,
+// alpha, beta, production) custom closed testing tracks can be used, the name is matched case-insensitively.
+func resolveTrack(ctx context.Context, service *androidpublisher.Service, packageName, appEditID, track string) (string, error) {
+	tracksListResponse, err := androidpublisher.NewEditsTracksService(service).List(packageName, appEditID).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to list tracks, error: %s", err)
+	}
+	return matchTrack(tracksListResponse.Tracks, track)
+}
+
+// verifyTracks checks that all the given tracks exist for the app, so a mistyped track fails the step before anything
+// is uploaded.
+func verifyTracks(ctx context.Context, service *androidpublisher.Service, packageName, appEditID string, tracks []string) error {
+	tracksListResponse, err := androidpublisher.NewEditsTracksService(service).List(packageName, appEditID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list tracks, error: %s", err)
+	}
+	for _, track := range tracks {
+		if _, err := matchTrack(tracksListResponse.Tracks, track); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchTrack returns the name of the track matching the given name from the given tracks.
+func matchTrack(tracks []*androidpublisher.Track, name string) (string, error) {
+	var names []string
+	for _, track := range tracks {
+		if track.Track == name {
+			return track.Track, nil
+		}
+		names = append(names, track.Track)
+	}
+	for _, track := range tracks {
+		if strings.EqualFold(track.Track, name) {
+			log.Warnf("Track %s not found, using %s", name, track.Track)
+			return track.Track, nil
+		}
+	}
+	return "", fmt.Errorf("track %s not found, available tracks: %s", name, strings.Join(names, ", "))
+}
+
 // createTrackRelease returns a release object with the given version codes and adds the listing information.
 func createTrackRelease(config Configs, versionCodes googleapi.Int64s) (*androidpublisher.TrackRelease, error) {
 	newRelease := &androidpublisher.TrackRelease{
@@ -195,6 +623,16 @@ func createTrackRelease(config Configs, versionCodes googleapi.Int64s) (*android
 		Status:              config.Status,
 		InAppUpdatePriority: int64(config.UpdatePriority),
 	}
+	retainedVersionCodes, err := config.retainedVersionCodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, versionCode := range retainedVersionCodes {
+		if !containsVersionCode(newRelease.VersionCodes, versionCode) {
+			log.Printf("Retaining version code: %d", versionCode)
+			newRelease.VersionCodes = append(newRelease.VersionCodes, versionCode)
+		}
+	}
 	log.Infof("Release version codes are: %v", newRelease.VersionCodes)
 
 	if newRelease.Status == "" {
@@ -204,12 +642,30 @@ func createTrackRelease(config Configs, versionCodes googleapi.Int64s) (*android
 	if shouldApplyUserFraction(newRelease.Status) {
 		newRelease.UserFraction = config.UserFraction
 	}
+	if newRelease.Status == releaseStatusHalted {
+		log.Warnf("Release is created as a halted staged rollout, it won't reach any users until it is resumed.")
+	}
+	if config.PreRegistration && newRelease.Status == releaseStatusCompleted {
+		log.Infof("Release is shipped to a track with a pre-registration campaign, pre-registered users receive it when the app launches.")
+	}
 
 	if config.ReleaseName != "" {
 		newRelease.Name = config.ReleaseName
 	}
 
-	if err := updateListing(config.WhatsnewsDir, newRelease); err != nil {
+	if countries := config.releaseCountries(); len(countries) > 0 {
+		log.Infof("Release is targeted to countries: %s (rest of world included: %v)", strings.Join(countries, ", "), config.IncludeRestOfWorld)
+		newRelease.CountryTargeting = &androidpublisher.CountryTargeting{
+			Countries:          countries,
+			IncludeRestOfWorld: config.IncludeRestOfWorld,
+		}
+	}
+
+	var variables map[string]string
+	if config.hasReleaseNotes() {
+		variables = releaseNotesVariables(config, newRelease.VersionCodes)
+	}
+	if err := updateListing(config, variables, newRelease); err != nil {
 		return nil, fmt.Errorf("failed to update listing, reason: %v", err)
 	}
 
@@ -225,6 +681,16 @@ func releaseStatusFromConfig(userFraction float64) string {
 	return releaseStatusCompleted
 }
 
+// containsVersionCode returns true if the given version code is in the list.
+func containsVersionCode(versionCodes []int64, versionCode int64) bool {
+	for _, code := range versionCodes {
+		if code == versionCode {
+			return true
+		}
+	}
+	return false
+}
+
 func shouldApplyUserFraction(status string) bool {
 	return status == releaseStatusInProgress || status == releaseStatusHalted
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/androidpublisher/v3"
 )
 
 func Test_verifyStatusOfTheCreatedRelease(t *testing.T) {
@@ -74,6 +78,59 @@ func Test_verifyUserFractionOfTheCreatedRelease(t *testing.T) {
 	}
 }
 
+func Test_retainedVersionCodesOfTheCreatedRelease(t *testing.T) {
+	tests := []struct {
+		name                 string
+		config               Configs
+		expectedVersionCodes []int64
+		wantErr              bool
+	}{
+		{"no retained version codes", Configs{}, []int64{3}, false},
+		{"retained version codes", Configs{RetainVersionCodes: "1, 2"}, []int64{3, 1, 2}, false},
+		{"already uploaded version code", Configs{RetainVersionCodes: "1|3"}, []int64{3, 1}, false},
+		{"invalid version code", Configs{RetainVersionCodes: "1.0"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trackRelease, err := createTrackRelease(tt.config, []int64{3})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedVersionCodes, []int64(trackRelease.VersionCodes))
+		})
+	}
+}
+
+func Test_countryTargetingOfTheCreatedRelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Configs
+		expected *androidpublisher.CountryTargeting
+	}{
+		{"no countries", Configs{IncludeRestOfWorld: true}, nil},
+		{"countries", Configs{ReleaseCountries: "us, gb"}, &androidpublisher.CountryTargeting{Countries: []string{"US", "GB"}}},
+		{"countries with rest of world", Configs{ReleaseCountries: "US|DE", IncludeRestOfWorld: true}, &androidpublisher.CountryTargeting{Countries: []string{"US", "DE"}, IncludeRestOfWorld: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trackRelease, err := createTrackRelease(tt.config, []int64{3})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, trackRelease.CountryTargeting)
+		})
+	}
+}
+
+func Test_missingVersionCodes(t *testing.T) {
+	assert.Equal(t, []int64(nil), missingVersionCodes([]int64{1, 2, 3}, []int64{3, 1}))
+	assert.Equal(t, []int64{4}, missingVersionCodes([]int64{1, 2, 3}, []int64{3, 4}))
+	assert.Equal(t, []int64{1}, missingVersionCodes(nil, []int64{1}))
+}
+
 func Test_releaseStatusFromConfig(t *testing.T) {
 
 	tests := []struct {
@@ -123,6 +180,36 @@ func Test_expFileInfo(t *testing.T) {
 	}
 }
 
+func Test_expFileReferencedVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		expFilePth string
+		want       int64
+		wantIsRef  bool
+		wantErr    bool
+	}{
+		{"path", "/file/path/1.obb", 0, false, false},
+		{"reference", "@123", 123, true, false},
+		{"invalid reference", "@abc", 0, false, true},
+		{"zero reference", "@0", 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotIsRef, err := expFileReferencedVersion(tt.expFilePth)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expFileReferencedVersion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("expFileReferencedVersion() got = %v, want %v", got, tt.want)
+			}
+			if gotIsRef != tt.wantIsRef {
+				t.Errorf("expFileReferencedVersion() gotIsRef = %v, want %v", gotIsRef, tt.wantIsRef)
+			}
+		})
+	}
+}
+
 func Test_validateExpansionFilePath(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -143,15 +230,123 @@ func Test_validateExpansionFilePath(t *testing.T) {
 	}
 }
 
+func Test_updateListing(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_updateListing")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	whatsNewsDir := filepath.Join(tmpDir, "whatsnew")
+	assert.NoError(t, os.MkdirAll(whatsNewsDir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(whatsNewsDir, "whatsnew-en-US"), []byte("What's new"), 0644))
+	releaseNotesPath := filepath.Join(tmpDir, "CHANGELOG")
+	assert.NoError(t, ioutil.WriteFile(releaseNotesPath, []byte("Changelog"), 0644))
+
+	release := &androidpublisher.TrackRelease{}
+	assert.NoError(t, updateListing(Configs{ReleaseNotesPath: releaseNotesPath, ReleaseNotesLanguage: "de-DE"}, nil, release))
+	assert.Equal(t, []*androidpublisher.LocalizedText{{Language: "de-DE", Text: "Changelog"}}, release.ReleaseNotes)
+
+	release = &androidpublisher.TrackRelease{}
+	assert.NoError(t, updateListing(Configs{WhatsnewsDir: whatsNewsDir, ReleaseNotesPath: releaseNotesPath, ReleaseNotesLanguage: "en-US"}, nil, release))
+	assert.Equal(t, []*androidpublisher.LocalizedText{{Language: "en-US", Text: "Changelog"}}, release.ReleaseNotes)
+
+	flavorWhatsNewsDir := filepath.Join(tmpDir, "flavor")
+	assert.NoError(t, os.MkdirAll(flavorWhatsNewsDir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(flavorWhatsNewsDir, "whatsnew-en-US"), []byte("Flavor news"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(flavorWhatsNewsDir, "whatsnew-de-DE"), []byte("Neuigkeiten"), 0644))
+	release = &androidpublisher.TrackRelease{}
+	assert.NoError(t, updateListing(Configs{WhatsnewsDir: whatsNewsDir + "\n" + flavorWhatsNewsDir}, nil, release))
+	assert.Equal(t, []*androidpublisher.LocalizedText{{Language: "de-DE", Text: "Neuigkeiten"}, {Language: "en-US", Text: "Flavor news"}}, release.ReleaseNotes)
+
+	release = &androidpublisher.TrackRelease{}
+	assert.NoError(t, updateListing(Configs{ReleaseNotesJSON: `{"de-DE": "Neuigkeiten"}`}, nil, release))
+	assert.Equal(t, []*androidpublisher.LocalizedText{{Language: "de-DE", Text: "Neuigkeiten"}}, release.ReleaseNotes)
+
+	release = &androidpublisher.TrackRelease{}
+	assert.NoError(t, updateListing(Configs{WhatsnewsDir: whatsNewsDir, ReleaseNotesJSON: `{"en-US": "From JSON"}`}, nil, release))
+	assert.Equal(t, []*androidpublisher.LocalizedText{{Language: "en-US", Text: "From JSON"}}, release.ReleaseNotes)
+
+	release = &androidpublisher.TrackRelease{}
+	assert.NoError(t, updateListing(Configs{ReleaseNotesLanguage: "en-US"}, nil, release))
+	assert.Nil(t, release.ReleaseNotes)
+
+	assert.NoError(t, os.Setenv("BITRISE_GIT_MESSAGE", "Add dark mode\n"))
+	defer func() {
+		assert.NoError(t, os.Unsetenv("BITRISE_GIT_MESSAGE"))
+	}()
+	release = &androidpublisher.TrackRelease{}
+	assert.NoError(t, updateListing(Configs{ReleaseNotesLanguage: "en-US", ReleaseNotesFromGitMessage: true}, nil, release))
+	assert.Equal(t, []*androidpublisher.LocalizedText{{Language: "en-US", Text: "Add dark mode"}}, release.ReleaseNotes)
+
+	release = &androidpublisher.TrackRelease{}
+	assert.NoError(t, updateListing(Configs{ReleaseNotesPath: releaseNotesPath, ReleaseNotesLanguage: "en-US", ReleaseNotesFromGitMessage: true}, nil, release))
+	assert.Equal(t, []*androidpublisher.LocalizedText{{Language: "en-US", Text: "Changelog"}}, release.ReleaseNotes)
+}
+
+func Test_expandReleaseNotes(t *testing.T) {
+	assert.NoError(t, os.Setenv("RELEASE_NOTES_TEST_ENV", "from env"))
+	defer func() {
+		assert.NoError(t, os.Unsetenv("RELEASE_NOTES_TEST_ENV"))
+	}()
+
+	variables := map[string]string{"version_name": "1.2.0", "version_code": "42", "git_tag": "v1.2.0"}
+	tests := []struct {
+		name         string
+		releaseNotes string
+		want         string
+	}{
+		{"no placeholders", "Bug fixes", "Bug fixes"},
+		{"build variables", "Version {version_name} ({version_code}), tag: {git_tag}", "Version 1.2.0 (42), tag: v1.2.0"},
+		{"environment variable", "Changes: {env:RELEASE_NOTES_TEST_ENV}", "Changes: from env"},
+		{"unset environment variable", "Changes: {env:RELEASE_NOTES_TEST_UNSET_ENV}", "Changes: {env:RELEASE_NOTES_TEST_UNSET_ENV}"},
+		{"unknown placeholder", "Fixed {crash} in {version_name}", "Fixed {crash} in 1.2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, expandReleaseNotes(tt.releaseNotes, variables))
+		})
+	}
+
+	assert.Equal(t, "Version {version_name}", expandReleaseNotes("Version {version_name}", nil))
+}
+
+func Test_applyLocaleAliases(t *testing.T) {
+	aliases := map[string]string{"en": "en-US", "pt": "pt-BR", "de": "de-DE"}
+	releaseNotes := map[string]string{"en": "English", "pt": "Portuguese", "de": "German alias", "de-DE": "German", "hu-HU": "Hungarian"}
+	assert.Equal(t, map[string]string{"en-US": "English", "pt-BR": "Portuguese", "de-DE": "German", "hu-HU": "Hungarian"}, applyLocaleAliases(releaseNotes, aliases))
+	assert.Equal(t, releaseNotes, applyLocaleAliases(releaseNotes, nil))
+}
+
+func Test_limitReleaseNotes(t *testing.T) {
+	short := "Bug fixes"
+	got, err := limitReleaseNotes("en-US", short, false)
+	assert.NoError(t, err)
+	assert.Equal(t, short, got)
+
+	long := strings.Repeat("word ", 120)
+	_, err = limitReleaseNotes("en-US", long, false)
+	assert.Error(t, err)
+
+	got, err = limitReleaseNotes("en-US", long, true)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("word ", 98)+"word…", got)
+	assert.True(t, utf8.RuneCountInString(got) <= maxReleaseNotesLength)
+
+	got, err = limitReleaseNotes("hu-HU", strings.Repeat("á", 600), true)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("á", maxReleaseNotesLength-1)+"…", got)
+}
+
 func Test_readLocalisedRecentChanges(t *testing.T) {
-	createTestFiles := func(localeToNote map[string]string) (string, error) {
+	createTestFiles := func(fileNameToNote map[string]string) (string, error) {
 		tmpDir, err := ioutil.TempDir("", "Test_readLocalisedRecentChanges")
 		if err != nil {
 			return "", err
 		}
 
-		for locale, notes := range localeToNote {
-			if err := ioutil.WriteFile(filepath.Join(tmpDir, "whatsnew-"+locale), []byte(notes), 0600); err != nil {
+		for fileName, notes := range fileNameToNote {
+			if err := ioutil.WriteFile(filepath.Join(tmpDir, fileName), []byte(notes), 0600); err != nil {
 				return "", err
 			}
 		}
@@ -166,25 +361,25 @@ func Test_readLocalisedRecentChanges(t *testing.T) {
 	}{
 		{
 			name:      "1 language: en-US",
-			testFiles: map[string]string{"en-US": "English"},
+			testFiles: map[string]string{"whatsnew-en-US": "English"},
 			want:      map[string]string{"en-US": "English"},
 			wantErr:   false,
 		},
 		{
 			name:      "2 language: en-US",
-			testFiles: map[string]string{"en-US": "English", "de-DE": "German"},
+			testFiles: map[string]string{"whatsnew-en-US": "English", "whatsnew-de-DE": "German"},
 			want:      map[string]string{"en-US": "English", "de-DE": "German"},
 			wantErr:   false,
 		},
 		{
 			name:      "no second subtag",
-			testFiles: map[string]string{"ca": "Catalan"},
+			testFiles: map[string]string{"whatsnew-ca": "Catalan"},
 			want:      map[string]string{"ca": "Catalan"},
 			wantErr:   false,
 		},
 		{
 			name:      "Latin American Spanish",
-			testFiles: map[string]string{"es-419": "Latin American Spanish"},
+			testFiles: map[string]string{"whatsnew-es-419": "Latin American Spanish"},
 			want:      map[string]string{"es-419": "Latin American Spanish"},
 			wantErr:   false,
 		},
@@ -192,10 +387,40 @@ func Test_readLocalisedRecentChanges(t *testing.T) {
 			// "sr-Latn-RS" represents Serbian ('sr') written using Latin script
 			//('Latn') as used in Serbia ('RS').
 			name:      "Latin American Spanish",
-			testFiles: map[string]string{"sr-Latn-RS": "Serbian"},
+			testFiles: map[string]string{"whatsnew-sr-Latn-RS": "Serbian"},
 			want:      map[string]string{"sr-Latn-RS": "Serbian"},
 			wantErr:   false,
 		},
+		{
+			name:      "txt extension",
+			testFiles: map[string]string{"whatsnew-en-US.txt": "English", "de-DE.txt": "German"},
+			want:      map[string]string{"en-US": "English", "de-DE": "German"},
+			wantErr:   false,
+		},
+		{
+			name:      "two-letter locale",
+			testFiles: map[string]string{"whatsnew-de": "German", "fr.txt": "French"},
+			want:      map[string]string{"de": "German", "fr": "French"},
+			wantErr:   false,
+		},
+		{
+			name:      "other files",
+			testFiles: map[string]string{"whatsnew-en-US": "English", "README.md": "Readme", "notes.txt": "Notes"},
+			want:      map[string]string{"en-US": "English"},
+			wantErr:   false,
+		},
+		{
+			name:      "non-locale files without prefix",
+			testFiles: map[string]string{"whatsnew-en-US": "English", "old.txt": "Old", "tmp.txt": "Temp", "foo-bar.txt": "Foo"},
+			want:      map[string]string{"en-US": "English"},
+			wantErr:   false,
+		},
+		{
+			name:      "duplicated locale",
+			testFiles: map[string]string{"whatsnew-en-US": "English", "en-US.txt": "English"},
+			want:      map[string]string{},
+			wantErr:   true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -221,3 +446,65 @@ func Test_readLocalisedRecentChanges(t *testing.T) {
 		})
 	}
 }
+
+func Test_matchTrack(t *testing.T) {
+	tracks := []*androidpublisher.Track{{Track: "production"}, {Track: "beta"}, {Track: "alpha"}, {Track: "internal"}, {Track: "QA"}}
+
+	tests := []struct {
+		name    string
+		track   string
+		want    string
+		wantErr bool
+	}{
+		{"built-in track", "beta", "beta", false},
+		{"custom track", "QA", "QA", false},
+		{"custom track with different case", "qa", "QA", false},
+		{"missing track", "dogfood", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchTrack(tracks, tt.track)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_readTextFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_readTextFile")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+		wantErr bool
+	}{
+		{"plain", []byte("Bug fixes\n- crash"), "Bug fixes\n- crash", false},
+		{"UTF-8 BOM and CRLF", []byte("\xEF\xBB\xBFBug fixes\r\n- crash\r\n"), "Bug fixes\n- crash\n", false},
+		{"UTF-16 LE", []byte{0xFF, 0xFE, 'O', 0, 'k', 0, '\r', 0, '\n', 0, 0xE9, 0}, "Ok\né", false},
+		{"UTF-16 BE", []byte{0xFE, 0xFF, 0, 'O', 0, 'k'}, "Ok", false},
+		{"invalid UTF-8", []byte("Bug fixes \xE9"), "", true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(tmpDir, fmt.Sprintf("whatsnew-%d", i))
+			assert.NoError(t, ioutil.WriteFile(pth, tt.content, 0644))
+
+			got, err := readTextFile(pth)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
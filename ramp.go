@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+// rampStep is a step of a staged rollout ramp plan: the rollout is increased to the given user fraction once the given
+// time elapsed since the start of the rollout.
+type rampStep struct {
+	userFraction float64
+	after        time.Duration
+}
+
+// rampState is the persisted state of a staged rollout ramp between the step runs.
+type rampState struct {
+	PackageName  string  `json:"package_name"`
+	Track        string  `json:"track"`
+	VersionCodes []int64 `json:"version_codes"`
+	// StartedAt is when the step first saw the rollout in progress, not when the rollout was started: the API does
+	// not report the latter.
+	StartedAt time.Time `json:"started_at"`
+}
+
+// parseRampPlan parses a ramp plan like "5%:0h,20%:24h,50%:72h,100%:120h". User fractions can be given in percent or
+// as a fraction (0.05), the times are durations since the start of the rollout.
+func parseRampPlan(plan string) ([]rampStep, error) {
+	var steps []rampStep
+	for _, e := range strings.Split(plan, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+
+		parts := strings.Split(e, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid ramp plan step: %s, expected format: <user fraction>:<duration>, like 20%%:24h", e)
+		}
+
+		userFraction, err := parseUserFraction(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid user fraction in ramp plan step: %s, error: %s", e, err)
+		}
+		after, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration in ramp plan step: %s, error: %s", e, err)
+		}
+		steps = append(steps, rampStep{userFraction: userFraction, after: after})
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty ramp plan")
+	}
+
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].after < steps[j].after })
+	for i := 1; i < len(steps); i++ {
+		if steps[i].userFraction <= steps[i-1].userFraction {
+			return nil, fmt.Errorf("user fractions of the ramp plan should increase over time")
+		}
+	}
+	return steps, nil
+}
+
+// parseUserFraction parses a user fraction given in percent (20%) or as a fraction (0.2).
+func parseUserFraction(s string) (float64, error) {
+	userFraction, err := parseFraction(s)
+	if err != nil {
+		return 0, err
+	}
+	if userFraction <= 0 || userFraction > 1 {
+		return 0, fmt.Errorf("user fraction should be greater than 0 and at most 100%%: %s", strings.TrimSpace(s))
+	}
+	return userFraction, nil
+}
+
+// parseFraction parses a fraction given in percent (20%) or as a fraction (0.2).
+func parseFraction(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	divisor := 1.0
+	if strings.HasSuffix(s, "%") {
+		s = strings.TrimSuffix(s, "%")
+		divisor = 100
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	return value / divisor, nil
+}
+
+// currentRampStep returns the last step of the plan which is due after the given elapsed time, false if none of them.
+func currentRampStep(plan []rampStep, elapsed time.Duration) (rampStep, bool) {
+	var current rampStep
+	var found bool
+	for _, step := range plan {
+		if step.after <= elapsed {
+			current, found = step, true
+		}
+	}
+	return current, found
+}
+
+// readRampState reads the ramp state from the given file, returns nil if the file does not exist.
+func readRampState(pth string) (*rampState, error) {
+	content, err := ioutil.ReadFile(pth)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read ramp state (%s), error: %s", pth, err)
+	}
+
+	var state rampState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse ramp state (%s), error: %s", pth, err)
+	}
+	return &state, nil
+}
+
+// writeRampState writes the ramp state to the given file.
+func writeRampState(pth string, state rampState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize ramp state, error: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return fmt.Errorf("failed to create ramp state directory, error: %s", err)
+	}
+	if err := ioutil.WriteFile(pth, content, 0644); err != nil {
+		return fmt.Errorf("failed to write ramp state (%s), error: %s", pth, err)
+	}
+	return nil
+}
+
+// sameVersionCodes returns true if the given lists contain the same version codes.
+func sameVersionCodes(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, versionCode := range a {
+		if !containsVersionCode(b, versionCode) {
+			return false
+		}
+	}
+	return true
+}
+
+// rampRollout advances the staged rollout in progress on the track according to the ramp plan. The start of the
+// rollout is recorded in the ramp state file on the first run, later runs increase the user fraction to the one due
+// by the elapsed time and complete the rollout at the 100% step. Returns the ramped release, or nil if the track is not
+// changed, so there is nothing to commit.
+func rampRollout(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) (*androidpublisher.TrackRelease, error) {
+	plan, err := parseRampPlan(configs.RampPlan)
+	if err != nil {
+		return nil, err
+	}
+
+	track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, configs.track())
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := readRampState(configs.RampStatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	release := inProgressRelease(track)
+	if release == nil {
+		if live := liveRelease(track); live != nil && state != nil && sameVersionCodes(state.VersionCodes, live.VersionCodes) {
+			log.Printf("Release %s (version codes: %v) is already completed", live.Name, live.VersionCodes)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s track has no staged rollout in progress", track.Track)
+	}
+	if state == nil || state.PackageName != configs.PackageName || state.Track != track.Track || !sameVersionCodes(state.VersionCodes, release.VersionCodes) {
+		log.Printf("Starting ramp of release %s (version codes: %v) on %s track", release.Name, release.VersionCodes, track.Track)
+		state = &rampState{PackageName: configs.PackageName, Track: track.Track, VersionCodes: release.VersionCodes, StartedAt: time.Now()}
+		if err := writeRampState(configs.RampStatePath, *state); err != nil {
+			return nil, err
+		}
+	}
+
+	elapsed := time.Since(state.StartedAt)
+	log.Printf("Ramp of release %s started at %s (%s ago), current user fraction: %v", release.Name, state.StartedAt.Format(time.RFC3339), elapsed.Round(time.Minute), release.UserFraction)
+
+	step, ok := currentRampStep(plan, elapsed)
+	if !ok || step.userFraction <= release.UserFraction {
+		log.Printf("No ramp step due, keeping the user fraction")
+		return nil, nil
+	}
+	if err := checkReleaseVitals(ctx, configs, release); err != nil {
+		return nil, err
+	}
+
+	if step.userFraction >= 1 {
+		log.Printf("Completing release %s", release.Name)
+		track.Releases = completedRolloutReleases(track.Releases, release)
+	} else {
+		log.Printf("Increasing the user fraction of release %s: %v -> %v", release.Name, release.UserFraction, step.userFraction)
+		release.UserFraction = step.userFraction
+	}
+	if err := updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, track); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRampPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    string
+		want    []rampStep
+		wantErr bool
+	}{
+		{
+			name: "percent",
+			plan: "5%:0h,20%:24h,50%:72h,100%:120h",
+			want: []rampStep{{0.05, 0}, {0.2, 24 * time.Hour}, {0.5, 72 * time.Hour}, {1, 120 * time.Hour}},
+		},
+		{
+			name: "fraction, unordered",
+			plan: " 0.5:48h, 0.1:0h ",
+			want: []rampStep{{0.1, 0}, {0.5, 48 * time.Hour}},
+		},
+		{name: "decreasing fraction", plan: "50%:0h,20%:24h", wantErr: true},
+		{name: "invalid fraction", plan: "150%:0h", wantErr: true},
+		{name: "invalid duration", plan: "5%:1d", wantErr: true},
+		{name: "invalid format", plan: "5%", wantErr: true},
+		{name: "empty", plan: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRampPlan(tt.plan)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_currentRampStep(t *testing.T) {
+	plan := []rampStep{{0.05, 0}, {0.2, 24 * time.Hour}, {1, 120 * time.Hour}}
+
+	step, ok := currentRampStep(plan, time.Hour)
+	require.True(t, ok)
+	require.Equal(t, 0.05, step.userFraction)
+
+	step, ok = currentRampStep(plan, 25*time.Hour)
+	require.True(t, ok)
+	require.Equal(t, 0.2, step.userFraction)
+
+	step, ok = currentRampStep(plan, 200*time.Hour)
+	require.True(t, ok)
+	require.Equal(t, 1.0, step.userFraction)
+
+	_, ok = currentRampStep([]rampStep{{0.2, 24 * time.Hour}}, time.Hour)
+	require.False(t, ok)
+}
+
+func Test_rampState(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_rampState")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	pth := filepath.Join(tmpDir, "state", "ramp.json")
+	state, err := readRampState(pth)
+	require.NoError(t, err)
+	require.Nil(t, state)
+
+	want := rampState{PackageName: "io.bitrise.sample", Track: "production", VersionCodes: []int64{1, 2}, StartedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	require.NoError(t, writeRampState(pth, want))
+
+	state, err = readRampState(pth)
+	require.NoError(t, err)
+	require.Equal(t, want, *state)
+}
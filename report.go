@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+const tracksReportPathEnvKey = "GOOGLE_PLAY_TRACKS_REPORT_PATH"
+
+// trackReport is the state of a track in the tracks report.
+type trackReport struct {
+	Track    string          `json:"track"`
+	Releases []releaseReport `json:"releases"`
+}
+
+// releaseReport is the state of a release in the tracks report.
+type releaseReport struct {
+	Name         string            `json:"name"`
+	Status       string            `json:"status"`
+	UserFraction float64           `json:"user_fraction,omitempty"`
+	VersionCodes []int64           `json:"version_codes"`
+	ReleaseNotes map[string]string `json:"release_notes,omitempty"`
+}
+
+// tracksReport returns the report of the given tracks and their releases.
+func tracksReport(tracks []*androidpublisher.Track) []trackReport {
+	report := []trackReport{}
+	for _, track := range tracks {
+		trackReport := trackReport{Track: track.Track, Releases: []releaseReport{}}
+		for _, release := range track.Releases {
+			releaseReport := releaseReport{
+				Name:         release.Name,
+				Status:       release.Status,
+				UserFraction: release.UserFraction,
+				VersionCodes: release.VersionCodes,
+			}
+			if len(release.ReleaseNotes) > 0 {
+				releaseReport.ReleaseNotes = map[string]string{}
+				for _, text := range release.ReleaseNotes {
+					releaseReport.ReleaseNotes[text.Language] = text.Text
+				}
+			}
+			trackReport.Releases = append(trackReport.Releases, releaseReport)
+		}
+		report = append(report, trackReport)
+	}
+	return report
+}
+
+// listTracks prints every track of the app with its releases, writes the report to the tracks_report_path as JSON
+// and exports its path.
+func listTracks(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) error {
+	tracksListResponse, err := androidpublisher.NewEditsTracksService(service).List(configs.PackageName, appEdit.Id).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list tracks, error: %s", err)
+	}
+
+	report := tracksReport(tracksListResponse.Tracks)
+	for _, track := range report {
+		log.Printf("%s track:", track.Track)
+		if len(track.Releases) == 0 {
+			log.Printf("- no releases")
+		}
+		for _, release := range track.Releases {
+			log.Printf("- release %s: %s, user fraction: %v, version codes: %v", release.Name, release.Status, release.UserFraction, release.VersionCodes)
+		}
+	}
+
+	if err := writeJSONFile(configs.TracksReportPath, report); err != nil {
+		return fmt.Errorf("failed to write tracks report, error: %s", err)
+	}
+	log.Printf("Tracks report written to: %s", configs.TracksReportPath)
+	return exportEnvironment(tracksReportPathEnvKey, configs.TracksReportPath)
+}
+
+// writeJSONFile writes the given value to the given path as indented JSON, creating its directory if needed.
+func writeJSONFile(pth string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s, error: %s", pth, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return fmt.Errorf("failed to create directory of %s, error: %s", pth, err)
+	}
+	if err := ioutil.WriteFile(pth, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s, error: %s", pth, err)
+	}
+	return nil
+}
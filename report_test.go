@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+func Test_tracksReport(t *testing.T) {
+	tracks := []*androidpublisher.Track{
+		{Track: "production", Releases: []*androidpublisher.TrackRelease{
+			{Name: "1.0", Status: releaseStatusCompleted, VersionCodes: []int64{1}},
+			{Name: "1.1", Status: releaseStatusInProgress, UserFraction: 0.2, VersionCodes: []int64{2, 3}, ReleaseNotes: []*androidpublisher.LocalizedText{{Language: "en-US", Text: "Bug fixes"}}},
+		}},
+		{Track: "beta"},
+	}
+
+	content, err := json.Marshal(tracksReport(tracks))
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"track": "production", "releases": [
+			{"name": "1.0", "status": "completed", "version_codes": [1]},
+			{"name": "1.1", "status": "inProgress", "user_fraction": 0.2, "version_codes": [2, 3], "release_notes": {"en-US": "Bug fixes"}}
+		]},
+		{"track": "beta", "releases": []}
+	]`, string(content))
+
+	content, err = json.Marshal(tracksReport(nil))
+	require.NoError(t, err)
+	require.Equal(t, "[]", string(content))
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	apkSigningBlockMagic = "APK Sig Block 42"
+	eocdSignature        = 0x06054b50
+	eocdMinSize          = 22
+	eocdMaxCommentSize   = 0xffff
+
+	apkSignatureSchemeV2BlockID = 0x7109871a
+	apkSignatureSchemeV3BlockID = 0xf05368c0
+)
+
+// isAppSigned returns true if the given .apk or .aab file has a JAR (v1) signature or an APK Signing Block (v2+).
+func isAppSigned(pth string) (bool, error) {
+	signatureFiles, err := jarSignatureFiles(pth)
+	if err != nil {
+		return false, err
+	}
+	if len(signatureFiles) > 0 {
+		return true, nil
+	}
+
+	block, err := readAPKSigningBlock(pth)
+	if err != nil {
+		return false, err
+	}
+	return block != nil, nil
+}
+
+// jarSignatureFiles returns the names of the signature block files (META-INF/*.RSA, *.DSA or *.EC) of the given zip.
+func jarSignatureFiles(pth string) ([]string, error) {
+	r, err := zip.OpenReader(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open app (%s) as zip, error: %s", pth, err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Warnf("failed to close (%s)", pth)
+		}
+	}()
+
+	var names []string
+	for _, f := range r.File {
+		if path.Dir(f.Name) != "META-INF" {
+			continue
+		}
+		switch strings.ToUpper(path.Ext(f.Name)) {
+		case ".RSA", ".DSA", ".EC":
+			names = append(names, f.Name)
+		}
+	}
+	return names, nil
+}
+
+// readAPKSigningBlock returns the APK Signing Block of the given zip, which is stored right before the central
+// directory. Returns nil if the zip does not have a signing block.
+func readAPKSigningBlock(pth string) ([]byte, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open app (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("failed to close (%s)", pth)
+		}
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read app (%s) info, error: %s", pth, err)
+	}
+
+	tailSize := int64(eocdMinSize + eocdMaxCommentSize)
+	if tailSize > info.Size() {
+		tailSize = info.Size()
+	}
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, info.Size()-tailSize); err != nil {
+		return nil, fmt.Errorf("failed to read app (%s), error: %s", pth, err)
+	}
+
+	eocd := -1
+	for i := len(tail) - eocdMinSize; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == eocdSignature {
+			eocd = i
+			break
+		}
+	}
+	if eocd < 0 {
+		return nil, errors.New("invalid zip: end of central directory not found")
+	}
+	centralDirOffset := int64(binary.LittleEndian.Uint32(tail[eocd+16:]))
+
+	// block size (8 bytes), ..., block size (8 bytes), magic (16 bytes)
+	if centralDirOffset < 32 {
+		return nil, nil
+	}
+	footer := make([]byte, 24)
+	if _, err := f.ReadAt(footer, centralDirOffset-24); err != nil {
+		return nil, fmt.Errorf("failed to read app (%s), error: %s", pth, err)
+	}
+	if !bytes.Equal(footer[8:], []byte(apkSigningBlockMagic)) {
+		return nil, nil
+	}
+
+	blockSize := int64(binary.LittleEndian.Uint64(footer))
+	blockStart := centralDirOffset - blockSize - 8
+	if blockSize < 24 || blockStart < 0 {
+		return nil, errors.New("invalid APK Signing Block size")
+	}
+	block := make([]byte, blockSize+8)
+	if _, err := f.ReadAt(block, blockStart); err != nil {
+		return nil, fmt.Errorf("failed to read APK Signing Block of app (%s), error: %s", pth, err)
+	}
+	return block, nil
+}
+
+// normalizeCertificateFingerprint returns the given hex encoded fingerprint in lower case, without `:` separators.
+func normalizeCertificateFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(fingerprint), ":", "", -1))
+}
+
+// validateSigningCertificates checks if every given app is signed with a certificate whose SHA-256 fingerprint is one
+// of the expected fingerprints.
+func validateSigningCertificates(appPaths []string, expectedFingerprints []string) error {
+	expected := map[string]bool{}
+	for _, fingerprint := range expectedFingerprints {
+		expected[normalizeCertificateFingerprint(fingerprint)] = true
+	}
+
+	for _, pth := range appPaths {
+		fingerprints, err := signingCertificateFingerprints(pth)
+		if err != nil {
+			return fmt.Errorf("failed to read the signing certificate of app (%s), error: %s", pth, err)
+		}
+		if len(fingerprints) == 0 {
+			return fmt.Errorf("app (%s) is not signed", pth)
+		}
+
+		matching := false
+		for _, fingerprint := range fingerprints {
+			if expected[fingerprint] {
+				matching = true
+				break
+			}
+		}
+		if !matching {
+			return fmt.Errorf("app (%s) is signed with an unexpected certificate, SHA-256 fingerprint: %s, expected: %s", pth, strings.Join(fingerprints, ", "), strings.Join(expectedFingerprints, ", "))
+		}
+		log.Printf(" signing certificate of %s matches", pth)
+	}
+	return nil
+}
+
+// signingCertificateFingerprints returns the hex encoded SHA-256 fingerprint of the signer certificates of the given
+// app, read from its APK Signing Block (v2, v3) and JAR signature (v1).
+func signingCertificateFingerprints(pth string) ([]string, error) {
+	var certificates [][]byte
+
+	block, err := readAPKSigningBlock(pth)
+	if err != nil {
+		return nil, err
+	}
+	if block != nil {
+		blockCertificates, err := apkSigningBlockCertificates(block)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, blockCertificates...)
+	}
+
+	jarCertificates, err := jarSignatureCertificates(pth)
+	if err != nil {
+		return nil, err
+	}
+	certificates = append(certificates, jarCertificates...)
+
+	var fingerprints []string
+	seen := map[string]bool{}
+	for _, cert := range certificates {
+		sum := sha256.Sum256(cert)
+		fingerprint := hex.EncodeToString(sum[:])
+		if !seen[fingerprint] {
+			seen[fingerprint] = true
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	return fingerprints, nil
+}
+
+// jarSignatureCertificates returns the DER encoded certificates of the PKCS #7 signature block files of the given zip.
+func jarSignatureCertificates(pth string) ([][]byte, error) {
+	names, err := jarSignatureFiles(pth)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	r, err := zip.OpenReader(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open app (%s) as zip, error: %s", pth, err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Warnf("failed to close (%s)", pth)
+		}
+	}()
+
+	var certificates [][]byte
+	for _, f := range r.File {
+		if !sliceContains(names, f.Name) {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s, error: %s", f.Name, err)
+		}
+		content, err := ioutil.ReadAll(rc)
+		if cerr := rc.Close(); cerr != nil {
+			log.Warnf("failed to close %s", f.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s, error: %s", f.Name, err)
+		}
+
+		pkcs7Certificates, err := pkcs7Certificates(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s, error: %s", f.Name, err)
+		}
+		certificates = append(certificates, pkcs7Certificates...)
+	}
+	return certificates, nil
+}
+
+// pkcs7ContentInfo is the ContentInfo structure of a PKCS #7 message.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// pkcs7SignedData is the SignedData structure of a PKCS #7 message.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// pkcs7Certificates returns the DER encoded certificates of a PKCS #7 SignedData message.
+func pkcs7Certificates(content []byte) ([][]byte, error) {
+	var contentInfo pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(content, &contentInfo); err != nil {
+		return nil, err
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, err
+	}
+
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var raw [][]byte
+	for _, cert := range certificates {
+		raw = append(raw, cert.Raw)
+	}
+	return raw, nil
+}
+
+// apkSigningBlockCertificates returns the DER encoded signer certificates of the v2 and v3 signature scheme blocks of
+// the given APK Signing Block.
+func apkSigningBlockCertificates(block []byte) ([][]byte, error) {
+	// size (8 bytes), ID-value pairs, size (8 bytes), magic (16 bytes)
+	if len(block) < 32 {
+		return nil, errors.New("invalid APK Signing Block")
+	}
+	pairs := block[8 : len(block)-24]
+
+	var certificates [][]byte
+	for len(pairs) > 0 {
+		if len(pairs) < 12 {
+			return nil, errors.New("invalid APK Signing Block: malformed ID-value pair")
+		}
+		pairLength := binary.LittleEndian.Uint64(pairs)
+		if pairLength < 4 || pairLength > uint64(len(pairs)-8) {
+			return nil, errors.New("invalid APK Signing Block: malformed ID-value pair")
+		}
+		id := binary.LittleEndian.Uint32(pairs[8:])
+		value := pairs[12 : 8+pairLength]
+		pairs = pairs[8+pairLength:]
+
+		if id != apkSignatureSchemeV2BlockID && id != apkSignatureSchemeV3BlockID {
+			continue
+		}
+		signerCertificates, err := apkSignatureSchemeCertificates(value)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, signerCertificates...)
+	}
+	return certificates, nil
+}
+
+// apkSignatureSchemeCertificates returns the first (signer) certificate of every signer of a v2 or v3 signature scheme
+// block.
+func apkSignatureSchemeCertificates(value []byte) ([][]byte, error) {
+	// length-prefixed sequence of length-prefixed signers
+	signers, _, err := lengthPrefixed(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates [][]byte
+	for len(signers) > 0 {
+		var signer []byte
+		signer, signers, err = lengthPrefixed(signers)
+		if err != nil {
+			return nil, err
+		}
+
+		// signer: length-prefixed signed data, ...
+		signedData, _, err := lengthPrefixed(signer)
+		if err != nil {
+			return nil, err
+		}
+		// signed data: length-prefixed digests, length-prefixed certificates, ...
+		_, rest, err := lengthPrefixed(signedData)
+		if err != nil {
+			return nil, err
+		}
+		signerCertificates, _, err := lengthPrefixed(rest)
+		if err != nil {
+			return nil, err
+		}
+		cert, _, err := lengthPrefixed(signerCertificates)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, cert)
+	}
+	return certificates, nil
+}
+
+// lengthPrefixed returns the uint32 length-prefixed value at the beginning of b and the remaining bytes.
+func lengthPrefixed(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, errors.New("invalid APK Signing Block: malformed length-prefixed value")
+	}
+	length := binary.LittleEndian.Uint32(b)
+	if uint64(length) > uint64(len(b)-4) {
+		return nil, nil, errors.New("invalid APK Signing Block: malformed length-prefixed value")
+	}
+	return b[4 : 4+length], b[4+length:], nil
+}
+
+func sliceContains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
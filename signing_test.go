@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// insertTestAPKSigningBlock inserts an APK Signing Block with the given ID-value pairs before the central directory of
+// the given zip file.
+func insertTestAPKSigningBlock(t *testing.T, pth string, pairs map[uint32][]byte) {
+	content, err := ioutil.ReadFile(pth)
+	require.NoError(t, err)
+
+	eocd := bytes.LastIndex(content, []byte{0x50, 0x4b, 0x05, 0x06})
+	require.True(t, eocd >= 0)
+	centralDirOffset := binary.LittleEndian.Uint32(content[eocd+16:])
+
+	var pairsData bytes.Buffer
+	for id, value := range pairs {
+		require.NoError(t, binary.Write(&pairsData, binary.LittleEndian, uint64(4+len(value))))
+		require.NoError(t, binary.Write(&pairsData, binary.LittleEndian, id))
+		pairsData.Write(value)
+	}
+	blockSize := uint64(pairsData.Len() + 8 + 16)
+
+	var block bytes.Buffer
+	require.NoError(t, binary.Write(&block, binary.LittleEndian, blockSize))
+	block.Write(pairsData.Bytes())
+	require.NoError(t, binary.Write(&block, binary.LittleEndian, blockSize))
+	block.WriteString(apkSigningBlockMagic)
+
+	var signed bytes.Buffer
+	signed.Write(content[:centralDirOffset])
+	signed.Write(block.Bytes())
+	signed.Write(content[centralDirOffset:])
+	signedContent := signed.Bytes()
+	binary.LittleEndian.PutUint32(signedContent[eocd+block.Len()+16:], centralDirOffset+uint32(block.Len()))
+
+	require.NoError(t, ioutil.WriteFile(pth, signedContent, 0600))
+}
+
+func Test_isAppSigned(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_isAppSigned")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	manifest := newTestManifest("io.bitrise.sample", 1)
+
+	t.Log("isAppSigned - unsigned")
+	{
+		pth := filepath.Join(tmpDir, "unsigned.apk")
+		createTestApp(t, pth, manifest, nil)
+
+		signed, err := isAppSigned(pth)
+		require.NoError(t, err)
+		require.False(t, signed)
+	}
+
+	t.Log("isAppSigned - JAR signature")
+	{
+		pth := filepath.Join(tmpDir, "v1.aab")
+		createTestApp(t, pth, manifest, map[string][]byte{"META-INF/KEY0.RSA": []byte("signature")})
+
+		signed, err := isAppSigned(pth)
+		require.NoError(t, err)
+		require.True(t, signed)
+	}
+
+	t.Log("isAppSigned - APK Signing Block")
+	{
+		pth := filepath.Join(tmpDir, "v2.apk")
+		createTestApp(t, pth, manifest, nil)
+		insertTestAPKSigningBlock(t, pth, map[uint32][]byte{0x7109871a: []byte("signature")})
+
+		signed, err := isAppSigned(pth)
+		require.NoError(t, err)
+		require.True(t, signed)
+
+		manifest, err := readAppManifest(pth)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), manifest.VersionCode)
+	}
+}
+
+func Test_validateReleaseBuilds(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_validateReleaseBuilds")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	signature := map[string][]byte{"META-INF/CERT.RSA": []byte("signature")}
+	debuggable := func(packageName string, versionCode uint32) testManifest {
+		manifest := newTestManifest(packageName, versionCode)
+		manifest.applicationAttributes = append(manifest.applicationAttributes, testXMLAttribute{name: "debuggable", resID: debuggableAttrResID, dataType: resValueTypeBoolean, data: 0xffffffff})
+		return manifest
+	}
+
+	release := filepath.Join(tmpDir, "release.apk")
+	createTestApp(t, release, newTestManifest("io.bitrise.sample", 1), signature)
+	releaseBundle := filepath.Join(tmpDir, "release.aab")
+	createTestApp(t, releaseBundle, newTestManifest("io.bitrise.sample", 2), signature)
+	unsigned := filepath.Join(tmpDir, "unsigned.aab")
+	createTestApp(t, unsigned, newTestManifest("io.bitrise.sample", 3), nil)
+	debugApk := filepath.Join(tmpDir, "debug.apk")
+	createTestApp(t, debugApk, debuggable("io.bitrise.sample", 4), signature)
+	debugBundle := filepath.Join(tmpDir, "debug.aab")
+	createTestApp(t, debugBundle, debuggable("io.bitrise.sample", 5), signature)
+
+	require.NoError(t, validateReleaseBuilds([]string{release, releaseBundle}))
+	require.Error(t, validateReleaseBuilds([]string{release, unsigned}))
+	require.Error(t, validateReleaseBuilds([]string{debugApk}))
+	require.Error(t, validateReleaseBuilds([]string{debugBundle}))
+}
+
+// createTestCertificate returns a self-signed DER encoded certificate and its SHA-256 fingerprint.
+func createTestCertificate(t *testing.T, commonName string) ([]byte, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(cert)
+	return cert, hex.EncodeToString(sum[:])
+}
+
+// testPKCS7SignedData returns a PKCS #7 SignedData message holding the given certificate.
+func testPKCS7SignedData(t *testing.T, cert []byte) []byte {
+	emptySet := asn1.RawValue{Tag: asn1.TagSet, IsCompound: true}
+	innerContentInfo, err := asn1.Marshal(struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})
+	require.NoError(t, err)
+
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      asn1.RawValue
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      asn1.RawValue{FullBytes: innerContentInfo},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert},
+		SignerInfos:      emptySet,
+	})
+	require.NoError(t, err)
+
+	contentInfo, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	require.NoError(t, err)
+	return contentInfo
+}
+
+// testAPKSignatureSchemeBlock returns a v2 signature scheme block with a single signer having the given certificate.
+func testAPKSignatureSchemeBlock(cert []byte) []byte {
+	lp := func(values ...[]byte) []byte {
+		var b bytes.Buffer
+		for _, v := range values {
+			_ = binary.Write(&b, binary.LittleEndian, uint32(len(v)))
+			b.Write(v)
+		}
+		return b.Bytes()
+	}
+
+	signedData := append(append(lp([]byte{}), lp(lp(cert))...), lp([]byte{})...)
+	signer := append(append(lp(signedData), lp([]byte{})...), lp([]byte{})...)
+	return lp(lp(signer))
+}
+
+func Test_signingCertificateFingerprints(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_signingCertificateFingerprints")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	cert, fingerprint := createTestCertificate(t, "upload")
+	otherCert, otherFingerprint := createTestCertificate(t, "other")
+	manifest := newTestManifest("io.bitrise.sample", 1)
+
+	jarSigned := filepath.Join(tmpDir, "v1.aab")
+	createTestApp(t, jarSigned, manifest, map[string][]byte{"META-INF/UPLOAD.RSA": testPKCS7SignedData(t, cert)})
+
+	blockSigned := filepath.Join(tmpDir, "v2.apk")
+	createTestApp(t, blockSigned, manifest, nil)
+	insertTestAPKSigningBlock(t, blockSigned, map[uint32][]byte{apkSignatureSchemeV2BlockID: testAPKSignatureSchemeBlock(cert)})
+
+	otherSigned := filepath.Join(tmpDir, "other.apk")
+	createTestApp(t, otherSigned, manifest, map[string][]byte{"META-INF/CERT.RSA": testPKCS7SignedData(t, otherCert)})
+
+	unsigned := filepath.Join(tmpDir, "unsigned.apk")
+	createTestApp(t, unsigned, manifest, nil)
+
+	t.Log("signingCertificateFingerprints")
+	{
+		fingerprints, err := signingCertificateFingerprints(jarSigned)
+		require.NoError(t, err)
+		require.Equal(t, []string{fingerprint}, fingerprints)
+
+		fingerprints, err = signingCertificateFingerprints(blockSigned)
+		require.NoError(t, err)
+		require.Equal(t, []string{fingerprint}, fingerprints)
+
+		fingerprints, err = signingCertificateFingerprints(unsigned)
+		require.NoError(t, err)
+		require.Empty(t, fingerprints)
+	}
+
+	t.Log("validateSigningCertificates")
+	{
+		var colonSeparated []string
+		for i := 0; i < len(fingerprint); i += 2 {
+			colonSeparated = append(colonSeparated, strings.ToUpper(fingerprint[i:i+2]))
+		}
+
+		require.NoError(t, validateSigningCertificates([]string{jarSigned, blockSigned}, []string{fingerprint}))
+		require.NoError(t, validateSigningCertificates([]string{jarSigned}, []string{strings.Join(colonSeparated, ":")}))
+		require.NoError(t, validateSigningCertificates([]string{jarSigned, otherSigned}, []string{fingerprint, otherFingerprint}))
+		require.Error(t, validateSigningCertificates([]string{jarSigned, otherSigned}, []string{fingerprint}))
+		require.Error(t, validateSigningCertificates([]string{unsigned}, []string{fingerprint}))
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+const (
+	releaseStateEnvKey  = "GOOGLE_PLAY_RELEASE_STATE"
+	releaseNameEnvKey   = "GOOGLE_PLAY_RELEASE_NAME"
+	releaseStatusEnvKey = "GOOGLE_PLAY_RELEASE_STATUS"
+	trackEnvKey         = "GOOGLE_PLAY_TRACK"
+	userFractionEnvKey  = "GOOGLE_PLAY_USER_FRACTION"
+	versionCodesEnvKey  = "GOOGLE_PLAY_TRACK_VERSION_CODES"
+	trackReleasesEnvKey = "GOOGLE_PLAY_TRACK_RELEASES"
+
+	releaseStateAssigned    = "assigned"
+	releaseStateNotAssigned = "not_assigned"
+
+	releaseStatePollInterval = 30 * time.Second
+)
+
+// exportEnvironment exports the given environment variable with envman, so the next steps can use it.
+func exportEnvironment(key, value string) error {
+	if out, err := exec.Command("envman", "add", "--key", key, "--value", value).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to export %s, error: %s, output: %s", key, err, out)
+	}
+	return nil
+}
+
+// envVar is an environment variable to export.
+type envVar struct {
+	key, value string
+}
+
+// exportEnvironments exports the given environment variables in order, and stops at the first failure.
+func exportEnvironments(envs []envVar) error {
+	for _, env := range envs {
+		if err := exportEnvironment(env.key, env.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackReleaseState returns the state of the release of the given version codes on the track: assigned if the track
+// has the release as completed or in progress, draft or halted if the release has that status, not_assigned if the
+// track does not have the release yet. The API does not report the review outcome, so a rejected release is
+// not_assigned too.
+func trackReleaseState(track *androidpublisher.Track, versionCodes []int64) string {
+	release := releaseOfVersionCodes(track, versionCodes)
+	if release == nil {
+		return releaseStateNotAssigned
+	}
+	if release.Status == releaseStatusCompleted || release.Status == releaseStatusInProgress {
+		return releaseStateAssigned
+	}
+	return release.Status
+}
+
+// releaseOfVersionCodes returns the release of the track with all of the given version codes, or nil if there is none.
+func releaseOfVersionCodes(track *androidpublisher.Track, versionCodes []int64) *androidpublisher.TrackRelease {
+	for _, release := range track.Releases {
+		if containsVersionCodes(release.VersionCodes, versionCodes) {
+			return release
+		}
+	}
+	return nil
+}
+
+// containsVersionCodes returns true if all of the given version codes are in the list.
+func containsVersionCodes(list []int64, versionCodes []int64) bool {
+	for _, versionCode := range versionCodes {
+		if !containsVersionCode(list, versionCode) {
+			return false
+		}
+	}
+	return len(versionCodes) > 0
+}
+
+// releaseState returns the state of the release of the given version codes in a new edit, it is assigned only if all
+// of the tracks have it assigned.
+func releaseState(ctx context.Context, service *androidpublisher.Service, configs Configs, versionCodes []int64) (string, error) {
+	appEdit, err := androidpublisher.NewEditsService(service).Insert(configs.PackageName, &androidpublisher.AppEdit{}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to perform edit insert call, error: %s", err)
+	}
+	defer func() {
+		if err := androidpublisher.NewEditsService(service).Delete(configs.PackageName, appEdit.Id).Context(ctx).Do(); err != nil {
+			log.Debugf("Failed to delete edit (%s), error: %s", appEdit.Id, err)
+		}
+	}()
+
+	state := releaseStateAssigned
+	for _, trackName := range configs.tracks() {
+		track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, trackName)
+		if err != nil {
+			return "", err
+		}
+		trackState := trackReleaseState(track, versionCodes)
+		log.Printf("%s track: %s", track.Track, trackState)
+		if trackState != releaseStateAssigned {
+			state = trackState
+		}
+	}
+	return state, nil
+}
+
+// pollReleaseState checks the state of the committed release until it is assigned or the timeout of the
+// release_state_timeout input elapses, and exports the last state.
+func pollReleaseState(ctx context.Context, service *androidpublisher.Service, configs Configs) error {
+	apps, err := configs.packageAppPaths()
+	if err != nil {
+		return err
+	}
+	versionCodes, err := manifestVersionCodes(apps)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(time.Duration(configs.ReleaseStateTimeout) * time.Second)
+	for {
+		state, err := releaseState(ctx, service, configs, versionCodes)
+		if err != nil {
+			return err
+		}
+		if state == releaseStateAssigned || !time.Now().Add(releaseStatePollInterval).Before(deadline) {
+			log.Printf("Release state: %s", state)
+			return exportEnvironment(releaseStateEnvKey, state)
+		}
+		log.Printf("Release state: %s, checking again in %s", state, releaseStatePollInterval)
+		if err := sleepContext(ctx, releaseStatePollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// exportCommittedRelease reads the release of the apps (of the given version codes for the operations other than
+// deploy) on the tracks in a new edit. For the first track, it exports the name of the release, which Google Play sets
+// if the release_name input is empty, its status, the name of the track as Google Play resolved it and the user
+// fraction of the release if it is a staged rollout, and every version code the track serves. The status and the user
+// fraction of the release on every track are exported in a list.
+func exportCommittedRelease(ctx context.Context, service *androidpublisher.Service, configs Configs, versionCodes []int64) error {
+	trackNames := []string{configs.track()}
+	if configs.isDeploy() {
+		trackNames = configs.tracks()
+		apps, err := configs.packageAppPaths()
+		if err != nil {
+			return err
+		}
+		if versionCodes, err = manifestVersionCodes(apps); err != nil {
+			return err
+		}
+	}
+
+	appEdit, err := androidpublisher.NewEditsService(service).Insert(configs.PackageName, &androidpublisher.AppEdit{}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to perform edit insert call, error: %s", err)
+	}
+	defer func() {
+		if err := androidpublisher.NewEditsService(service).Delete(configs.PackageName, appEdit.Id).Context(ctx).Do(); err != nil {
+			log.Debugf("Failed to delete edit (%s), error: %s", appEdit.Id, err)
+		}
+	}()
+
+	var envs []envVar
+	var trackReleases []string
+	for _, trackName := range trackNames {
+		track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, trackName)
+		if err != nil {
+			return err
+		}
+		release := releaseOfVersionCodes(track, versionCodes)
+		if release == nil {
+			return fmt.Errorf("%s track has no release with version codes %v", track.Track, versionCodes)
+		}
+		log.Printf("Release %s on %s track: %s", release.Name, track.Track, release.Status)
+		trackReleases = append(trackReleases, trackReleaseEntry(track, release))
+		if envs != nil {
+			continue
+		}
+
+		var trackVersionCodes []string
+		for _, versionCode := range activeVersionCodes(track) {
+			trackVersionCodes = append(trackVersionCodes, strconv.FormatInt(versionCode, 10))
+		}
+		log.Printf("Version codes served by %s track: %s", track.Track, strings.Join(trackVersionCodes, ", "))
+
+		envs = []envVar{
+			{releaseNameEnvKey, release.Name},
+			{releaseStatusEnvKey, release.Status},
+			{trackEnvKey, track.Track},
+			{userFractionEnvKey, releaseUserFraction(release)},
+			{versionCodesEnvKey, strings.Join(trackVersionCodes, ",")},
+		}
+	}
+	return exportEnvironments(append(envs, envVar{trackReleasesEnvKey, strings.Join(trackReleases, "\n")}))
+}
+
+// trackReleaseEntry returns the entry of the given release in the list of the releases of the tracks:
+// <track>:<status>:<user fraction>, where the user fraction is empty if the release is not a staged rollout.
+func trackReleaseEntry(track *androidpublisher.Track, release *androidpublisher.TrackRelease) string {
+	return fmt.Sprintf("%s:%s:%s", track.Track, release.Status, releaseUserFraction(release))
+}
+
+// releaseUserFraction returns the user fraction of the given release if it is a staged rollout, empty otherwise.
+func releaseUserFraction(release *androidpublisher.TrackRelease) string {
+	if !shouldApplyUserFraction(release.Status) {
+		return ""
+	}
+	return strconv.FormatFloat(release.UserFraction, 'f', -1, 64)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+func Test_trackReleaseState(t *testing.T) {
+	track := &androidpublisher.Track{Track: "beta", Releases: []*androidpublisher.TrackRelease{
+		{Status: releaseStatusCompleted, VersionCodes: []int64{1, 2}},
+		{Status: releaseStatusInProgress, VersionCodes: []int64{3}},
+		{Status: releaseStatusDraft, VersionCodes: []int64{4}},
+	}}
+
+	tests := []struct {
+		name         string
+		versionCodes []int64
+		want         string
+	}{
+		{"completed", []int64{1, 2}, releaseStateAssigned},
+		{"in progress", []int64{3}, releaseStateAssigned},
+		{"draft", []int64{4}, releaseStatusDraft},
+		{"not on the track", []int64{5}, releaseStateNotAssigned},
+		{"partly on the track", []int64{2, 5}, releaseStateNotAssigned},
+		{"no version codes", nil, releaseStateNotAssigned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, trackReleaseState(track, tt.versionCodes))
+		})
+	}
+}
+
+func Test_releaseOfVersionCodes(t *testing.T) {
+	completed := &androidpublisher.TrackRelease{Name: "1.0", Status: releaseStatusCompleted, VersionCodes: []int64{1, 2}}
+	draft := &androidpublisher.TrackRelease{Name: "1.1", Status: releaseStatusDraft, VersionCodes: []int64{3}}
+	track := &androidpublisher.Track{Track: "beta", Releases: []*androidpublisher.TrackRelease{completed, draft}}
+
+	require.Equal(t, completed, releaseOfVersionCodes(track, []int64{1, 2}))
+	require.Equal(t, draft, releaseOfVersionCodes(track, []int64{3}))
+	require.Nil(t, releaseOfVersionCodes(track, []int64{2, 3}))
+	require.Nil(t, releaseOfVersionCodes(track, nil))
+}
+
+func Test_releaseUserFraction(t *testing.T) {
+	require.Equal(t, "0.25", releaseUserFraction(&androidpublisher.TrackRelease{Status: releaseStatusInProgress, UserFraction: 0.25}))
+	require.Equal(t, "0.1", releaseUserFraction(&androidpublisher.TrackRelease{Status: releaseStatusHalted, UserFraction: 0.1}))
+	require.Equal(t, "", releaseUserFraction(&androidpublisher.TrackRelease{Status: releaseStatusCompleted}))
+	require.Equal(t, "", releaseUserFraction(&androidpublisher.TrackRelease{Status: releaseStatusDraft}))
+}
+
+func Test_trackReleaseEntry(t *testing.T) {
+	track := &androidpublisher.Track{Track: "production"}
+	require.Equal(t, "production:inProgress:0.25", trackReleaseEntry(track, &androidpublisher.TrackRelease{Status: releaseStatusInProgress, UserFraction: 0.25}))
+	require.Equal(t, "production:completed:", trackReleaseEntry(track, &androidpublisher.TrackRelease{Status: releaseStatusCompleted}))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	deploySummaryPathEnvKey = "GOOGLE_PLAY_DEPLOY_SUMMARY_PATH"
+	deploySummaryFileName   = "google-play-deploy-summary.json"
+)
+
+// deploySummary is the result of the step, written as JSON for release dashboards.
+type deploySummary struct {
+	StartedAt  string           `json:"started_at"`
+	FinishedAt string           `json:"finished_at"`
+	Succeeded  bool             `json:"succeeded"`
+	Timings    []phaseTiming    `json:"timings,omitempty"`
+	Packages   []packageSummary `json:"packages"`
+}
+
+// packageSummary is the result of the step for a package in the deploy summary.
+type packageSummary struct {
+	PackageName        string             `json:"package_name"`
+	Operation          string             `json:"operation"`
+	Tracks             []string           `json:"tracks"`
+	Status             string             `json:"status,omitempty"`
+	UserFraction       float64            `json:"user_fraction,omitempty"`
+	ReleaseName        string             `json:"release_name,omitempty"`
+	VersionCodes       []int64            `json:"version_codes,omitempty"`
+	Artifacts          []artifactSummary  `json:"artifacts,omitempty"`
+	DeobfuscationFiles map[int64][]string `json:"deobfuscation_files,omitempty"`
+	EditIDs            []string           `json:"edit_ids,omitempty"`
+	EditCreatedAt      string             `json:"edit_created_at,omitempty"`
+	EditExpiresAt      string             `json:"edit_expires_at,omitempty"`
+	CommittedAt        string             `json:"committed_at,omitempty"`
+	SentForReview      *bool              `json:"sent_for_review,omitempty"`
+	ClearedReleases    []string           `json:"cleared_releases,omitempty"`
+	SharingURLs        []string           `json:"internal_app_sharing_urls,omitempty"`
+	ConsoleURL         string             `json:"console_url,omitempty"`
+	Calls              []callSummary      `json:"api_calls"`
+	Timings            []phaseTiming      `json:"timings,omitempty"`
+	Error              string             `json:"error,omitempty"`
+	ErrorCategory      string             `json:"error_category,omitempty"`
+
+	// phaseCategory is the category of the phase which failed, attached where the error is created.
+	phaseCategory errorCategory
+}
+
+// artifactSummary is an app of the package in the deploy summary.
+type artifactSummary struct {
+	Path        string `json:"path"`
+	Sha1        string `json:"sha1"`
+	Sha256      string `json:"sha256"`
+	VersionCode int64  `json:"version_code,omitempty"`
+}
+
+// callSummary is the outcome of a Google Play API call (or of the group of calls of an operation) in the deploy
+// summary.
+type callSummary struct {
+	Name       string `json:"name"`
+	FinishedAt string `json:"finished_at"`
+	Error      string `json:"error,omitempty"`
+}
+
+// newPackageSummary returns the summary of the package of the given configs, with the release the step creates.
+func newPackageSummary(configs Configs) *packageSummary {
+	operation := configs.Operation
+	if operation == "" {
+		operation = operationDeploy
+	}
+	summary := &packageSummary{
+		PackageName: configs.PackageName,
+		Operation:   operation,
+		Tracks:      configs.tracks(),
+		Calls:       []callSummary{},
+	}
+	if configs.isDeploy() && !configs.UploadOnly {
+		summary.Status = configs.Status
+		if summary.Status == "" {
+			summary.Status = releaseStatusCompleted
+			if configs.UserFraction != 0 {
+				summary.Status = releaseStatusInProgress
+			}
+		}
+		if shouldApplyUserFraction(summary.Status) {
+			summary.UserFraction = configs.UserFraction
+		}
+		summary.ReleaseName = configs.ReleaseName
+	}
+	return summary
+}
+
+// failPhase records the category of the failed phase, and returns the given error message.
+func (s *packageSummary) failPhase(category errorCategory, errorString string) string {
+	s.phaseCategory = category
+	return errorString
+}
+
+// recordCall adds the outcome of the given call to the summary. An empty errorString means the call succeeded.
+func (s *packageSummary) recordCall(name, errorString string) {
+	s.Calls = append(s.Calls, callSummary{Name: name, FinishedAt: time.Now().Format(time.RFC3339), Error: errorString})
+}
+
+// recordArtifacts adds the apps of the package with their hashes and version codes to the summary.
+func (s *packageSummary) recordArtifacts(configs Configs) error {
+	appPaths, err := configs.packageAppPaths()
+	if err != nil {
+		return err
+	}
+	for _, pth := range appPaths {
+		sha1, sha256, err := fileHashes(pth)
+		if err != nil {
+			return err
+		}
+		artifact := artifactSummary{Path: pth, Sha1: sha1, Sha256: sha256}
+		if manifest, err := readAppManifest(pth); err != nil {
+			log.Debugf("Failed to read the manifest of app (%s), error: %s", pth, err)
+		} else {
+			artifact.VersionCode = manifest.VersionCode
+			if !containsVersionCode(s.VersionCodes, manifest.VersionCode) {
+				s.VersionCodes = append(s.VersionCodes, manifest.VersionCode)
+			}
+		}
+		s.Artifacts = append(s.Artifacts, artifact)
+	}
+	return nil
+}
+
+// writeDeploySummary writes the summary to the deploy_summary_dir as JSON and exports its path.
+func writeDeploySummary(configs Configs, summary deploySummary) error {
+	pth := filepath.Join(configs.DeploySummaryDir, deploySummaryFileName)
+	if err := writeJSONFile(pth, summary); err != nil {
+		return fmt.Errorf("failed to write deploy summary, error: %s", err)
+	}
+	log.Printf("Deploy summary written to: %s", pth)
+	return exportEnvironment(deploySummaryPathEnvKey, pth)
+}
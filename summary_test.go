@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newPackageSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs Configs
+		want    string
+	}{
+		{
+			name:    "completed release",
+			configs: Configs{PackageName: "io.bitrise.sample", Track: "production", ReleaseName: "1.0"},
+			want:    `{"package_name": "io.bitrise.sample", "operation": "deploy", "tracks": ["production"], "status": "completed", "release_name": "1.0", "api_calls": []}`,
+		},
+		{
+			name:    "staged rollout",
+			configs: Configs{PackageName: "io.bitrise.sample", Track: "beta", UserFraction: 0.1},
+			want:    `{"package_name": "io.bitrise.sample", "operation": "deploy", "tracks": ["beta"], "status": "inProgress", "user_fraction": 0.1, "api_calls": []}`,
+		},
+		{
+			name:    "draft release",
+			configs: Configs{PackageName: "io.bitrise.sample", Track: "beta", Status: releaseStatusDraft, UserFraction: 0.1},
+			want:    `{"package_name": "io.bitrise.sample", "operation": "deploy", "tracks": ["beta"], "status": "draft", "api_calls": []}`,
+		},
+		{
+			name:    "upload only",
+			configs: Configs{PackageName: "io.bitrise.sample", Track: "beta", UploadOnly: true},
+			want:    `{"package_name": "io.bitrise.sample", "operation": "deploy", "tracks": ["beta"], "api_calls": []}`,
+		},
+		{
+			name:    "other operation",
+			configs: Configs{PackageName: "io.bitrise.sample", Track: "production", Operation: operationHaltRollout},
+			want:    `{"package_name": "io.bitrise.sample", "operation": "halt_rollout", "tracks": ["production"], "api_calls": []}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := json.Marshal(newPackageSummary(tt.configs))
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, string(content))
+		})
+	}
+}
+
+func Test_packageSummary_recordCall(t *testing.T) {
+	summary := newPackageSummary(Configs{PackageName: "io.bitrise.sample", Track: "production"})
+	summary.recordCall("edits.insert", "")
+	summary.recordCall("deploy", "Failed to upload APKs")
+
+	require.Len(t, summary.Calls, 2)
+	require.Equal(t, "edits.insert", summary.Calls[0].Name)
+	require.Empty(t, summary.Calls[0].Error)
+	require.NotEmpty(t, summary.Calls[0].FinishedAt)
+	require.Equal(t, "deploy", summary.Calls[1].Name)
+	require.Equal(t, "Failed to upload APKs", summary.Calls[1].Error)
+}
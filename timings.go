@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const phaseTimingsEnvKey = "GOOGLE_PLAY_PHASE_TIMINGS"
+
+// phaseTiming is the time spent in a phase of the step.
+type phaseTiming struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// newPhaseTiming returns the timing of the given phase started at the given time and finished now.
+func newPhaseTiming(name string, start time.Time) phaseTiming {
+	return phaseTiming{Name: name, Seconds: time.Since(start).Round(100 * time.Millisecond).Seconds()}
+}
+
+// recordPhase adds the timing of the given phase, started at the given time and finished now, to the summary.
+func (s *packageSummary) recordPhase(name string, start time.Time) {
+	s.Timings = append(s.Timings, newPhaseTiming(name, start))
+}
+
+// timingsReport returns the timings of the summary as <phase>: <seconds>s lines, in the order of the phases. The
+// phases of the packages are prefixed with the package name if there are multiple packages.
+func timingsReport(summary deploySummary) []string {
+	var lines []string
+	for _, timing := range summary.Timings {
+		lines = append(lines, fmt.Sprintf("%s: %vs", timing.Name, timing.Seconds))
+	}
+	for _, packageSummary := range summary.Packages {
+		for _, timing := range packageSummary.Timings {
+			name := timing.Name
+			if len(summary.Packages) > 1 {
+				name = packageSummary.PackageName + " " + name
+			}
+			lines = append(lines, fmt.Sprintf("%s: %vs", name, timing.Seconds))
+		}
+	}
+	return lines
+}
+
+// printTimings prints the timings of the summary and exports them.
+func printTimings(summary deploySummary) error {
+	lines := timingsReport(summary)
+	for _, line := range lines {
+		log.Printf("- %s", line)
+	}
+	return exportEnvironment(phaseTimingsEnvKey, strings.Join(lines, "\n"))
+}
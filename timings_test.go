@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_timingsReport(t *testing.T) {
+	summary := deploySummary{
+		Timings: []phaseTiming{{Name: "auth", Seconds: 1.2}},
+		Packages: []packageSummary{
+			{PackageName: "io.bitrise.sample", Timings: []phaseTiming{{Name: "upload app-release.aab", Seconds: 95.4}, {Name: "commit", Seconds: 12}}},
+		},
+	}
+	require.Equal(t, []string{"auth: 1.2s", "upload app-release.aab: 95.4s", "commit: 12s"}, timingsReport(summary))
+
+	summary.Packages = append(summary.Packages, packageSummary{PackageName: "io.bitrise.other", Timings: []phaseTiming{{Name: "commit", Seconds: 3.5}}})
+	require.Equal(t, []string{
+		"auth: 1.2s",
+		"io.bitrise.sample upload app-release.aab: 95.4s",
+		"io.bitrise.sample commit: 12s",
+		"io.bitrise.other commit: 3.5s",
+	}, timingsReport(summary))
+}
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+const (
+	operationDeploy          = "deploy"
+	operationPromote         = "promote"
+	operationHaltRollout     = "halt_rollout"
+	operationUpdateRollout   = "update_rollout"
+	operationCompleteRollout = "complete_rollout"
+	operationRollback        = "rollback"
+	operationRampRollout     = "ramp_rollout"
+	operationListTracks      = "list_tracks"
+	operationUpdateListing   = "update_listing"
+
+	existingRolloutFail          = "fail"
+	existingRolloutCompleteFirst = "complete_first"
+	existingRolloutHaltFirst     = "halt_first"
+	existingRolloutReplace       = "replace"
+
+	shadowedReleaseCheckWarn = "warn"
+	shadowedReleaseCheckFail = "fail"
+	shadowedReleaseCheckOff  = "off"
+
+	productionTrack = "production"
+	// legacyRolloutTrack is the staged rollout pseudo-track of the v2 API, a staged rollout on the production track in v3.
+	legacyRolloutTrack = "rollout"
+
+	// rollbackToPrevious is the rollback_version_code input value to roll back to the previous release.
+	rollbackToPrevious = "previous"
+
+	// clearedReleasesEnvKey is the output of the tracks and version codes cleared by the clear_lower_tracks input.
+	clearedReleasesEnvKey = "GOOGLE_PLAY_CLEARED_RELEASES"
+)
+
+// getTrack returns the given track of the edit.
+func getTrack(ctx context.Context, service *androidpublisher.Service, packageName, appEditID, track string) (*androidpublisher.Track, error) {
+	trackName, err := resolveTrack(ctx, service, packageName, appEditID, track)
+	if err != nil {
+		return nil, err
+	}
+
+	editsTrack, err := androidpublisher.NewEditsTracksService(service).Get(packageName, appEditID, trackName).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s track, error: %s", trackName, err)
+	}
+	return editsTrack, nil
+}
+
+// updateTrackReleases sets the releases of the given track, the releases not listed are removed from the track.
+func updateTrackReleases(ctx context.Context, service *androidpublisher.Service, packageName, appEditID string, track *androidpublisher.Track) error {
+	updatedTrack, err := androidpublisher.NewEditsTracksService(service).Update(packageName, appEditID, track.Track, track).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("update call failed, error: %s", err)
+	}
+	log.Printf(" updated track: %s", updatedTrack.Track)
+	return nil
+}
+
+// inProgressRelease returns the staged rollout in progress of the given track, or nil if there is none.
+func inProgressRelease(track *androidpublisher.Track) *androidpublisher.TrackRelease {
+	return releaseWithStatus(track, releaseStatusInProgress)
+}
+
+// releaseWithStatus returns the first release of the given track with the given status, or nil if there is none.
+func releaseWithStatus(track *androidpublisher.Track, status string) *androidpublisher.TrackRelease {
+	for _, release := range track.Releases {
+		if release.Status == status {
+			return release
+		}
+	}
+	return nil
+}
+
+// liveRelease returns the release of the track the users currently receive: the staged rollout in progress, or the
+// completed release if there is no rollout in progress. Returns nil if the track has no live release.
+func liveRelease(track *androidpublisher.Track) *androidpublisher.TrackRelease {
+	if release := inProgressRelease(track); release != nil {
+		return release
+	}
+	return releaseWithStatus(track, releaseStatusCompleted)
+}
+
+// latestRelease returns the release of the given track with the highest version code, or nil if the track has no
+// release with version codes.
+func latestRelease(track *androidpublisher.Track) *androidpublisher.TrackRelease {
+	var latest *androidpublisher.TrackRelease
+	var latestVersionCode int64
+	for _, release := range track.Releases {
+		for _, versionCode := range release.VersionCodes {
+			if versionCode > latestVersionCode {
+				latest = release
+				latestVersionCode = versionCode
+			}
+		}
+	}
+	return latest
+}
+
+// activeVersionCodes returns the version codes the given track serves to its users, the ones of its completed release
+// and of its staged rollout in progress, in ascending order.
+func activeVersionCodes(track *androidpublisher.Track) []int64 {
+	var versionCodes []int64
+	for _, release := range track.Releases {
+		if release.Status != releaseStatusCompleted && release.Status != releaseStatusInProgress {
+			continue
+		}
+		for _, versionCode := range release.VersionCodes {
+			if !containsVersionCode(versionCodes, versionCode) {
+				versionCodes = append(versionCodes, versionCode)
+			}
+		}
+	}
+	sort.Slice(versionCodes, func(i, j int) bool { return versionCodes[i] < versionCodes[j] })
+	return versionCodes
+}
+
+// appendLiveVersionCodes adds the version codes of the live release of the given track to the given release, so the
+// new release keeps serving them.
+func appendLiveVersionCodes(release *androidpublisher.TrackRelease, track *androidpublisher.Track) {
+	current := liveRelease(track)
+	if current == nil {
+		log.Printf("%s track has no live release, no version codes to append to", track.Track)
+		return
+	}
+
+	for _, versionCode := range current.VersionCodes {
+		if !containsVersionCode(release.VersionCodes, versionCode) {
+			release.VersionCodes = append(release.VersionCodes, versionCode)
+		}
+	}
+	log.Printf("Appending to the version codes of release %s on %s track: %v", current.Name, track.Track, release.VersionCodes)
+}
+
+// replaceNamedRelease replaces the release of the track having the same name as the given release. Returns false if
+// the track has no release with that name.
+func replaceNamedRelease(track *androidpublisher.Track, release *androidpublisher.TrackRelease) bool {
+	for i, current := range track.Releases {
+		if current.Name == release.Name {
+			log.Printf("Updating release %s (status: %s, version codes: %v) on %s track", current.Name, current.Status, current.VersionCodes, track.Track)
+			track.Releases[i] = release
+			return true
+		}
+	}
+	log.Warnf("%s track has no release named %s, creating a new release", track.Track, release.Name)
+	return false
+}
+
+// mergeRelease returns the releases of the track with the given release, replacing the release with the same status.
+// A track can have only one staged rollout (in progress or halted), which is also replaced by a completed release, as
+// the rollout would not allow its users to upgrade to the completed release.
+func mergeRelease(track *androidpublisher.Track, release *androidpublisher.TrackRelease) []*androidpublisher.TrackRelease {
+	var releases []*androidpublisher.TrackRelease
+	for _, current := range track.Releases {
+		replaced := current.Status == release.Status
+		if shouldApplyUserFraction(current.Status) && (release.Status == releaseStatusCompleted || shouldApplyUserFraction(release.Status)) {
+			replaced = true
+		}
+		if replaced {
+			log.Printf("Replacing release %s (status: %s, version codes: %v) on %s track", current.Name, current.Status, current.VersionCodes, track.Track)
+			continue
+		}
+		log.Printf("Keeping release %s (status: %s, version codes: %v) on %s track", current.Name, current.Status, current.VersionCodes, track.Track)
+		releases = append(releases, current)
+	}
+	return append(releases, release)
+}
+
+// removeDraftReleases removes the draft releases of the given track. Returns the number of removed releases.
+func removeDraftReleases(track *androidpublisher.Track) int {
+	var releases []*androidpublisher.TrackRelease
+	for _, release := range track.Releases {
+		if release.Status == releaseStatusDraft {
+			log.Printf("Removing draft release %s (version codes: %v) from %s track", release.Name, release.VersionCodes, track.Track)
+			continue
+		}
+		releases = append(releases, release)
+	}
+
+	removed := len(track.Releases) - len(releases)
+	track.Releases = releases
+	return removed
+}
+
+// promoteRelease creates a release on the track with the version codes of the live release of the source track. The
+// name and the release notes of the source release are kept, unless the release_name input or release notes for the
+// track are given. Returns the new release.
+func promoteRelease(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) (*androidpublisher.TrackRelease, error) {
+	sourceTrack, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, configs.SourceTrack)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceRelease := liveRelease(sourceTrack)
+	if sourceRelease == nil {
+		return nil, fmt.Errorf("%s track has no completed or in progress release to promote", sourceTrack.Track)
+	}
+	log.Printf("Promoting release %s (version codes: %v) from %s track", sourceRelease.Name, sourceRelease.VersionCodes, sourceTrack.Track)
+
+	newRelease, err := createTrackRelease(configs.forTrack(configs.track()), sourceRelease.VersionCodes)
+	if err != nil {
+		return nil, err
+	}
+	if newRelease.Name == "" {
+		newRelease.Name = sourceRelease.Name
+	}
+	if len(newRelease.ReleaseNotes) == 0 {
+		newRelease.ReleaseNotes = sourceRelease.ReleaseNotes
+	}
+
+	if !shouldApplyUserFraction(newRelease.Status) {
+		if err := updateTrack(ctx, service, configs.PackageName, appEdit.Id, configs.track(), newRelease); err != nil {
+			return nil, err
+		}
+		return newRelease, nil
+	}
+
+	track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, configs.track())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Promoting as a staged rollout to %v of the users of %s track", newRelease.UserFraction, track.Track)
+	track.Releases = stagedRolloutReleases(track, newRelease)
+	if err := updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, track); err != nil {
+		return nil, err
+	}
+	return newRelease, nil
+}
+
+// updateReleaseNotes sets the release notes of the latest release of the track, without changing its version codes
+// or status.
+func updateReleaseNotes(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) error {
+	track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, configs.track())
+	if err != nil {
+		return err
+	}
+
+	release := latestRelease(track)
+	if release == nil {
+		return fmt.Errorf("%s track has no release to update the release notes of", track.Track)
+	}
+	log.Printf("Updating the release notes of release %s (version codes: %v, status: %s) on %s track", release.Name, release.VersionCodes, release.Status, track.Track)
+
+	releaseNotes, err := releaseNotesTexts(configs.forTrack(configs.track()), releaseNotesVariables(configs, release.VersionCodes))
+	if err != nil {
+		return err
+	}
+	release.ReleaseNotes = releaseNotes
+	return updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, track)
+}
+
+// stagedRolloutReleases returns the releases of the track with the given staged rollout: the completed release of
+// the track is kept, so the rest of the users keep receiving it.
+func stagedRolloutReleases(track *androidpublisher.Track, rollout *androidpublisher.TrackRelease) []*androidpublisher.TrackRelease {
+	var releases []*androidpublisher.TrackRelease
+	if completed := releaseWithStatus(track, releaseStatusCompleted); completed != nil {
+		log.Printf("Keeping release %s (version codes: %v) for the rest of the users", completed.Name, completed.VersionCodes)
+		releases = append(releases, completed)
+	}
+	return append(releases, rollout)
+}
+
+// haltRollout halts the staged rollout in progress on the track, and returns the halted release.
+func haltRollout(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) (*androidpublisher.TrackRelease, error) {
+	track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, configs.track())
+	if err != nil {
+		return nil, err
+	}
+
+	release := inProgressRelease(track)
+	if release == nil {
+		return nil, fmt.Errorf("%s track has no staged rollout in progress", track.Track)
+	}
+	log.Printf("Halting release %s (version codes: %v, user fraction: %v) on %s track", release.Name, release.VersionCodes, release.UserFraction, track.Track)
+
+	release.Status = releaseStatusHalted
+	if err := updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, track); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// updateRollout sets the user fraction of the staged rollout in progress on the track, and returns the updated release.
+func updateRollout(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) (*androidpublisher.TrackRelease, error) {
+	track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, configs.track())
+	if err != nil {
+		return nil, err
+	}
+
+	release := inProgressRelease(track)
+	if release == nil {
+		release = releaseWithStatus(track, releaseStatusHalted)
+		if release == nil {
+			return nil, fmt.Errorf("%s track has no staged rollout in progress or halted", track.Track)
+		}
+		log.Printf("Resuming halted release %s", release.Name)
+		release.Status = releaseStatusInProgress
+	}
+	if configs.UserFraction <= release.UserFraction {
+		log.Warnf("The new user fraction (%v) is not greater than the current one (%v)", configs.UserFraction, release.UserFraction)
+	}
+	if configs.UserFraction > release.UserFraction {
+		if err := checkReleaseVitals(ctx, configs, release); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Updating the user fraction of release %s (version codes: %v) on %s track: %v -> %v", release.Name, release.VersionCodes, track.Track, release.UserFraction, configs.UserFraction)
+
+	release.UserFraction = configs.UserFraction
+	if err := updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, track); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// completeRollout releases the staged rollout in progress on the track to every user, and returns the completed release.
+func completeRollout(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) (*androidpublisher.TrackRelease, error) {
+	track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, configs.track())
+	if err != nil {
+		return nil, err
+	}
+
+	release := inProgressRelease(track)
+	if release == nil {
+		return nil, fmt.Errorf("%s track has no staged rollout in progress", track.Track)
+	}
+	log.Printf("Completing release %s (version codes: %v, user fraction: %v) on %s track", release.Name, release.VersionCodes, release.UserFraction, track.Track)
+	if err := checkReleaseVitals(ctx, configs, release); err != nil {
+		return nil, err
+	}
+
+	track.Releases = completedRolloutReleases(track.Releases, release)
+	if err := updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, track); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// completedRolloutReleases returns the releases of a track after completing the given rollout: the rollout becomes
+// the completed release and replaces the previous completed release, as a track can have only one.
+func completedRolloutReleases(releases []*androidpublisher.TrackRelease, rollout *androidpublisher.TrackRelease) []*androidpublisher.TrackRelease {
+	var completed []*androidpublisher.TrackRelease
+	for _, release := range releases {
+		if release == rollout {
+			release.Status = releaseStatusCompleted
+			release.UserFraction = 0
+		} else if release.Status == releaseStatusCompleted {
+			log.Printf(" release %s (version codes: %v) is replaced", release.Name, release.VersionCodes)
+			continue
+		}
+		completed = append(completed, release)
+	}
+	return completed
+}
+
+// resolveExistingRollout returns the releases of the track with the new release, resolving the staged rollout in
+// progress on the track according to the given existing_rollout_policy input value. Returns nil if the track has no
+// rollout in progress or the rollout is replaced by the new release.
+func resolveExistingRollout(policy string, track *androidpublisher.Track, newRelease *androidpublisher.TrackRelease) ([]*androidpublisher.TrackRelease, error) {
+	rollout := inProgressRelease(track)
+	if rollout == nil {
+		return nil, nil
+	}
+
+	switch policy {
+	case existingRolloutFail:
+		return nil, fmt.Errorf("%s track has a staged rollout in progress: release %s (version codes: %v, user fraction: %v)", track.Track, rollout.Name, rollout.VersionCodes, rollout.UserFraction)
+	case existingRolloutCompleteFirst:
+		log.Printf("Completing release %s (version codes: %v, user fraction: %v) on %s track before creating the new release", rollout.Name, rollout.VersionCodes, rollout.UserFraction, track.Track)
+		return appendRelease(completedRolloutReleases(track.Releases, rollout), newRelease), nil
+	case existingRolloutHaltFirst:
+		log.Printf("Halting release %s (version codes: %v, user fraction: %v) on %s track before creating the new release", rollout.Name, rollout.VersionCodes, rollout.UserFraction, track.Track)
+		rollout.Status = releaseStatusHalted
+		return appendRelease(track.Releases, newRelease), nil
+	default:
+		log.Printf("Release %s (version codes: %v, user fraction: %v) on %s track is replaced by the new release", rollout.Name, rollout.VersionCodes, rollout.UserFraction, track.Track)
+		return nil, nil
+	}
+}
+
+// appendRelease adds the given release to the releases. A completed release replaces the completed release of the
+// releases, as a track can have only one.
+func appendRelease(releases []*androidpublisher.TrackRelease, release *androidpublisher.TrackRelease) []*androidpublisher.TrackRelease {
+	var result []*androidpublisher.TrackRelease
+	for _, r := range releases {
+		if release.Status == releaseStatusCompleted && r.Status == releaseStatusCompleted {
+			log.Printf(" release %s (version codes: %v) is replaced", r.Name, r.VersionCodes)
+			continue
+		}
+		result = append(result, r)
+	}
+	return append(result, release)
+}
+
+// rollback creates a release on the track with the version codes of the rollback_version_code input. In case of
+// "previous", a staged rollout in progress is rolled back to the completed release of the track, otherwise the track
+// is rolled back to the highest uploaded version code lower than the ones of the live release. Returns the new release.
+func rollback(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) (*androidpublisher.TrackRelease, error) {
+	track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, configs.track())
+	if err != nil {
+		return nil, err
+	}
+
+	versionCodes, err := rollbackVersionCodes(ctx, configs, service, appEdit, track)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Rolling back %s track to version codes: %v", track.Track, versionCodes)
+
+	newRelease, err := createTrackRelease(configs.forTrack(track.Track), versionCodes)
+	if err != nil {
+		return nil, err
+	}
+	if err := updateTrack(ctx, service, configs.PackageName, appEdit.Id, track.Track, newRelease); err != nil {
+		return nil, err
+	}
+	return newRelease, nil
+}
+
+// rollbackVersionCodes returns the version codes to roll back the given track to.
+func rollbackVersionCodes(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit, track *androidpublisher.Track) ([]int64, error) {
+	if configs.RollbackVersionCode != rollbackToPrevious {
+		var versionCodes []int64
+		for _, code := range strings.Split(configs.RollbackVersionCode, ",") {
+			versionCode, err := strconv.ParseInt(strings.TrimSpace(code), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version code to roll back to: %s", code)
+			}
+			versionCodes = append(versionCodes, versionCode)
+		}
+		return versionCodes, nil
+	}
+
+	live := liveRelease(track)
+	if live == nil {
+		return nil, fmt.Errorf("%s track has no live release to roll back", track.Track)
+	}
+	if live.Status == releaseStatusInProgress {
+		for _, release := range track.Releases {
+			if release.Status == releaseStatusCompleted {
+				log.Printf("Rolling back the staged rollout of release %s to release %s", live.Name, release.Name)
+				return release.VersionCodes, nil
+			}
+		}
+	}
+
+	uploaded, err := uploadedVersionCodes(ctx, service, configs.PackageName, appEdit.Id)
+	if err != nil {
+		return nil, err
+	}
+	previous, ok := previousVersionCode(uploaded, live.VersionCodes)
+	if !ok {
+		return nil, fmt.Errorf("no version code found lower than the ones of release %s (%v)", live.Name, live.VersionCodes)
+	}
+	return []int64{previous}, nil
+}
+
+// uploadedVersionCodes returns the version codes of every app bundle and APK uploaded for the app.
+func uploadedVersionCodes(ctx context.Context, service *androidpublisher.Service, packageName, appEditID string) ([]int64, error) {
+	bundles, err := androidpublisher.NewEditsBundlesService(service).List(packageName, appEditID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list app bundles, error: %s", err)
+	}
+	apks, err := androidpublisher.NewEditsApksService(service).List(packageName, appEditID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list APKs, error: %s", err)
+	}
+
+	var versionCodes []int64
+	for _, bundle := range bundles.Bundles {
+		versionCodes = append(versionCodes, bundle.VersionCode)
+	}
+	for _, apk := range apks.Apks {
+		versionCodes = append(versionCodes, apk.VersionCode)
+	}
+	return versionCodes, nil
+}
+
+// previousVersionCode returns the highest of the uploaded version codes which is lower than every live version code.
+func previousVersionCode(uploaded, live []int64) (int64, bool) {
+	if len(live) == 0 {
+		return 0, false
+	}
+	lowestLive := live[0]
+	for _, versionCode := range live {
+		if versionCode < lowestLive {
+			lowestLive = versionCode
+		}
+	}
+
+	var previous int64
+	for _, versionCode := range uploaded {
+		if versionCode < lowestLive && versionCode > previous {
+			previous = versionCode
+		}
+	}
+	return previous, previous != 0
+}
+
+// validateVersionCodesAgainstTracks checks if the version code of every app is higher than the version codes of the
+// live release of the tracks, so a lower version code fails before uploading anything instead of at commit.
+func validateVersionCodesAgainstTracks(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) error {
+	apps, err := configs.packageAppPaths()
+	if err != nil {
+		return err
+	}
+
+	appVersionCodes := map[string]int64{}
+	for _, pth := range apps {
+		manifest, err := readAppManifest(pth)
+		if err != nil {
+			log.Warnf("Failed to read the version code of app (%s), skipping check: %s", pth, err)
+			continue
+		}
+		appVersionCodes[pth] = manifest.VersionCode
+	}
+
+	for _, trackName := range configs.tracks() {
+		track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, trackName)
+		if err != nil {
+			return err
+		}
+		release := liveRelease(track)
+		if release == nil {
+			continue
+		}
+		if err := checkHigherVersionCodes(appVersionCodes, release.VersionCodes); err != nil {
+			return fmt.Errorf("%s track: %s", track.Track, err)
+		}
+	}
+	return nil
+}
+
+// checkHigherVersionCodes returns an error if any of the app version codes is not higher than all of the live ones.
+func checkHigherVersionCodes(appVersionCodes map[string]int64, liveVersionCodes []int64) error {
+	var highestLive int64
+	for _, versionCode := range liveVersionCodes {
+		if versionCode > highestLive {
+			highestLive = versionCode
+		}
+	}
+
+	var apps []string
+	for pth := range appVersionCodes {
+		apps = append(apps, pth)
+	}
+	sort.Strings(apps)
+
+	for _, pth := range apps {
+		if appVersionCodes[pth] <= highestLive {
+			return fmt.Errorf("version code of app (%s) is %d, which is not higher than the version code of the live release: %d", pth, appVersionCodes[pth], highestLive)
+		}
+	}
+	return nil
+}
+
+// trackRank returns the audience rank of the given track: users of a track also receive the releases of the tracks
+// with a higher rank, the release with the highest version code among them is served. Custom tracks are closed
+// testing tracks, ranked as alpha.
+func trackRank(track string) int {
+	switch strings.ToLower(track) {
+	case "internal":
+		return 0
+	case "beta":
+		return 2
+	case productionTrack:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// shadowingTracks returns the tracks with a higher rank than the given one, having a live release with a version code
+// higher than all of the given version codes. The users of the given track receive the release of these tracks instead.
+func shadowingTracks(tracks []*androidpublisher.Track, target string, versionCodes []int64) []string {
+	var highest int64
+	for _, versionCode := range versionCodes {
+		if versionCode > highest {
+			highest = versionCode
+		}
+	}
+
+	var shadowing []string
+	for _, track := range tracks {
+		if trackRank(track.Track) <= trackRank(target) {
+			continue
+		}
+		release := liveRelease(track)
+		if release == nil {
+			continue
+		}
+		for _, versionCode := range release.VersionCodes {
+			if versionCode > highest {
+				shadowing = append(shadowing, fmt.Sprintf("%s (version code: %d)", track.Track, versionCode))
+				break
+			}
+		}
+	}
+	return shadowing
+}
+
+// checkShadowedReleases checks if the new release of the tracks would be shadowed by a release with a higher version
+// code on a track with a wider audience, and warns or fails according to the shadowed_release_check input.
+func checkShadowedReleases(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit, versionCodes []int64) error {
+	if configs.ShadowedReleaseCheck == shadowedReleaseCheckOff {
+		return nil
+	}
+
+	tracksListResponse, err := androidpublisher.NewEditsTracksService(service).List(configs.PackageName, appEdit.Id).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list tracks, error: %s", err)
+	}
+
+	for _, track := range configs.tracks() {
+		shadowing := shadowingTracks(tracksListResponse.Tracks, track, versionCodes)
+		if len(shadowing) == 0 {
+			continue
+		}
+		message := fmt.Sprintf("the release of %s track (version codes: %v) is shadowed by the release of: %s, its users receive that release instead", track, versionCodes, strings.Join(shadowing, ", "))
+		if configs.ShadowedReleaseCheck == shadowedReleaseCheckFail {
+			return errors.New(message)
+		}
+		log.Warnf(message)
+	}
+	return nil
+}
+
+// lowerTracks returns the given tracks with a lower rank than the highest ranked target track.
+func lowerTracks(tracks []string, targets []string) []string {
+	highest := -1
+	for _, target := range targets {
+		if rank := trackRank(target); rank > highest {
+			highest = rank
+		}
+	}
+
+	var lower []string
+	for _, track := range tracks {
+		if containsString(targets, track) {
+			continue
+		}
+		if trackRank(track) >= highest {
+			log.Warnf("%s track is not lower than the tracks updated (%s), not clearing it", track, strings.Join(targets, ", "))
+			continue
+		}
+		lower = append(lower, track)
+	}
+	return lower
+}
+
+// clearLowerTracks removes the releases of the lower tracks of the clear_lower_tracks input, so their users receive
+// the new release of the higher track. Returns the <track>:<version codes> entries of the cleared tracks, which are
+// exported once the edit is committed.
+func clearLowerTracks(ctx context.Context, configs Configs, service *androidpublisher.Service, appEdit *androidpublisher.AppEdit) ([]string, error) {
+	tracks, err := configs.lowerTracksToClear()
+	if err != nil {
+		return nil, err
+	}
+
+	var cleared []string
+	for _, trackName := range lowerTracks(tracks, configs.tracks()) {
+		track, err := getTrack(ctx, service, configs.PackageName, appEdit.Id, trackName)
+		if err != nil {
+			return nil, err
+		}
+		if len(track.Releases) == 0 {
+			log.Printf("%s track has no releases", track.Track)
+			continue
+		}
+		for _, release := range track.Releases {
+			log.Printf("Removing release %s (version codes: %v) from %s track", release.Name, release.VersionCodes, track.Track)
+		}
+		cleared = append(cleared, clearedTrackEntry(track))
+		track.Releases = []*androidpublisher.TrackRelease{}
+		track.ForceSendFields = []string{"Releases"}
+		if err := updateTrackReleases(ctx, service, configs.PackageName, appEdit.Id, track); err != nil {
+			return nil, fmt.Errorf("failed to clear %s track, error: %s", track.Track, err)
+		}
+	}
+	return cleared, nil
+}
+
+// clearedTrackEntry returns the <track>:<version codes> entry of the given track for the cleared releases output,
+// with the version codes of all of its releases.
+func clearedTrackEntry(track *androidpublisher.Track) string {
+	var versionCodes []string
+	for _, release := range track.Releases {
+		for _, versionCode := range release.VersionCodes {
+			versionCodes = append(versionCodes, strconv.FormatInt(versionCode, 10))
+		}
+	}
+	return fmt.Sprintf("%s:%s", track.Track, strings.Join(versionCodes, ","))
+}
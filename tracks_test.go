@@ -0,0 +1,294 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+func Test_liveRelease(t *testing.T) {
+	completed := &androidpublisher.TrackRelease{Name: "1.0", Status: releaseStatusCompleted}
+	inProgress := &androidpublisher.TrackRelease{Name: "1.1", Status: releaseStatusInProgress}
+	draft := &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusDraft}
+
+	tests := []struct {
+		name     string
+		releases []*androidpublisher.TrackRelease
+		want     *androidpublisher.TrackRelease
+	}{
+		{"completed", []*androidpublisher.TrackRelease{draft, completed}, completed},
+		{"rollout in progress", []*androidpublisher.TrackRelease{completed, inProgress}, inProgress},
+		{"no live release", []*androidpublisher.TrackRelease{draft}, nil},
+		{"empty track", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, liveRelease(&androidpublisher.Track{Releases: tt.releases}))
+		})
+	}
+}
+
+func Test_latestRelease(t *testing.T) {
+	completed := &androidpublisher.TrackRelease{Name: "1.0", Status: releaseStatusCompleted, VersionCodes: []int64{10, 11}}
+	inProgress := &androidpublisher.TrackRelease{Name: "1.1", Status: releaseStatusInProgress, VersionCodes: []int64{12}}
+	draft := &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusDraft, VersionCodes: []int64{13}}
+	empty := &androidpublisher.TrackRelease{Name: "empty", Status: releaseStatusDraft}
+
+	tests := []struct {
+		name     string
+		releases []*androidpublisher.TrackRelease
+		want     *androidpublisher.TrackRelease
+	}{
+		{"draft", []*androidpublisher.TrackRelease{draft, completed, inProgress}, draft},
+		{"rollout in progress", []*androidpublisher.TrackRelease{completed, inProgress}, inProgress},
+		{"release without version codes", []*androidpublisher.TrackRelease{empty, completed}, completed},
+		{"empty track", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, latestRelease(&androidpublisher.Track{Releases: tt.releases}))
+		})
+	}
+}
+
+func Test_inProgressRelease(t *testing.T) {
+	completed := &androidpublisher.TrackRelease{Name: "1.0", Status: releaseStatusCompleted}
+	inProgress := &androidpublisher.TrackRelease{Name: "1.1", Status: releaseStatusInProgress, UserFraction: 0.1}
+
+	require.Equal(t, inProgress, inProgressRelease(&androidpublisher.Track{Releases: []*androidpublisher.TrackRelease{completed, inProgress}}))
+	require.Nil(t, inProgressRelease(&androidpublisher.Track{Releases: []*androidpublisher.TrackRelease{completed}}))
+}
+
+func Test_completedRolloutReleases(t *testing.T) {
+	completed := &androidpublisher.TrackRelease{Name: "1.0", Status: releaseStatusCompleted, VersionCodes: []int64{1}}
+	inProgress := &androidpublisher.TrackRelease{Name: "1.1", Status: releaseStatusInProgress, VersionCodes: []int64{2}, UserFraction: 0.2}
+	draft := &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusDraft, VersionCodes: []int64{3}}
+
+	got := completedRolloutReleases([]*androidpublisher.TrackRelease{completed, inProgress, draft}, inProgress)
+	require.Equal(t, []*androidpublisher.TrackRelease{
+		{Name: "1.1", Status: releaseStatusCompleted, VersionCodes: []int64{2}},
+		draft,
+	}, got)
+}
+
+func Test_appendLiveVersionCodes(t *testing.T) {
+	track := &androidpublisher.Track{
+		Track: "production",
+		Releases: []*androidpublisher.TrackRelease{
+			{Name: "1.0", Status: releaseStatusCompleted, VersionCodes: []int64{1, 2}},
+			{Name: "1.1", Status: releaseStatusDraft, VersionCodes: []int64{3}},
+		},
+	}
+
+	release := &androidpublisher.TrackRelease{VersionCodes: []int64{2, 4}}
+	appendLiveVersionCodes(release, track)
+	require.Equal(t, []int64{2, 4, 1}, []int64(release.VersionCodes))
+
+	release = &androidpublisher.TrackRelease{VersionCodes: []int64{4}}
+	appendLiveVersionCodes(release, &androidpublisher.Track{Track: "alpha"})
+	require.Equal(t, []int64{4}, []int64(release.VersionCodes))
+}
+
+func Test_previousVersionCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		uploaded []int64
+		live     []int64
+		want     int64
+		wantOK   bool
+	}{
+		{"previous", []int64{1, 2, 3, 4}, []int64{4}, 3, true},
+		{"multiple live version codes", []int64{10, 11, 20, 21}, []int64{21, 20}, 11, true},
+		{"no previous", []int64{4}, []int64{4}, 0, false},
+		{"no live release", []int64{1, 2}, nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := previousVersionCode(tt.uploaded, tt.live)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func Test_checkHigherVersionCodes(t *testing.T) {
+	tests := []struct {
+		name             string
+		appVersionCodes  map[string]int64
+		liveVersionCodes []int64
+		wantErr          bool
+	}{
+		{"higher", map[string]int64{"app.aab": 11}, []int64{10}, false},
+		{"no live release", map[string]int64{"app.aab": 1}, nil, false},
+		{"same", map[string]int64{"app.aab": 10}, []int64{10}, true},
+		{"lower than one of the live version codes", map[string]int64{"arm.apk": 21, "x86.apk": 20}, []int64{19, 20}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkHigherVersionCodes(tt.appVersionCodes, tt.liveVersionCodes)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_replaceNamedRelease(t *testing.T) {
+	completed := &androidpublisher.TrackRelease{Name: "1.0", Status: releaseStatusCompleted, VersionCodes: []int64{1}}
+	draft := &androidpublisher.TrackRelease{Name: "1.1", Status: releaseStatusDraft, VersionCodes: []int64{2}}
+	track := &androidpublisher.Track{Track: "production", Releases: []*androidpublisher.TrackRelease{completed, draft}}
+
+	release := &androidpublisher.TrackRelease{Name: "1.1", Status: releaseStatusInProgress, VersionCodes: []int64{3}, UserFraction: 0.1}
+	require.True(t, replaceNamedRelease(track, release))
+	require.Equal(t, []*androidpublisher.TrackRelease{completed, release}, track.Releases)
+
+	require.False(t, replaceNamedRelease(track, &androidpublisher.TrackRelease{Name: "2.0"}))
+	require.Equal(t, []*androidpublisher.TrackRelease{completed, release}, track.Releases)
+}
+
+func Test_removeDraftReleases(t *testing.T) {
+	completed := &androidpublisher.TrackRelease{Name: "1.0", Status: releaseStatusCompleted, VersionCodes: []int64{1}}
+	inProgress := &androidpublisher.TrackRelease{Name: "1.1", Status: releaseStatusInProgress, VersionCodes: []int64{2}}
+	track := &androidpublisher.Track{Track: "beta", Releases: []*androidpublisher.TrackRelease{
+		{Name: "draft 1", Status: releaseStatusDraft},
+		completed,
+		{Name: "draft 2", Status: releaseStatusDraft},
+		inProgress,
+	}}
+
+	require.Equal(t, 2, removeDraftReleases(track))
+	require.Equal(t, []*androidpublisher.TrackRelease{completed, inProgress}, track.Releases)
+	require.Equal(t, 0, removeDraftReleases(track))
+}
+
+func Test_shadowingTracks(t *testing.T) {
+	release := func(status string, versionCodes ...int64) []*androidpublisher.TrackRelease {
+		return []*androidpublisher.TrackRelease{{Status: status, VersionCodes: versionCodes}}
+	}
+	tracks := []*androidpublisher.Track{
+		{Track: "internal", Releases: release(releaseStatusCompleted, 30)},
+		{Track: "alpha", Releases: release(releaseStatusCompleted, 12)},
+		{Track: "beta", Releases: release(releaseStatusDraft, 40)},
+		{Track: "production", Releases: release(releaseStatusInProgress, 20)},
+	}
+
+	require.Equal(t, []string(nil), shadowingTracks(tracks, "beta", []int64{21}))
+	require.Equal(t, []string{"production (version code: 20)"}, shadowingTracks(tracks, "beta", []int64{15}))
+	require.Equal(t, []string{"production (version code: 20)"}, shadowingTracks(tracks, "QA", []int64{10, 11}))
+	require.Equal(t, []string{"alpha (version code: 12)", "production (version code: 20)"}, shadowingTracks(tracks, "internal", []int64{11}))
+	require.Equal(t, []string(nil), shadowingTracks(tracks, "production", []int64{1}))
+}
+
+func Test_resolveExistingRollout(t *testing.T) {
+	newTrack := func() *androidpublisher.Track {
+		return &androidpublisher.Track{Track: "production", Releases: []*androidpublisher.TrackRelease{
+			{Name: "1.0", Status: releaseStatusCompleted, VersionCodes: []int64{1}},
+			{Name: "1.1", Status: releaseStatusInProgress, VersionCodes: []int64{2}, UserFraction: 0.2},
+		}}
+	}
+	names := func(releases []*androidpublisher.TrackRelease) (names []string) {
+		for _, release := range releases {
+			names = append(names, release.Name+":"+release.Status)
+		}
+		return
+	}
+
+	_, err := resolveExistingRollout(existingRolloutFail, newTrack(), &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusCompleted})
+	require.Error(t, err)
+
+	releases, err := resolveExistingRollout(existingRolloutReplace, newTrack(), &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusCompleted})
+	require.NoError(t, err)
+	require.Nil(t, releases)
+
+	releases, err = resolveExistingRollout(existingRolloutCompleteFirst, newTrack(), &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusInProgress})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.1:completed", "1.2:inProgress"}, names(releases))
+
+	releases, err = resolveExistingRollout(existingRolloutCompleteFirst, newTrack(), &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusCompleted})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.2:completed"}, names(releases))
+
+	releases, err = resolveExistingRollout(existingRolloutHaltFirst, newTrack(), &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusInProgress})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1.0:completed", "1.1:halted", "1.2:inProgress"}, names(releases))
+
+	noRollout := &androidpublisher.Track{Track: "production", Releases: []*androidpublisher.TrackRelease{{Name: "1.0", Status: releaseStatusCompleted}}}
+	releases, err = resolveExistingRollout(existingRolloutFail, noRollout, &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusCompleted})
+	require.NoError(t, err)
+	require.Nil(t, releases)
+}
+
+func Test_stagedRolloutReleases(t *testing.T) {
+	completed := &androidpublisher.TrackRelease{Name: "1.0", Status: releaseStatusCompleted, VersionCodes: []int64{1}}
+	oldRollout := &androidpublisher.TrackRelease{Name: "1.1", Status: releaseStatusInProgress, VersionCodes: []int64{2}}
+	rollout := &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusInProgress, VersionCodes: []int64{3}, UserFraction: 0.1}
+
+	track := &androidpublisher.Track{Track: "production", Releases: []*androidpublisher.TrackRelease{completed, oldRollout}}
+	require.Equal(t, []*androidpublisher.TrackRelease{completed, rollout}, stagedRolloutReleases(track, rollout))
+
+	empty := &androidpublisher.Track{Track: "production"}
+	require.Equal(t, []*androidpublisher.TrackRelease{rollout}, stagedRolloutReleases(empty, rollout))
+}
+
+func Test_lowerTracks(t *testing.T) {
+	require.Equal(t, []string{"internal", "alpha"}, lowerTracks([]string{"internal", "alpha", "production"}, []string{"beta"}))
+	require.Equal(t, []string{"internal"}, lowerTracks([]string{"internal", "QA"}, []string{"alpha"}))
+	require.Equal(t, []string{"QA"}, lowerTracks([]string{"internal", "QA"}, []string{"internal", "beta"}))
+	require.Equal(t, []string(nil), lowerTracks([]string{"beta"}, []string{"internal"}))
+}
+
+func Test_mergeRelease(t *testing.T) {
+	newTrack := func() *androidpublisher.Track {
+		return &androidpublisher.Track{Track: "production", Releases: []*androidpublisher.TrackRelease{
+			{Name: "1.0", Status: releaseStatusCompleted},
+			{Name: "1.1", Status: releaseStatusInProgress},
+			{Name: "2.0", Status: releaseStatusDraft},
+		}}
+	}
+	names := func(releases []*androidpublisher.TrackRelease) (names []string) {
+		for _, release := range releases {
+			names = append(names, release.Name)
+		}
+		return
+	}
+
+	tests := []struct {
+		name    string
+		release *androidpublisher.TrackRelease
+		want    []string
+	}{
+		{"completed", &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusCompleted}, []string{"2.0", "1.2"}},
+		{"in progress", &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusInProgress}, []string{"1.0", "2.0", "1.2"}},
+		{"halted", &androidpublisher.TrackRelease{Name: "1.2", Status: releaseStatusHalted}, []string{"1.0", "2.0", "1.2"}},
+		{"draft", &androidpublisher.TrackRelease{Name: "2.1", Status: releaseStatusDraft}, []string{"1.0", "1.1", "2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, names(mergeRelease(newTrack(), tt.release)))
+		})
+	}
+	require.Equal(t, []string{"1.0"}, names(mergeRelease(&androidpublisher.Track{}, &androidpublisher.TrackRelease{Name: "1.0", Status: releaseStatusCompleted})))
+}
+
+func Test_clearedTrackEntry(t *testing.T) {
+	track := &androidpublisher.Track{Track: "beta", Releases: []*androidpublisher.TrackRelease{
+		{Status: releaseStatusCompleted, VersionCodes: []int64{100, 101}},
+		{Status: releaseStatusInProgress, VersionCodes: []int64{102}},
+	}}
+	require.Equal(t, "beta:100,101,102", clearedTrackEntry(track))
+	require.Equal(t, "internal:", clearedTrackEntry(&androidpublisher.Track{Track: "internal", Releases: []*androidpublisher.TrackRelease{{Status: releaseStatusDraft}}}))
+}
+
+func Test_activeVersionCodes(t *testing.T) {
+	track := &androidpublisher.Track{Track: "production", Releases: []*androidpublisher.TrackRelease{
+		{Status: releaseStatusInProgress, VersionCodes: []int64{103, 101}},
+		{Status: releaseStatusCompleted, VersionCodes: []int64{100, 101}},
+		{Status: releaseStatusDraft, VersionCodes: []int64{104}},
+		{Status: releaseStatusHalted, VersionCodes: []int64{102}},
+	}}
+	require.Equal(t, []int64{100, 101, 103}, activeVersionCodes(track))
+	require.Nil(t, activeVersionCodes(&androidpublisher.Track{Track: "beta"}))
+}
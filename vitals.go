@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+const (
+	reportingScope   = "https://www.googleapis.com/auth/playdeveloperreporting"
+	reportingBaseURL = "https://playdeveloperreporting.googleapis.com/v1beta1"
+
+	crashRateMetricSet = "crashRateMetricSet"
+	crashRateMetric    = "crashRate"
+	anrRateMetricSet   = "anrRateMetricSet"
+	anrRateMetric      = "anrRate"
+)
+
+// reportingDateTime is a date time of the Play Developer Reporting API.
+type reportingDateTime struct {
+	Year     int `json:"year"`
+	Month    int `json:"month"`
+	Day      int `json:"day"`
+	TimeZone struct {
+		ID string `json:"id"`
+	} `json:"timeZone"`
+}
+
+// metricSet is the response of getting a metric set of the Play Developer Reporting API.
+type metricSet struct {
+	FreshnessInfo struct {
+		Freshnesses []struct {
+			AggregationPeriod string            `json:"aggregationPeriod"`
+			LatestEndTime     reportingDateTime `json:"latestEndTime"`
+		} `json:"freshnesses"`
+	} `json:"freshnessInfo"`
+}
+
+// metricSetQueryResponse is the response of querying a metric set of the Play Developer Reporting API.
+type metricSetQueryResponse struct {
+	Rows []struct {
+		Dimensions []struct {
+			Dimension   string `json:"dimension"`
+			StringValue string `json:"stringValue"`
+		} `json:"dimensions"`
+		Metrics []struct {
+			Metric       string `json:"metric"`
+			DecimalValue struct {
+				Value string `json:"value"`
+			} `json:"decimalValue"`
+		} `json:"metrics"`
+	} `json:"rows"`
+}
+
+// reportingRequest sends a request to the Play Developer Reporting API and decodes the response into the given value.
+func reportingRequest(ctx context.Context, client *http.Client, method, url string, body interface{}, v interface{}) error {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to serialize request, error: %s", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request, error: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request (%s), error: %s", url, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close response body, error: %s", err)
+		}
+	}()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response, error: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request (%s) failed with status code: %d, response: %s", url, resp.StatusCode, content)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("failed to parse response, error: %s", err)
+	}
+	return nil
+}
+
+// queryRate returns the highest daily rate of the given metric of the given version codes on the latest day the
+// metric is available for. Returns false if there is no data for the version codes yet.
+func queryRate(ctx context.Context, client *http.Client, baseURL, packageName, metricSetName, metric string, versionCodes []int64) (float64, bool, error) {
+	metricSetURL := fmt.Sprintf("%s/apps/%s/%s", baseURL, packageName, metricSetName)
+
+	var set metricSet
+	if err := reportingRequest(ctx, client, http.MethodGet, metricSetURL, nil, &set); err != nil {
+		return 0, false, err
+	}
+	var end *reportingDateTime
+	for _, freshness := range set.FreshnessInfo.Freshnesses {
+		if freshness.AggregationPeriod == "DAILY" {
+			end = &freshness.LatestEndTime
+			break
+		}
+	}
+	if end == nil {
+		return 0, false, fmt.Errorf("no daily %s data is available", metric)
+	}
+	startDate := time.Date(end.Year, time.Month(end.Month), end.Day, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	start := *end
+	start.Year, start.Month, start.Day = startDate.Year(), int(startDate.Month()), startDate.Day()
+
+	query := map[string]interface{}{
+		"timelineSpec": map[string]interface{}{
+			"aggregationPeriod": "DAILY",
+			"startTime":         start,
+			"endTime":           end,
+		},
+		"dimensions": []string{"versionCode"},
+		"metrics":    []string{metric},
+	}
+	var response metricSetQueryResponse
+	if err := reportingRequest(ctx, client, http.MethodPost, metricSetURL+":query", query, &response); err != nil {
+		return 0, false, err
+	}
+
+	var rate float64
+	var found bool
+	for _, row := range response.Rows {
+		var versionCode int64
+		for _, dimension := range row.Dimensions {
+			if dimension.Dimension == "versionCode" {
+				versionCode, _ = strconv.ParseInt(dimension.StringValue, 10, 64)
+			}
+		}
+		if !containsVersionCode(versionCodes, versionCode) {
+			continue
+		}
+		for _, m := range row.Metrics {
+			if m.Metric != metric {
+				continue
+			}
+			value, err := strconv.ParseFloat(m.DecimalValue.Value, 64)
+			if err != nil {
+				return 0, false, fmt.Errorf("invalid %s value: %s", metric, m.DecimalValue.Value)
+			}
+			if !found || value > rate {
+				rate = value
+			}
+			found = true
+		}
+	}
+	return rate, found, nil
+}
+
+// checkReleaseVitals returns an error if the crash or ANR rate of the given release exceeds the max_crash_rate or
+// max_anr_rate input, so the rollout is not increased.
+func checkReleaseVitals(ctx context.Context, configs Configs, release *androidpublisher.TrackRelease) error {
+	maxCrashRate, maxANRRate, err := configs.vitalsThresholds()
+	if err != nil || (maxCrashRate == 0 && maxANRRate == 0) {
+		return err
+	}
+
+	client, err := createHTTPClient(string(configs.JSONKeyPath), configs.apiTransport(), reportingScope)
+	if err != nil {
+		return fmt.Errorf("failed to create Play Developer Reporting API client, error: %s", err)
+	}
+
+	thresholds := []struct {
+		metricSet, metric string
+		max               float64
+	}{
+		{crashRateMetricSet, crashRateMetric, maxCrashRate},
+		{anrRateMetricSet, anrRateMetric, maxANRRate},
+	}
+	for _, threshold := range thresholds {
+		if threshold.max == 0 {
+			continue
+		}
+		rate, found, err := queryRate(ctx, client, reportingBaseURL, configs.PackageName, threshold.metricSet, threshold.metric, release.VersionCodes)
+		if err != nil {
+			return fmt.Errorf("failed to query %s, error: %s", threshold.metric, err)
+		}
+		if !found {
+			log.Warnf("No %s data is available for release %s (version codes: %v) yet", threshold.metric, release.Name, release.VersionCodes)
+			continue
+		}
+		log.Printf("%s of release %s: %.4f%% (max: %.4f%%)", threshold.metric, release.Name, rate*100, threshold.max*100)
+		if rate > threshold.max {
+			return fmt.Errorf("%s of release %s (%.4f%%) exceeds the maximum (%.4f%%), not increasing the rollout", threshold.metric, release.Name, rate*100, threshold.max*100)
+		}
+	}
+	return nil
+}
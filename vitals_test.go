@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_queryRate(t *testing.T) {
+	var query map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apps/io.bitrise.sample/crashRateMetricSet":
+			_, err := w.Write([]byte(`{"freshnessInfo": {"freshnesses": [
+				{"aggregationPeriod": "HOURLY", "latestEndTime": {"year": 2021, "month": 3, "day": 1, "timeZone": {"id": "America/Los_Angeles"}}},
+				{"aggregationPeriod": "DAILY", "latestEndTime": {"year": 2021, "month": 3, "day": 1, "timeZone": {"id": "America/Los_Angeles"}}}
+			]}}`))
+			require.NoError(t, err)
+		case "/apps/io.bitrise.sample/crashRateMetricSet:query":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+			_, err := w.Write([]byte(`{"rows": [
+				{"dimensions": [{"dimension": "versionCode", "stringValue": "1"}], "metrics": [{"metric": "crashRate", "decimalValue": {"value": "0.05"}}]},
+				{"dimensions": [{"dimension": "versionCode", "stringValue": "2"}], "metrics": [{"metric": "crashRate", "decimalValue": {"value": "0.01"}}]},
+				{"dimensions": [{"dimension": "versionCode", "stringValue": "3"}], "metrics": [{"metric": "crashRate", "decimalValue": {"value": "0.02"}}]}
+			]}`))
+			require.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	rate, found, err := queryRate(context.Background(), server.Client(), server.URL, "io.bitrise.sample", crashRateMetricSet, crashRateMetric, []int64{2, 3})
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, 0.02, rate)
+
+	timelineSpec := query["timelineSpec"].(map[string]interface{})
+	require.Equal(t, "DAILY", timelineSpec["aggregationPeriod"])
+	require.Equal(t, float64(28), timelineSpec["startTime"].(map[string]interface{})["day"])
+	require.Equal(t, float64(2), timelineSpec["startTime"].(map[string]interface{})["month"])
+	require.Equal(t, float64(1), timelineSpec["endTime"].(map[string]interface{})["day"])
+
+	_, found, err = queryRate(context.Background(), server.Client(), server.URL, "io.bitrise.sample", crashRateMetricSet, crashRateMetric, []int64{4})
+	require.NoError(t, err)
+	require.False(t, found)
+
+	_, _, err = queryRate(context.Background(), server.Client(), server.URL, "io.bitrise.other", crashRateMetricSet, crashRateMetric, []int64{1})
+	require.Error(t, err)
+}
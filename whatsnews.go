@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// downloadWhatsnews downloads the zip of what's new files from the given http(s) URL and extracts it to a temporary
+// directory. Returns the directory of the what's new files.
+func downloadWhatsnews(whatsnewsURL string) (string, error) {
+	tmpDir, err := ioutil.TempDir("", "google-play-deploy-whatsnews")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory, error: %s", err)
+	}
+
+	zipPth := filepath.Join(tmpDir, "whatsnews.zip")
+	log.Printf("Downloading %s", whatsnewsURL)
+	if err := downloadFileWithRetry(whatsnewsURL, zipPth, 3, 3); err != nil {
+		return "", fmt.Errorf("failed to download what's new files (%s), error: %s", whatsnewsURL, err)
+	}
+
+	dir, err := extractWhatsnews(zipPth, filepath.Join(tmpDir, "whatsnews"))
+	if err != nil {
+		return "", err
+	}
+	log.Printf(" extracted to %s", dir)
+	return dir, nil
+}
+
+// extractWhatsnews extracts the given zip of what's new files to the given directory. Returns the directory of the
+// what's new files: the single top level directory of the zip if the files are in one, the given directory otherwise.
+func extractWhatsnews(zipPth, dir string) (string, error) {
+	r, err := zip.OpenReader(zipPth)
+	if err != nil {
+		return "", fmt.Errorf("failed to open what's new zip (%s), error: %s", zipPth, err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Warnf("failed to close (%s)", zipPth)
+		}
+	}()
+
+	for _, f := range r.File {
+		pth := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(pth, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return "", fmt.Errorf("invalid file path in what's new zip: %s", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if err := extractZipFile(f, pth); err != nil {
+			return "", err
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read extracted what's new files, error: %s", err)
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+// extractZipFile writes the content of the given zip file to the given path.
+func extractZipFile(f *zip.File, pth string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s in what's new zip, error: %s", f.Name, err)
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			log.Warnf("failed to close %s in what's new zip", f.Name)
+		}
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return fmt.Errorf("failed to create directory of %s, error: %s", pth, err)
+	}
+	file, err := os.Create(pth)
+	if err != nil {
+		return fmt.Errorf("failed to create file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("failed to close (%s)", pth)
+		}
+	}()
+
+	if _, err := io.Copy(file, rc); err != nil {
+		return fmt.Errorf("failed to extract %s from what's new zip, error: %s", f.Name, err)
+	}
+	return nil
+}
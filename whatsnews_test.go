@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testZip returns a zip with the given files.
+func testZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func Test_extractWhatsnews(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "Test_extractWhatsnews")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}()
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantDir string
+		wantErr bool
+	}{
+		{name: "files at the root", files: map[string]string{"whatsnew-en-US": "English", "whatsnew-de-DE": "German"}},
+		{name: "single directory", files: map[string]string{"export/whatsnew-en-US": "English"}, wantDir: "export"},
+		{name: "invalid path", files: map[string]string{"../whatsnew-en-US": "English"}, wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zipPth := filepath.Join(tmpDir, "whatsnews.zip")
+			require.NoError(t, ioutil.WriteFile(zipPth, testZip(t, tt.files), 0644))
+			dir := filepath.Join(tmpDir, string(rune('a'+i)))
+
+			got, err := extractWhatsnews(zipPth, dir)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, filepath.Join(dir, tt.wantDir), got)
+
+			recentChanges, err := readLocalisedRecentChanges(got)
+			require.NoError(t, err)
+			require.Equal(t, "English", recentChanges["en-US"])
+		})
+	}
+}
+
+func Test_downloadWhatsnews(t *testing.T) {
+	content := testZip(t, map[string]string{"whatsnew-en-US": "English"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/whatsnews.zip" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err := w.Write(content)
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	dir, err := downloadWhatsnews(server.URL + "/whatsnews.zip")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(filepath.Dir(dir)))
+	}()
+	recentChanges, err := readLocalisedRecentChanges(dir)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"en-US": "English"}, recentChanges)
+}